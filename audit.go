@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditKeyEnv The environment variable the audit log key is read from when -auditKey is not given.
+const auditKeyEnv = "AUDIT_LOG_KEY"
+
+// Outcomes recorded in the audit log.
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// auditEntry One line of the audit log. Each entry holds the hash of the one before it, so an edit breaks the chain.
+// With a key the hashes are HMACs, so the chain cannot be rewritten by anyone without it.
+type auditEntry struct {
+	Seq      int       `json:"seq"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// auditHead Is kept beside the log and records the last entry, so removing entries from the end can be detected.
+type auditHead struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditLog An append-only JSONL file of every mutation made to IAM and every storage target written.
+type auditLog struct {
+	mu       sync.Mutex
+	filename string
+	actor    string
+	key      []byte
+	seq      int
+	last     string
+}
+
+// auditTrail Is what you use at runtime to record mutations, it is nil when the -auditLog flag is not set.
+var auditTrail *auditLog
+
+// callerIdentifier Gets the ARN of the current credentials.
+type callerIdentifier interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// auditedIamClient Records every mutating IAM call in the audit log.
+type auditedIamClient struct {
+	rotator.IAMClient
+	trail *auditLog
+}
+
+// auditedSSHClient Records every mutating SSH public key call in the audit log.
+type auditedSSHClient struct {
+	rotator.SSHClient
+	trail *auditLog
+}
+
+// auditedServiceClient Records every mutating service-specific credential call in the audit log.
+type auditedServiceClient struct {
+	rotator.ServiceCredentialClient
+	trail *auditLog
+}
+
+// auditedTagClient Records every change to the tags of the user in the audit log, such as locks and rotation metadata.
+type auditedTagClient struct {
+	rotator.TagClient
+	trail *auditLog
+}
+
+// getActorArn Get the ARN of the IAM user or role making the calls.
+func getActorArn(ctx context.Context, client callerIdentifier) (string, error) {
+	gcio, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf(errors.auditActorErr, err.Error())
+	}
+
+	return aws.ToString(gcio.Arn), nil
+}
+
+// auditKey Get the key the audit log is signed with, from -auditKey or else the environment, so -help does not show
+// it. It is nil when neither is set.
+func auditKey(ac *applicationFlags) []byte {
+	key := *ac.auditKey
+	if key == "" {
+		key = os.Getenv(auditKeyEnv)
+	}
+
+	if key == "" {
+		return nil
+	}
+
+	return []byte(key)
+}
+
+// openAuditTrail Open the audit log named by -auditLog as the auditTrail, recording the ARN of the credentials of the
+// AWS config as the actor. The auditTrail is left nil when the flag is not set.
+func openAuditTrail(ctx context.Context, ac *applicationFlags, awsConfig aws.Config) error {
+	auditTrail = nil
+	if *ac.auditLog == "" {
+		return nil
+	}
+
+	actor, err := getActorArn(ctx, sts.NewFromConfig(awsConfig))
+	if err != nil {
+		return err
+	}
+
+	auditTrail, err = openAuditLog(*ac.auditLog, actor, auditKey(ac))
+
+	return err
+}
+
+// headFilename Get the path of the file that records the last entry.
+func headFilename(filename string) string {
+	return filename + ".head"
+}
+
+// openAuditLog Open an audit log for appending, continuing the chain from the last entry in the file. Entries are
+// signed with key when it is not nil.
+func openAuditLog(filename, actor string, key []byte) (*auditLog, error) {
+	al := &auditLog{filename: filename, actor: actor, key: key}
+
+	entries, err := readAuditEntries(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf(errors.auditReadErr, err.Error())
+	}
+
+	if len(entries) > 0 {
+		al.seq = entries[len(entries)-1].Seq
+		al.last = entries[len(entries)-1].Hash
+	}
+
+	return al, nil
+}
+
+// hashEntry Calculate the hash of an entry, which covers every field except the hash itself. With a key it is an
+// HMAC-SHA256 of the entry.
+func hashEntry(e auditEntry, key []byte) string {
+	e.Hash = ""
+	content, _ := json.Marshal(e)
+
+	if key == nil {
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// record Append an entry to the audit log, err is the outcome of the action. Safe to call on a nil log.
+func (al *auditLog) record(action, target string, err error) error {
+	if al == nil {
+		return nil
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	e := auditEntry{
+		Seq:      al.seq + 1,
+		Time:     time.Now().UTC(),
+		Actor:    al.actor,
+		Action:   action,
+		Target:   target,
+		Outcome:  auditSuccess,
+		PrevHash: al.last,
+	}
+
+	if err != nil {
+		e.Outcome = auditFailure
+		e.Error = rotator.Redact(err.Error())
+	}
+
+	e.Hash = hashEntry(e, al.key)
+
+	line, _ := json.Marshal(e)
+
+	f, err1 := os.OpenFile(al.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err1 != nil {
+		return fmt.Errorf(errors.auditWriteErr, err1.Error())
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf(errors.auditWriteErr, err.Error())
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf(errors.auditWriteErr, err.Error())
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(errors.auditWriteErr, err.Error())
+	}
+
+	al.seq = e.Seq
+	al.last = e.Hash
+
+	head, _ := json.Marshal(auditHead{e.Seq, e.Hash})
+	if err := writeFileAtomic(headFilename(al.filename), head, 0600); err != nil {
+		return fmt.Errorf(errors.auditWriteErr, err.Error())
+	}
+
+	return nil
+}
+
+// recordOrLog Record an entry, logging when it cannot be written. The action already happened, so it must not fail.
+func (al *auditLog) recordOrLog(action, target string, err error) {
+	if e := al.record(action, target, err); e != nil {
+		appLog.Error(e.Error(), "action", action)
+	}
+}
+
+// readAuditEntries Read every entry in the audit log.
+func readAuditEntries(filename string) ([]auditEntry, error) {
+	content, err1 := ioutil.ReadFile(filename)
+	if err1 != nil {
+		return nil, err1
+	}
+
+	entries := make([]auditEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		e := auditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf(errors.auditLineInvalid, n, err.Error())
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// verifyAuditLog Check every entry is intact, in order, chained to the one before, and that none were removed from the end.
+// The key must be the one the entries were signed with, nil when they were not.
+func verifyAuditLog(filename string, key []byte) (int, error) {
+	entries, err1 := readAuditEntries(filename)
+	if err1 != nil {
+		return 0, fmt.Errorf(errors.auditReadErr, err1.Error())
+	}
+
+	prev := ""
+	for i, e := range entries {
+		if e.Seq != i+1 {
+			return i, withKind(rotator.ErrVerificationFailed, fmt.Errorf(errors.auditSeqErr, i+1, e.Seq))
+		}
+
+		if e.PrevHash != prev {
+			return i, withKind(rotator.ErrVerificationFailed, fmt.Errorf(errors.auditChainErr, e.Seq))
+		}
+
+		if !hmac.Equal([]byte(hashEntry(e, key)), []byte(e.Hash)) {
+			return i, withKind(rotator.ErrVerificationFailed, fmt.Errorf(errors.auditHashErr, e.Seq))
+		}
+
+		prev = e.Hash
+	}
+
+	content, err2 := ioutil.ReadFile(headFilename(filename))
+	if err2 != nil {
+		return len(entries), fmt.Errorf(errors.auditHeadErr, err2.Error())
+	}
+
+	head := auditHead{}
+	if err := json.Unmarshal(content, &head); err != nil {
+		return len(entries), fmt.Errorf(errors.auditHeadErr, err.Error())
+	}
+
+	if head.Seq != len(entries) || head.Hash != prev {
+		return len(entries), withKind(rotator.ErrVerificationFailed, fmt.Errorf(errors.auditTruncatedErr, head.Seq, len(entries)))
+	}
+
+	return len(entries), nil
+}
+
+// writeFileAtomic Write to a temporary file in the same directory then rename it, so readers never see a partial file.
+func writeFileAtomic(filename string, content []byte, perm os.FileMode) error {
+	tmp, err1 := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err1 != nil {
+		return err1
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (c *auditedIamClient) CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error) {
+	out, err := c.IAMClient.CreateAccessKey(ctx, params, optFns...)
+
+	target := aws.ToString(params.UserName)
+	if out != nil && out.AccessKey != nil {
+		target = aws.ToString(out.AccessKey.AccessKeyId)
+	}
+	c.trail.recordOrLog("iam:CreateAccessKey", target, err)
+
+	return out, err
+}
+
+func (c *auditedIamClient) DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error) {
+	out, err := c.IAMClient.DeleteAccessKey(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:DeleteAccessKey", aws.ToString(params.AccessKeyId), err)
+
+	return out, err
+}
+
+func (c *auditedSSHClient) UploadSSHPublicKey(ctx context.Context, params *iam.UploadSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UploadSSHPublicKeyOutput, error) {
+	out, err := c.SSHClient.UploadSSHPublicKey(ctx, params, optFns...)
+
+	target := aws.ToString(params.UserName)
+	if out != nil && out.SSHPublicKey != nil {
+		target = aws.ToString(out.SSHPublicKey.SSHPublicKeyId)
+	}
+	c.trail.recordOrLog("iam:UploadSSHPublicKey", target, err)
+
+	return out, err
+}
+
+func (c *auditedSSHClient) UpdateSSHPublicKey(ctx context.Context, params *iam.UpdateSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateSSHPublicKeyOutput, error) {
+	out, err := c.SSHClient.UpdateSSHPublicKey(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:UpdateSSHPublicKey:"+string(params.Status), aws.ToString(params.SSHPublicKeyId), err)
+
+	return out, err
+}
+
+func (c *auditedSSHClient) DeleteSSHPublicKey(ctx context.Context, params *iam.DeleteSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteSSHPublicKeyOutput, error) {
+	out, err := c.SSHClient.DeleteSSHPublicKey(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:DeleteSSHPublicKey", aws.ToString(params.SSHPublicKeyId), err)
+
+	return out, err
+}
+
+func (c *auditedServiceClient) CreateServiceSpecificCredential(ctx context.Context, params *iam.CreateServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.CreateServiceSpecificCredentialOutput, error) {
+	out, err := c.ServiceCredentialClient.CreateServiceSpecificCredential(ctx, params, optFns...)
+
+	target := aws.ToString(params.UserName)
+	if out != nil && out.ServiceSpecificCredential != nil {
+		target = aws.ToString(out.ServiceSpecificCredential.ServiceSpecificCredentialId)
+	}
+	c.trail.recordOrLog("iam:CreateServiceSpecificCredential", target, err)
+
+	return out, err
+}
+
+func (c *auditedServiceClient) ResetServiceSpecificCredential(ctx context.Context, params *iam.ResetServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.ResetServiceSpecificCredentialOutput, error) {
+	out, err := c.ServiceCredentialClient.ResetServiceSpecificCredential(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:ResetServiceSpecificCredential", aws.ToString(params.ServiceSpecificCredentialId), err)
+
+	return out, err
+}
+
+func (c *auditedServiceClient) DeleteServiceSpecificCredential(ctx context.Context, params *iam.DeleteServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.DeleteServiceSpecificCredentialOutput, error) {
+	out, err := c.ServiceCredentialClient.DeleteServiceSpecificCredential(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:DeleteServiceSpecificCredential", aws.ToString(params.ServiceSpecificCredentialId), err)
+
+	return out, err
+}
+
+func (c *auditedTagClient) TagUser(ctx context.Context, params *iam.TagUserInput, optFns ...func(*iam.Options)) (*iam.TagUserOutput, error) {
+	out, err := c.TagClient.TagUser(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:TagUser", aws.ToString(params.UserName), err)
+
+	return out, err
+}
+
+func (c *auditedTagClient) UntagUser(ctx context.Context, params *iam.UntagUserInput, optFns ...func(*iam.Options)) (*iam.UntagUserOutput, error) {
+	out, err := c.TagClient.UntagUser(ctx, params, optFns...)
+	c.trail.recordOrLog("iam:UntagUser", aws.ToString(params.UserName), err)
+
+	return out, err
+}
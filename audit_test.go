@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type mockStsClient struct {
+	arn string
+}
+
+func (msc *mockStsClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if msc.arn == "" {
+		return nil, fmt.Errorf("a test error occurred")
+	}
+
+	return &sts.GetCallerIdentityOutput{Arn: &msc.arn}, nil
+}
+
+// writeTestAuditLog Write an audit log with 3 entries, signed with key when it is not nil, and return its path.
+func writeTestAuditLog(t *testing.T, name string, key []byte) string {
+	filename := testTmp + "/" + name + ".jsonl"
+	_ = os.Remove(filename)
+	_ = os.Remove(headFilename(filename))
+
+	al, err1 := openAuditLog(filename, "arn:aws:iam::123456789012:user/bob", key)
+	if err1 != nil {
+		t.Fatalf("unexpected error: %v", err1)
+	}
+
+	_ = al.record("iam:CreateAccessKey", "AKIANEW", nil)
+	_ = al.record("store:circleci", "AKIANEW", fmt.Errorf("failed to update context"))
+	_ = al.record("iam:DeleteAccessKey", "AKIAOLD", nil)
+
+	return filename
+}
+
+func TestAuditLogVerify(tester *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func(lines []string) []string
+		want   string
+	}{
+		{"intact", func(lines []string) []string { return lines }, ""},
+		{"edited", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"outcome":"failure"`, `"outcome":"success"`, 1)
+			return lines
+		}, "was edited"},
+		{"removed", func(lines []string) []string { return append(lines[:1], lines[2:]...) }, "out of sequence"},
+		{"truncated", func(lines []string) []string { return lines[:2] }, "was truncated"},
+		{"reordered", func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, "out of sequence"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			filename := writeTestAuditLog(t, "audit-"+test.name, nil)
+
+			content, _ := ioutil.ReadFile(filename)
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			_ = ioutil.WriteFile(filename, []byte(strings.Join(test.tamper(lines), "\n")+"\n"), 0600)
+
+			_, err := verifyAuditLog(filename, nil)
+			if test.want == "" && err != nil {
+				t.Errorf("want no error, got %v", err)
+			}
+
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("want error containing %q, got %v", test.want, err)
+			}
+
+			if test.want != "" && exitCode(err, false) != exitVerificationFailed {
+				t.Errorf("want exit code %v, got %v", exitVerificationFailed, exitCode(err, false))
+			}
+		})
+	}
+}
+
+func TestAuditLogContinuesChain(tester *testing.T) {
+	filename := writeTestAuditLog(tester, "audit-continue", nil)
+
+	al, err1 := openAuditLog(filename, "arn:aws:iam::123456789012:user/bob", nil)
+	if err1 != nil {
+		tester.Fatalf("unexpected error: %v", err1)
+	}
+
+	_ = al.record("iam:CreateAccessKey", "AKIANEXT", nil)
+
+	n, err := verifyAuditLog(filename, nil)
+	if err != nil {
+		tester.Errorf("want no error, got %v", err)
+	}
+
+	if n != 4 {
+		tester.Errorf("want 4 entries, got %v", n)
+	}
+}
+
+func TestAuditLogVerifyKeyed(tester *testing.T) {
+	key := []byte("s3cret")
+
+	cases := []struct {
+		name    string
+		key     []byte
+		rewrite bool
+		want    string
+	}{
+		{"sameKey", key, false, ""},
+		{"otherKey", []byte("guess"), false, "was edited"},
+		{"noKey", nil, false, "was edited"},
+		// The whole chain and head are made again without the key after an edit, which only a key catches.
+		{"rewritten", key, true, "was edited"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			filename := writeTestAuditLog(t, "audit-keyed-"+test.name, key)
+
+			if test.rewrite {
+				entries, _ := readAuditEntries(filename)
+				entries[1].Outcome = auditSuccess
+				entries[1].Error = ""
+
+				lines, prev := "", ""
+				for _, e := range entries {
+					e.PrevHash = prev
+					e.Hash = hashEntry(e, nil)
+					prev = e.Hash
+					line, _ := json.Marshal(e)
+					lines += string(line) + "\n"
+				}
+				head, _ := json.Marshal(auditHead{len(entries), prev})
+				_ = ioutil.WriteFile(filename, []byte(lines), 0600)
+				_ = ioutil.WriteFile(headFilename(filename), head, 0600)
+			}
+
+			_, err := verifyAuditLog(filename, test.key)
+			if test.want == "" && err != nil {
+				t.Errorf("want no error, got %v", err)
+			}
+
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("want error containing %q, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestAuditKey(tester *testing.T) {
+	cases := []struct {
+		name string
+		flag string
+		env  string
+		want []byte
+	}{
+		{"unset", "", "", nil},
+		{"fromFlag", "flag-key", "env-key", []byte("flag-key")},
+		{"fromEnv", "", "env-key", []byte("env-key")},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			t.Setenv(auditKeyEnv, test.env)
+
+			got := auditKey(&applicationFlags{auditKey: &test.flag})
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAuditedIamClient(tester *testing.T) {
+	filename := testTmp + "/audit-client.jsonl"
+	_ = os.Remove(filename)
+	al, _ := openAuditLog(filename, "arn:aws:iam::123456789012:user/bob", nil)
+	client := &auditedIamClient{&mockIamClient{}, al}
+
+	s1 := "ABC123"
+	s2 := "DERR"
+	_, _ = client.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{})
+	_, _ = client.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{AccessKeyId: &s1})
+	_, _ = client.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{AccessKeyId: &s2})
+
+	entries, err := readAuditEntries(filename)
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct{ action, target, outcome string }{
+		{"iam:CreateAccessKey", "test1234", auditSuccess},
+		{"iam:DeleteAccessKey", s1, auditSuccess},
+		{"iam:DeleteAccessKey", s2, auditFailure},
+	}
+
+	if len(entries) != len(want) {
+		tester.Fatalf("want %v entries, got %v", len(want), len(entries))
+	}
+
+	for i, w := range want {
+		e := entries[i]
+		if e.Action != w.action || e.Target != w.target || e.Outcome != w.outcome || e.Actor == "" {
+			tester.Errorf("entry %v: want %+v, got %+v", i, w, e)
+		}
+	}
+}
+
+func TestGetActorArn(tester *testing.T) {
+	cases := []struct {
+		name  string
+		arn   string
+		throw bool
+	}{
+		{"found", "arn:aws:iam::123456789012:user/bob", false},
+		{"fails", "", true},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			got, err := getActorArn(context.TODO(), &mockStsClient{test.arn})
+
+			if (err != nil) != test.throw {
+				t.Errorf("want error %v, got %v", test.throw, err)
+			}
+
+			if got != test.arn {
+				t.Errorf("want %q, got %q", test.arn, got)
+			}
+		})
+	}
+}
+
+func TestRunSubcommandAuditVerify(tester *testing.T) {
+	filename := writeTestAuditLog(tester, "audit-subcommand", nil)
+	empty := ""
+
+	cases := []struct {
+		name  string
+		args  []string
+		log   *string
+		throw bool
+	}{
+		{"verify", []string{"audit", "verify"}, &filename, false},
+		{"no_log", []string{"audit", "verify"}, &empty, true},
+		{"unknown", []string{"audit", "nope"}, &filename, true},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			defer quiet()()
+
+			_, err := runSubcommand(context.TODO(), test.args, &applicationFlags{auditKey: &empty, auditLog: test.log})
+			if (err != nil) != test.throw {
+				t.Errorf("want error %v, got %v", test.throw, err)
+			}
+		})
+	}
+}
+
+func TestRotateLockAudited(tester *testing.T) {
+	keyFile, auditFile := testTmp+"/fake-iam-lock-audited.json", testTmp+"/fake-iam-lock-audit.jsonl"
+	_ = os.Remove(auditFile)
+
+	cmd := getTestBinCmd([]string{"-region", "us-east-1", "-circleci", "1234", "-circleciContext", "ctx-id", "-filename", keyFile, "-lock", "-auditLog", auditFile, "-detailedExitCodes"})
+	cmd.Env = append(cmd.Env, fakeIamEnv+"=")
+
+	cmdOut, cmdErr := cmd.CombinedOutput()
+	if got := cmd.ProcessState.ExitCode(); got != exitRotated {
+		showCmdOutput(cmdOut, cmdErr)
+		tester.Fatalf("want exit code %v, got %v", exitRotated, got)
+	}
+
+	entries, err := readAuditEntries(auditFile)
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, e.Action)
+	}
+
+	want := "iam:TagUser iam:CreateAccessKey store:file store:circleci iam:DeleteAccessKey iam:TagUser iam:UntagUser"
+	if strings.Join(got, " ") != want {
+		tester.Errorf("want actions %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// sendJSON Make a request to the API of a CI service with the payload as JSON, any response other than 2xx is an
+// error. The auth is the value of the authorization header, service names the API in errors.
+func sendJSON(ctx context.Context, hc httpCommunicator, service, method, reqUrl, auth string, payload interface{}) ([]byte, error) {
+	var content []byte
+	if payload != nil {
+		var err error
+		if content, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err1 := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(content))
+	if err1 != nil {
+		return nil, err1
+	}
+
+	req.Header.Add("accept", "application/json")
+	req.Header.Add("authorization", auth)
+	if payload != nil {
+		req.Header.Add("content-type", "application/json")
+	}
+
+	_, body, err2 := doRequest(hc, service, req)
+
+	return body, err2
+}
+
+// doRequest Make a request to the API of a CI service and read the response, any response other than 2xx is an error.
+func doRequest(hc httpCommunicator, service string, req *http.Request) (*http.Response, []byte, error) {
+	res, err1 := hc.Do(req)
+	if err1 != nil {
+		return nil, nil, fmt.Errorf(errors.ciRequestErr, err1)
+	}
+
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res, nil, rotator.NewStatusError(res, fmt.Errorf(errors.ciResponseErr, service, req.Method, res.StatusCode, string(body)))
+	}
+
+	return res, body, nil
+}
+
+// basicAuth Get the authorization header value for HTTP basic auth.
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// ciOutputs What a CI variable can be set to by name, as a template.
+var ciOutputs = map[string]string{
+	"key":          "{{.AccessKeyId}}",
+	"secret":       "{{.SecretAccessKey}}",
+	"user":         "{{.UserName}}",
+	"region":       "{{.Region}}",
+	"rotatedAt":    "{{.RotatedAt}}",
+	"smtpPassword": "{{.SMTPPassword}}",
+}
+
+// ciVarRe Finds the start of each NAME=value entry of a -*Vars flag, so a template may hold commas.
+var ciVarRe = regexp.MustCompile(`(^|,)\s*[A-Za-z_][A-Za-z0-9_]*=`)
+
+// keyOutputs What the value of a CI variable is made from.
+type keyOutputs struct {
+	AccessKeyId,
+	SecretAccessKey,
+	UserName,
+	Region,
+	RotatedAt,
+	SMTPPassword string
+	// SMTPPasswords The SES SMTP password of each region of -sesSmtpRegions, SMTPPassword is the one of the first.
+	SMTPPasswords map[string]string
+}
+
+// ciVar A variable saved to a CI service, its value made from the new key by a template.
+type ciVar struct {
+	name string
+	tmpl *template.Template
+}
+
+// ciVars The variables saved to a CI service. With none given, the key ID and secret are saved as keyVarName and
+// secretVarName, along with the SES SMTP user name and passwords when smtp is set.
+type ciVars struct {
+	list   []ciVar
+	region string
+	smtp   *sesSmtp
+}
+
+// parseCiVars Read the value of a -*Vars flag, comma separated NAME=value entries. A value is the name of an output in
+// ciOutputs, or a Go template of the fields of keyOutputs.
+func parseCiVars(flagName, value, region string) (*ciVars, error) {
+	cv := &ciVars{region: region}
+
+	starts := ciVarRe.FindAllStringIndex(value, -1)
+	if strings.TrimSpace(value) != "" && (len(starts) == 0 || starts[0][0] != 0) {
+		return nil, fmt.Errorf(errors.ciVarsInvalid, flagName, value)
+	}
+
+	seen := map[string]bool{}
+	for n, start := range starts {
+		end := len(value)
+		if n+1 < len(starts) {
+			end = starts[n+1][0]
+		}
+
+		entry := strings.SplitN(strings.TrimLeft(value[start[0]:end], ", \t"), "=", 2)
+		name, text := entry[0], strings.TrimSpace(entry[1])
+
+		if out, ok := ciOutputs[text]; ok {
+			text = out
+		} else if !strings.Contains(text, "{{") {
+			return nil, fmt.Errorf(errors.ciVarOutputInvalid, flagName, name, text)
+		}
+
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf(errors.ciVarTemplateInvalid, flagName, name, err)
+		}
+
+		// Catch a template that uses a field there is not now, rather than after a new key is made.
+		if err := tmpl.Execute(ioutil.Discard, &keyOutputs{}); err != nil {
+			return nil, fmt.Errorf(errors.ciVarTemplateInvalid, flagName, name, err)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf(errors.ciVarsInvalid, flagName, value)
+		}
+		seen[name] = true
+
+		cv.list = append(cv.list, ciVar{name, tmpl})
+	}
+
+	return cv, nil
+}
+
+// usesSecret Indicates a variable is set to the secret access key, by secret or a template of it.
+func (cv *ciVars) usesSecret() bool {
+	out := &keyOutputs{SecretAccessKey: "\x00secret\x00"}
+	for _, v := range cv.list {
+		b := &strings.Builder{}
+		if err := v.tmpl.Execute(b, out); err == nil && strings.Contains(b.String(), out.SecretAccessKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// values Get the variables to save the new key as, by name.
+func (cv *ciVars) values(creds *iam.CreateAccessKeyOutput) (map[string]string, error) {
+	rotatedAt := aws.ToTime(creds.AccessKey.CreateDate)
+	if rotatedAt.IsZero() {
+		rotatedAt = time.Now()
+	}
+
+	out := &keyOutputs{
+		AccessKeyId:     aws.ToString(creds.AccessKey.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.AccessKey.SecretAccessKey),
+		UserName:        aws.ToString(creds.AccessKey.UserName),
+		RotatedAt:       rotatedAt.UTC().Format(time.RFC3339),
+	}
+
+	if cv == nil {
+		return map[string]string{keyVarName: out.AccessKeyId, secretVarName: out.SecretAccessKey}, nil
+	}
+
+	if len(cv.list) == 0 {
+		vals := map[string]string{}
+		for _, v := range newKeyPair(creds.AccessKey, cv.smtp).envVars() {
+			vals[v.name] = v.value
+		}
+
+		return vals, nil
+	}
+
+	out.Region = cv.region
+	if cv.smtp != nil {
+		out.SMTPPasswords = cv.smtp.passwords(out.SecretAccessKey)
+		out.SMTPPassword = out.SMTPPasswords[cv.smtp.regions[0]]
+	}
+
+	vals := make(map[string]string, len(cv.list))
+	for _, v := range cv.list {
+		b := &strings.Builder{}
+		if err := v.tmpl.Execute(b, out); err != nil {
+			return nil, fmt.Errorf(errors.ciVarValueErr, v.name, err)
+		}
+		vals[v.name] = b.String()
+	}
+
+	return vals, nil
+}
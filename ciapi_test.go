@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustCiVars Parse the value of a -*Vars flag, for the region us-east-1.
+func mustCiVars(value string) *ciVars {
+	vars, err := parseCiVars("testVars", value, "us-east-1")
+	if err != nil {
+		panic(err)
+	}
+
+	return vars
+}
+
+func TestCiVars(tester *testing.T) {
+	creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+		AccessKeyId:     aws.String("AKIANEW"),
+		SecretAccessKey: aws.String("new-secret"),
+		UserName:        aws.String("bob"),
+		CreateDate:      aws.Time(time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)),
+	}}
+
+	cases := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr string
+	}{
+		{"default", "", map[string]string{keyVarName: "AKIANEW", secretVarName: "new-secret"}, ""},
+		{
+			"outputs", "DEPLOY_KEY_ID=key, DEPLOY_SECRET=secret,AWS_DEFAULT_REGION=region,DEPLOY_USER=user,ROTATED_AT=rotatedAt",
+			map[string]string{"DEPLOY_KEY_ID": "AKIANEW", "DEPLOY_SECRET": "new-secret", "AWS_DEFAULT_REGION": "us-east-1", "DEPLOY_USER": "bob", "ROTATED_AT": "2022-01-31T01:00:00Z"},
+			"",
+		},
+		{
+			"templates", `AWS_ACCESS_KEY_ID=key,AWS_CREDS={{.AccessKeyId}},{{.SecretAccessKey}},NOTE={{printf "%v in %v" .UserName .Region}}`,
+			map[string]string{keyVarName: "AKIANEW", "AWS_CREDS": "AKIANEW,new-secret", "NOTE": "bob in us-east-1"},
+			"",
+		},
+		{"unknownOutput", "AWS_ACCESS_KEY_ID=secert", nil, `sets AWS_ACCESS_KEY_ID to "secert"`},
+		{"unknownField", "AWS_ACCESS_KEY_ID={{.KeyId}}", nil, "bad template for AWS_ACCESS_KEY_ID"},
+		{"badTemplate", "AWS_ACCESS_KEY_ID={{.AccessKeyId", nil, "bad template for AWS_ACCESS_KEY_ID"},
+		{"noName", "key", nil, "must be comma separated NAME=value entries"},
+		{"twice", "A=key,A=secret", nil, "each name only once"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			vars, err := parseCiVars("testVars", test.value, "us-east-1")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := vars.values(creds)
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("want %v, got %v, %v", test.want, got, err)
+			}
+		})
+	}
+}
@@ -1,7 +1,29 @@
 package main
 
 var stdMsgs = struct {
-	expireKey string
+	auditVerified,
+	exiting,
+	expireKey,
+	jenkinsDescription,
+	keyAboutToExpire,
+	keyRotated,
+	removedKeyFile,
+	rolledBack,
+	serviceCredentialRotated,
+	sshKeyRotated,
+	stopping,
+	tfcPlanMessage string
 }{
-	expireKey: "current IAM key has expired, making a new key",
+	auditVerified:            "audit log verified, no entries were changed or removed",
+	exiting:                  "exiting",
+	expireKey:                "current IAM key has expired, making a new key",
+	jenkinsDescription:       "AWS access key rotated by iam-user-key-rotator",
+	keyAboutToExpire:         "current IAM key is %v days old and will be rotated after %v days",
+	keyRotated:               "made a new key to replace %v",
+	removedKeyFile:           "removed the local key file, the key was saved to the other storage targets",
+	rolledBack:               "the new key was deleted because it could not be saved: %v",
+	serviceCredentialRotated: "made a new service-specific credential, sign in to %v with its service user name and password",
+	sshKeyRotated:            "made a new SSH key pair, connect to CodeCommit as the SSH key ID",
+	stopping:                 "stopping, a signal was received",
+	tfcPlanMessage:           "Checking a new AWS access key made by iam-user-key-rotator",
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io"
+)
+
+// runCredentialProcess Act as an AWS credential_process program. The current key is read from the key file, rotated
+// when it has expired, and printed to out in the format the AWS SDKs expect.
+func runCredentialProcess(ctx context.Context, ac *applicationFlags, out io.Writer) error {
+	// The next run signs in with the key in the file, so it must keep the secret.
+	if *ac.sesSmtpOnly {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.sesSmtpOnlyConflict, "the credential-process subcommand"))
+	}
+
+	if err := ac.check(); err != nil {
+		return err
+	}
+
+	// The key file is where the current key is kept, so it must be kept and readable.
+	if !*ac.keepFile {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.credentialProcessNeedsFile))
+	}
+
+	if *ac.fileFormat != "json" && *ac.fileFormat != "credential_process" {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.credentialProcessFormat, *ac.fileFormat))
+	}
+
+	current, err1 := readKeyFile(*ac.filename, *ac.ageIdentity)
+	if err1 != nil {
+		return err1
+	}
+
+	ctx, cancel := withTimeout(ctx, *ac.timeout)
+	defer cancel()
+
+	awsConfig, err2 := getAwsConfig(ctx, ac)
+	if err2 != nil {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.awsConfigErr, err2))
+	}
+
+	// Use the key from the file, not whatever the default credential chain finds, which may well be this program.
+	awsConfig.Credentials = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(current.Id, current.Key, ""))
+
+	// Never save to the local profile, static keys there would take the place of this credential_process. The key file
+	// holds the only copy of the current key, so it is written last, once every other store has the new key. When one
+	// of them fails the new key is deleted, and the file still holds the current key.
+	stores := newStores(ac, httpComm, false)
+	stores = append(stores[1:], stores[0])
+
+	newKey, err3 := rotate(ctx, ac, &awsConfig, stores)
+	if err3 != nil {
+		return err3
+	}
+
+	cpo := credentialProcessOutput{Version: 1, AccessKeyId: current.Id, SecretAccessKey: current.Key}
+	if newKey != nil {
+		cpo.AccessKeyId = aws.ToString(newKey.AccessKeyId)
+		cpo.SecretAccessKey = aws.ToString(newKey.SecretAccessKey)
+	}
+
+	return json.NewEncoder(out).Encode(cpo)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCredentialProcessChecks(tester *testing.T) {
+	region := "us-east-2"
+	yes, no := true, false
+	json, csv := "json", "csv"
+	missing := testTmp + "/missing-key.json"
+
+	cases := []struct {
+		name     string
+		keepFile *bool
+		format   *string
+		want     string
+	}{
+		{"no_file", &no, &json, "-keepFile must be true"},
+		{"bad_format", &yes, &csv, "needs a -fileFormat of json or credential_process"},
+		{"missing_file", &yes, &json, "could not read the key file"},
+		{"ses_smtp_only", &yes, &json, "which the credential-process subcommand needs"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := *appFlags
+			ac.region = &region
+			ac.keepFile = test.keepFile
+			ac.fileFormat = test.format
+			ac.filename = &missing
+			smtpOnly := test.name == "ses_smtp_only"
+			ac.sesSmtpOnly = &smtpOnly
+			out := &bytes.Buffer{}
+
+			err := runCredentialProcess(context.TODO(), &ac, out)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("want error containing %q, got %v", test.want, err)
+			}
+
+			if out.Len() != 0 {
+				t.Errorf("want nothing printed on error, got %q", out.String())
+			}
+		})
+	}
+}
+
+func TestRunCredentialProcessWithFakeIam(tester *testing.T) {
+	region, keyFile, format, circleci, contextId := "us-east-1", testTmp+"/credential-process-key.json", "json", "1234", "ctx-id"
+	yes := true
+	newKeyId := "AKIAFAKE000000000001"
+
+	cases := []struct {
+		name      string
+		days      int
+		ciFails   bool
+		wantErr   error
+		wantOut   string
+		wantFile  string
+		wantCount int
+	}{
+		{"valid", 5, false, nil, fakeKeyId, fakeKeyId, 1},
+		{"expired", 45, false, nil, newKeyId, newKeyId, 1},
+		// The new key is deleted again, and the key file still holds the current key.
+		{"ciFails", 45, true, rotator.ErrStorageFailed, "", fakeKeyId, 1},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			secret := f.AddKey("bob", fakeKeyId, time.Now().AddDate(0, 0, -test.days), types.StatusTypeActive)
+			srv := iamfake.NewServer(f)
+			defer srv.Close()
+
+			optFns = iamfake.LoadOptions(srv.URL, fakeKeyId, secret)
+			httpComm = &mockHttpClient{0}
+			if test.ciFails {
+				httpComm = &mockHttpClient{1}
+			}
+			defer func() { optFns, httpComm = nil, nil }()
+
+			content, _ := json.Marshal(awsKeyPair{Id: fakeKeyId, Key: secret, Username: "bob"})
+			if err := ioutil.WriteFile(keyFile, content, keyFileMode); err != nil {
+				t.Fatal(err)
+			}
+
+			ac := *appFlags
+			ac.region = &region
+			ac.keepFile = &yes
+			ac.fileFormat = &format
+			ac.filename = &keyFile
+			ac.circleci = &circleci
+			ac.circleciContext = &contextId
+			out := &bytes.Buffer{}
+
+			err := runCredentialProcess(context.TODO(), &ac, out)
+			if !stderrors.Is(err, test.wantErr) {
+				t.Fatalf("want error %v, got %v", test.wantErr, err)
+			}
+
+			cpo := credentialProcessOutput{}
+			_ = json.Unmarshal(out.Bytes(), &cpo)
+			if cpo.AccessKeyId != test.wantOut {
+				t.Errorf("want key %q printed, got %q", test.wantOut, out.String())
+			}
+
+			kp, _ := readKeyFile(keyFile, "")
+			if kp == nil || kp.Id != test.wantFile || kp.Key == "" {
+				t.Errorf("want key %v in the key file, got %+v", test.wantFile, kp)
+			}
+
+			if n := len(f.Keys("bob")); n != test.wantCount {
+				t.Errorf("want %v keys left in IAM, got %v", test.wantCount, n)
+			}
+		})
+	}
+}
@@ -1,15 +1,151 @@
 package main
 
 var errors = struct {
+	ageIdentityInvalid,
+	ageIdentityMissing,
+	ageRecipientInvalid,
+	auditActorErr,
+	auditChainErr,
+	auditHashErr,
+	auditHeadErr,
+	auditLineInvalid,
+	auditLogMissing,
+	auditReadErr,
+	auditSeqErr,
+	auditTruncatedErr,
+	auditWriteErr,
+	awsConfigErr,
+	azureFlagsMissing,
+	azureGroupNotFound,
+	bitbucketAuthMissing,
+	bitbucketEnvNeedsRepo,
+	bitbucketEnvNotFound,
+	buildkiteFlagsMissing,
+	ciRequestErr,
+	ciResponseErr,
+	ciResponseInvalid,
+	ciVarOutputInvalid,
+	ciVarTemplateInvalid,
+	ciVarValueErr,
+	circleciContextMissing,
+	ciVarsInvalid,
+	credentialProcessFormat,
+	currentKeyIdErr,
+	credentialProcessNeedsFile,
+	decryptKeyErr,
+	droneFlagsMissing,
+	emailFromMissing,
+	emailNoRecipients,
+	emailTemplateErr,
+	encryptKeyErr,
+	fileFormatInvalid,
+	intervalInvalid,
+	jenkinsFlagsMissing,
+	jenkinsKindInvalid,
+	keyFileNotEncrypted,
+	lockInvalid,
+	logFormatInvalid,
+	logLevelInvalid,
+	metricsTextfileErr,
+	notifyErr,
+	panicked,
 	probMakingNewKey,
+	readingKeyFileErr,
 	regionMissing,
+	removingKeyFileErr,
+	retryInvalid,
+	rollbackErr,
+	sesSmtpNotForService,
+	serviceResetNeedsTags,
+	sesSmtpOnlyConflict,
+	sesSmtpOnlyNeedsCi,
+	sesSmtpOnlyNeedsRegions,
+	sesSmtpRegionInvalid,
+	sshKeyTypeInvalid,
+	tfcFlagsMissing,
+	tfcPlanFailed,
+	tfcPlanNeedsWorkspace,
+	tfcVarSetNotFound,
+	timeoutInvalid,
 	translateKeyToJsonErr,
-	updateCiContextErr,
-	writingNewKeyErr string
+	travisFlagsMissing,
+	unknownSubcommand,
+	userNameErr,
+	webhookResponseErr,
+	writingNewKeyErr,
+	writingSSHKeyErr string
 }{
-	regionMissing:         "the -region flag is required and must not be an empty string",
-	translateKeyToJsonErr: "problem translating the new access key to JSON: %v",
-	updateCiContextErr:    "failed to update context: %v",
-	writingNewKeyErr:      "problem writing the new access key to a file: %v",
-	probMakingNewKey:      "problem with making a new access key: %v",
+	ageIdentityInvalid:         "could not read the age identity file: %v",
+	ageIdentityMissing:         "the -ageIdentity flag is required to decrypt the key file",
+	ageRecipientInvalid:        "the -ageRecipient flag is not a valid list of age recipients: %v",
+	auditActorErr:              "could not get the ARN of the current credentials for the audit log: %v",
+	auditChainErr:              "audit log entry %v does not follow the entry before it, entries were edited or removed",
+	auditHashErr:               "audit log entry %v was edited, or signed with another -auditKey, its hash does not match",
+	auditHeadErr:               "could not read the audit log head file: %v",
+	auditLineInvalid:           "audit log line %v is not a valid entry: %v",
+	auditLogMissing:            "the -auditLog flag is required to verify the audit log",
+	auditReadErr:               "could not read the audit log: %v",
+	auditSeqErr:                "audit log entry %v is out of sequence, found %v",
+	auditTruncatedErr:          "audit log was truncated, the last entry should be %v but found %v",
+	auditWriteErr:              "could not write to the audit log: %v",
+	awsConfigErr:               "could not get AWS configuration with default methods; %w",
+	azureFlagsMissing:          "the -azureVariableGroup flag needs -azureOrg, -azureProject and -azurePat",
+	azureGroupNotFound:         "no variable group %q in Azure DevOps project %q",
+	bitbucketAuthMissing:       "the -bitbucketWorkspace flag needs -bitbucketToken, or -bitbucketUser and -bitbucketAppPassword",
+	bitbucketEnvNeedsRepo:      "the -bitbucketEnvironment flag needs -bitbucketRepo",
+	bitbucketEnvNotFound:       "no deployment environment %q in Bitbucket repository %v/%v",
+	buildkiteFlagsMissing:      "the -buildkiteOrg flag needs -buildkiteToken, and -buildkiteCluster or -buildkitePipeline",
+	ciRequestErr:               "could not reach the CI API: %w",
+	ciResponseErr:              "%v %v responded with status %v: %v",
+	ciResponseInvalid:          "could not read the %v response: %v",
+	ciVarOutputInvalid:         "the -%v flag sets %v to %q, which is neither one of key, secret, user, region, rotatedAt or smtpPassword, nor a template",
+	ciVarTemplateInvalid:       "the -%v flag has a bad template for %v: %v",
+	ciVarValueErr:              "could not make the value of CI variable %v: %v",
+	circleciContextMissing:     "the -circleci flag needs -circleciContext",
+	ciVarsInvalid:              "the -%v flag must be comma separated NAME=value entries, each name only once, got %q",
+	credentialProcessFormat:    "the credential-process subcommand needs a -fileFormat of json or credential_process, got %q",
+	credentialProcessNeedsFile: "the credential-process subcommand keeps the current key in the key file, so -keepFile must be true",
+	currentKeyIdErr:            "could not get current AWS key ID; %w",
+	decryptKeyErr:              "could not decrypt the key file: %v",
+	droneFlagsMissing:          "the -droneServer flag needs -droneToken, and -droneRepo as owner/name",
+	emailFromMissing:           "the -emailFrom flag is required when -smtpAddr is set",
+	emailNoRecipients:          "no email recipients found for IAM user %q",
+	emailTemplateErr:           "problem with the email template: %v",
+	encryptKeyErr:              "could not encrypt the new access key: %v",
+	fileFormatInvalid:          "the -fileFormat %q is not supported, use one of: %v",
+	intervalInvalid:            "the -interval flag must be greater than zero in daemon mode",
+	jenkinsFlagsMissing:        "the -jenkinsUrl flag needs -jenkinsCredentialId, -jenkinsUser and -jenkinsToken",
+	jenkinsKindInvalid:         "the -jenkinsCredentialKind flag must be aws or usernamePassword, got %q",
+	keyFileNotEncrypted:        "the key file %q is not encrypted with age",
+	lockInvalid:                "the -lockLease flag must be greater than zero and -lockWait must not be negative",
+	logFormatInvalid:           "the -logFormat flag must be logfmt or json, got %q",
+	logLevelInvalid:            "the -logLevel flag must be debug, info, warn or error, got %q",
+	metricsTextfileErr:         "problem writing metrics to the textfile: %v",
+	notifyErr:                  "could not send %v notification: %v",
+	panicked:                   "stopped by an unexpected error: %v",
+	readingKeyFileErr:          "could not read the key file: %v",
+	regionMissing:              "the -region flag is required and must not be an empty string",
+	removingKeyFileErr:         "could not remove the local key file: %v",
+	retryInvalid:               "the -retryAttempts flag must be at least 1, and -retryMaxDelay at least -retryBaseDelay, which must be greater than zero",
+	rollbackErr:                "could not roll back new key %q, delete it manually; %v",
+	sesSmtpNotForService:       "the -sesSmtpRegions flag only applies to access keys, not the %v subcommand",
+	serviceResetNeedsTags:      "the -serviceReset flag needs -tagUser, to record when the password was reset",
+	sesSmtpOnlyConflict:        "the -sesSmtpOnly flag leaves out the secret access key, which %v needs",
+	sesSmtpOnlyNeedsCi:         "the -sesSmtpOnly flag needs a CI service to save to, without one the key file is the only store and needs the secret access key",
+	sesSmtpOnlyNeedsRegions:    "the -sesSmtpOnly flag needs -sesSmtpRegions",
+	sesSmtpRegionInvalid:       "the -sesSmtpRegions flag must be a comma separated list of AWS regions, got %q",
+	sshKeyTypeInvalid:          "the -sshKeyType flag must be rsa or ed25519, got %q",
+	tfcFlagsMissing:            "the -tfcWorkspaces and -tfcVariableSets flags need -tfcOrg and -tfcToken",
+	tfcPlanFailed:              "the Terraform Cloud plan %v of workspace %q with the new key did not finish: %v",
+	tfcPlanNeedsWorkspace:      "the -tfcPlan flag needs -tfcWorkspaces",
+	tfcVarSetNotFound:          "no variable set %q in Terraform Cloud organization %q",
+	timeoutInvalid:             "the -timeout and -callTimeout flags must not be negative",
+	webhookResponseErr:         "webhook responded with status %v: %v",
+	travisFlagsMissing:         "the -travisRepo flag needs -travisToken, and must be owner/name",
+	translateKeyToJsonErr:      "problem translating the new access key to JSON: %v",
+	unknownSubcommand:          "unknown subcommand %q",
+	userNameErr:                "could not get the name of the IAM user signed in; %w",
+	writingNewKeyErr:           "problem writing the new access key to a file: %v",
+	writingSSHKeyErr:           "problem writing the new SSH key pair to a file: %v",
+	probMakingNewKey:           "problem with making a new access key: %v",
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	stderrors "errors"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+)
+
+// Exit codes, so scripts and CI can tell what happened. They are listed in the README.
+const (
+	exitNothingToDo        = 0
+	exitFailed             = 1
+	exitConfigInvalid      = 2
+	exitAuthFailed         = 3
+	exitQuotaReached       = 4
+	exitStorageFailed      = 5
+	exitRollbackNeeded     = 6
+	exitVerificationFailed = 7
+	exitLocked             = 8
+	exitRotated            = 10
+)
+
+// exitKinds The exit code of each kind of failure, the first kind an error is decides the code.
+var exitKinds = []struct {
+	kind error
+	code int
+}{
+	{rotator.ErrRollbackNeeded, exitRollbackNeeded},
+	{rotator.ErrStorageFailed, exitStorageFailed},
+	{rotator.ErrQuotaReached, exitQuotaReached},
+	{rotator.ErrAuthFailed, exitAuthFailed},
+	{rotator.ErrConfigInvalid, exitConfigInvalid},
+	{rotator.ErrVerificationFailed, exitVerificationFailed},
+	{rotator.ErrLocked, exitLocked},
+}
+
+// exitCode Get the code to exit with after a run that returned err, exitRotated when rotated is set, which is only
+// done with -detailedExitCodes.
+func exitCode(err error, rotated bool) int {
+	if err == nil {
+		if rotated {
+			return exitRotated
+		}
+
+		return exitNothingToDo
+	}
+
+	for _, ek := range exitKinds {
+		if stderrors.Is(err, ek.kind) {
+			return ek.code
+		}
+	}
+
+	return exitFailed
+}
+
+// withKind Mark err as a kind of failure from the rotator package, nil stays nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &rotator.Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"testing"
+)
+
+func TestExitCode(tester *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		rotated bool
+		want    int
+	}{
+		{"nothingToDo", nil, false, exitNothingToDo},
+		{"rotated", nil, true, exitRotated},
+		{"failed", fmt.Errorf("a test error occurred"), false, exitFailed},
+		{"configInvalid", withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.regionMissing)), false, exitConfigInvalid},
+		{"authFailed", withKind(rotator.ErrAuthFailed, fmt.Errorf("a test error occurred")), false, exitAuthFailed},
+		{"quotaReached", fmt.Errorf("wrapped: %w", withKind(rotator.ErrQuotaReached, fmt.Errorf("a test error occurred"))), false, exitQuotaReached},
+		{"storageFailed", withKind(rotator.ErrStorageFailed, fmt.Errorf("a test error occurred")), false, exitStorageFailed},
+		{"rollbackNeeded", withKind(rotator.ErrRollbackNeeded, withKind(rotator.ErrStorageFailed, fmt.Errorf("a test error occurred"))), false, exitRollbackNeeded},
+		{"locked", withKind(rotator.ErrLocked, fmt.Errorf("a test error occurred")), false, exitLocked},
+		{"verificationFailed", withKind(rotator.ErrVerificationFailed, fmt.Errorf("a test error occurred")), false, exitVerificationFailed},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			if got := exitCode(test.err, test.rotated); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExitCodeConfigInvalid(tester *testing.T) {
+	empty := ""
+	ac := &applicationFlags{region: &empty, auditLog: &empty}
+
+	if got := exitCode(ac.check(), false); got != exitConfigInvalid {
+		tester.Errorf("want %v for missing flags, got %v", exitConfigInvalid, got)
+	}
+
+	_, err := runSubcommand(context.TODO(), []string{"nope"}, ac)
+	if got := exitCode(err, false); got != exitConfigInvalid {
+		tester.Errorf("want %v for an unknown subcommand, got %v", exitConfigInvalid, got)
+	}
+}
@@ -3,18 +3,108 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // This is the struct that defines all application flags.
 type applicationFlags struct {
+	daemon,
+	detailedExitCodes,
+	keepFile,
+	lock,
+	serviceReset,
+	sesSmtpOnly,
+	tagUser,
+	tfcPlan *bool
+	callTimeout,
+	interval,
+	lockLease,
+	lockWait,
+	retryBaseDelay,
+	retryMaxDelay,
+	tfcPlanTimeout,
+	timeout *time.Duration
 	maxDaysAllowed,
-	maxKeysAllowed *int
+	maxKeysAllowed,
+	retryAttempts,
+	warnDays *int
+	ageIdentity,
+	ageRecipient,
+	auditKey,
+	auditLog,
+	azureOrg,
+	azurePat,
+	azureProject,
+	azureVars,
+	azureVariableGroup,
+	bitbucketAppPassword,
+	bitbucketEnvironment,
+	bitbucketRepo,
+	bitbucketToken,
+	bitbucketUser,
+	bitbucketVars,
+	bitbucketWorkspace,
+	buildkiteCluster,
+	buildkiteOrg,
+	buildkitePipeline,
+	buildkiteToken,
+	buildkiteVars,
 	circleci,
+	circleciContext,
+	circleciVars,
+	droneRepo,
+	droneServer,
+	droneToken,
+	droneVars,
+	emailFrom,
+	emailTag,
+	emailTemplate,
+	emailTo,
 	region,
 	filename,
-	profile *string
+	fileFormat,
+	jenkinsCredentialId,
+	jenkinsCredentialKind,
+	jenkinsDomain,
+	jenkinsFolder,
+	jenkinsToken,
+	jenkinsUrl,
+	jenkinsUser,
+	logFormat,
+	logLevel,
+	metricsAddr,
+	metricsTextfile,
+	notifyOn,
+	profile,
+	serviceName,
+	sesSmtpRegions,
+	slackWebhook,
+	smtpAddr,
+	smtpPassword,
+	smtpUser,
+	sshKeyFile,
+	sshKeyType,
+	teamsWebhook,
+	tfcHost,
+	tfcOrg,
+	tfcToken,
+	tfcVariableSets,
+	tfcVars,
+	tfcWorkspaces,
+	travisEndpoint,
+	travisRepo,
+	travisToken,
+	travisVars,
+	webhook *string
 }
 
+// repoSlugRe A repository given as owner/name.
+var repoSlugRe = regexp.MustCompile(`^[^/]+/[^/]+$`)
+
 // appFlags Is what you use at runtime, it is the implementation of the applicationFlags type.
 var appFlags = new(applicationFlags)
 
@@ -29,13 +119,333 @@ func (af *applicationFlags) define() {
 	appFlags.filename = flag.String("filename", "new-aws-access-key.json", flagUsages["filename"])
 	appFlags.profile = flag.String("profile", "", flagUsages["profile"])
 	appFlags.circleci = flag.String("circleci", "", flagUsages["circleci"])
+	appFlags.circleciContext = flag.String("circleciContext", "", flagUsages["circleciContext"])
+	appFlags.circleciVars = flag.String("circleciVars", "", flagUsages["circleciVars"])
+	appFlags.bitbucketWorkspace = flag.String("bitbucketWorkspace", "", flagUsages["bitbucketWorkspace"])
+	appFlags.bitbucketRepo = flag.String("bitbucketRepo", "", flagUsages["bitbucketRepo"])
+	appFlags.bitbucketEnvironment = flag.String("bitbucketEnvironment", "", flagUsages["bitbucketEnvironment"])
+	appFlags.bitbucketUser = flag.String("bitbucketUser", "", flagUsages["bitbucketUser"])
+	appFlags.bitbucketAppPassword = flag.String("bitbucketAppPassword", "", flagUsages["bitbucketAppPassword"])
+	appFlags.bitbucketVars = flag.String("bitbucketVars", "", flagUsages["bitbucketVars"])
+	appFlags.bitbucketToken = flag.String("bitbucketToken", "", flagUsages["bitbucketToken"])
+	appFlags.azureOrg = flag.String("azureOrg", "", flagUsages["azureOrg"])
+	appFlags.azureProject = flag.String("azureProject", "", flagUsages["azureProject"])
+	appFlags.azureVariableGroup = flag.String("azureVariableGroup", "", flagUsages["azureVariableGroup"])
+	appFlags.azureVars = flag.String("azureVars", "", flagUsages["azureVars"])
+	appFlags.azurePat = flag.String("azurePat", "", flagUsages["azurePat"])
+	appFlags.jenkinsUrl = flag.String("jenkinsUrl", "", flagUsages["jenkinsUrl"])
+	appFlags.jenkinsUser = flag.String("jenkinsUser", "", flagUsages["jenkinsUser"])
+	appFlags.jenkinsToken = flag.String("jenkinsToken", "", flagUsages["jenkinsToken"])
+	appFlags.jenkinsFolder = flag.String("jenkinsFolder", "", flagUsages["jenkinsFolder"])
+	appFlags.jenkinsDomain = flag.String("jenkinsDomain", "_", flagUsages["jenkinsDomain"])
+	appFlags.jenkinsCredentialId = flag.String("jenkinsCredentialId", "", flagUsages["jenkinsCredentialId"])
+	appFlags.jenkinsCredentialKind = flag.String("jenkinsCredentialKind", "aws", flagUsages["jenkinsCredentialKind"])
+	appFlags.tfcHost = flag.String("tfcHost", "app.terraform.io", flagUsages["tfcHost"])
+	appFlags.tfcOrg = flag.String("tfcOrg", "", flagUsages["tfcOrg"])
+	appFlags.tfcToken = flag.String("tfcToken", "", flagUsages["tfcToken"])
+	appFlags.tfcWorkspaces = flag.String("tfcWorkspaces", "", flagUsages["tfcWorkspaces"])
+	appFlags.tfcVariableSets = flag.String("tfcVariableSets", "", flagUsages["tfcVariableSets"])
+	appFlags.tfcVars = flag.String("tfcVars", "", flagUsages["tfcVars"])
+	appFlags.tfcPlan = flag.Bool("tfcPlan", false, flagUsages["tfcPlan"])
+	appFlags.tfcPlanTimeout = flag.Duration("tfcPlanTimeout", 30*time.Minute, flagUsages["tfcPlanTimeout"])
+	appFlags.buildkiteOrg = flag.String("buildkiteOrg", "", flagUsages["buildkiteOrg"])
+	appFlags.buildkiteCluster = flag.String("buildkiteCluster", "", flagUsages["buildkiteCluster"])
+	appFlags.buildkitePipeline = flag.String("buildkitePipeline", "", flagUsages["buildkitePipeline"])
+	appFlags.buildkiteToken = flag.String("buildkiteToken", "", flagUsages["buildkiteToken"])
+	appFlags.buildkiteVars = flag.String("buildkiteVars", "", flagUsages["buildkiteVars"])
+	appFlags.droneServer = flag.String("droneServer", "", flagUsages["droneServer"])
+	appFlags.droneRepo = flag.String("droneRepo", "", flagUsages["droneRepo"])
+	appFlags.droneToken = flag.String("droneToken", "", flagUsages["droneToken"])
+	appFlags.droneVars = flag.String("droneVars", "", flagUsages["droneVars"])
+	appFlags.travisEndpoint = flag.String("travisEndpoint", "https://api.travis-ci.com", flagUsages["travisEndpoint"])
+	appFlags.travisRepo = flag.String("travisRepo", "", flagUsages["travisRepo"])
+	appFlags.travisToken = flag.String("travisToken", "", flagUsages["travisToken"])
+	appFlags.travisVars = flag.String("travisVars", "", flagUsages["travisVars"])
+	appFlags.daemon = flag.Bool("daemon", false, flagUsages["daemon"])
+	appFlags.detailedExitCodes = flag.Bool("detailedExitCodes", false, flagUsages["detailedExitCodes"])
+	appFlags.interval = flag.Duration("interval", time.Hour, flagUsages["interval"])
+	appFlags.metricsAddr = flag.String("metricsAddr", ":9464", flagUsages["metricsAddr"])
+	appFlags.metricsTextfile = flag.String("metricsTextfile", "", flagUsages["metricsTextfile"])
+	appFlags.slackWebhook = flag.String("slackWebhook", "", flagUsages["slackWebhook"])
+	appFlags.teamsWebhook = flag.String("teamsWebhook", "", flagUsages["teamsWebhook"])
+	appFlags.webhook = flag.String("webhook", "", flagUsages["webhook"])
+	appFlags.notifyOn = flag.String("notifyOn", "rotated,warning,failure,rollback", flagUsages["notifyOn"])
+	appFlags.warnDays = flag.Int("warnDays", 0, flagUsages["warnDays"])
+	appFlags.fileFormat = flag.String("fileFormat", "json", flagUsages["fileFormat"])
+	appFlags.ageRecipient = flag.String("ageRecipient", "", flagUsages["ageRecipient"])
+	appFlags.ageIdentity = flag.String("ageIdentity", "", flagUsages["ageIdentity"])
+	appFlags.keepFile = flag.Bool("keepFile", true, flagUsages["keepFile"])
+	appFlags.auditKey = flag.String("auditKey", "", flagUsages["auditKey"])
+	appFlags.auditLog = flag.String("auditLog", "", flagUsages["auditLog"])
+	appFlags.logFormat = flag.String("logFormat", "logfmt", flagUsages["logFormat"])
+	appFlags.logLevel = flag.String("logLevel", "info", flagUsages["logLevel"])
+	appFlags.smtpAddr = flag.String("smtpAddr", "", flagUsages["smtpAddr"])
+	appFlags.smtpUser = flag.String("smtpUser", "", flagUsages["smtpUser"])
+	appFlags.smtpPassword = flag.String("smtpPassword", "", flagUsages["smtpPassword"])
+	appFlags.emailFrom = flag.String("emailFrom", "", flagUsages["emailFrom"])
+	appFlags.emailTo = flag.String("emailTo", "", flagUsages["emailTo"])
+	appFlags.emailTag = flag.String("emailTag", "owner-email", flagUsages["emailTag"])
+	appFlags.emailTemplate = flag.String("emailTemplate", "", flagUsages["emailTemplate"])
+	appFlags.timeout = flag.Duration("timeout", 5*time.Minute, flagUsages["timeout"])
+	appFlags.callTimeout = flag.Duration("callTimeout", 30*time.Second, flagUsages["callTimeout"])
+	appFlags.retryAttempts = flag.Int("retryAttempts", rotator.DefaultMaxAttempts, flagUsages["retryAttempts"])
+	appFlags.retryBaseDelay = flag.Duration("retryBaseDelay", rotator.DefaultBaseDelay, flagUsages["retryBaseDelay"])
+	appFlags.retryMaxDelay = flag.Duration("retryMaxDelay", rotator.DefaultMaxDelay, flagUsages["retryMaxDelay"])
+	appFlags.lock = flag.Bool("lock", false, flagUsages["lock"])
+	appFlags.tagUser = flag.Bool("tagUser", true, flagUsages["tagUser"])
+	appFlags.lockLease = flag.Duration("lockLease", rotator.DefaultLockLease, flagUsages["lockLease"])
+	appFlags.lockWait = flag.Duration("lockWait", time.Minute, flagUsages["lockWait"])
+	appFlags.sshKeyFile = flag.String("sshKeyFile", "new-ssh-key", flagUsages["sshKeyFile"])
+	appFlags.sshKeyType = flag.String("sshKeyType", rotator.SSHKeyRSA, flagUsages["sshKeyType"])
+	appFlags.serviceName = flag.String("serviceName", rotator.ServiceCodeCommit, flagUsages["serviceName"])
+	appFlags.serviceReset = flag.Bool("serviceReset", false, flagUsages["serviceReset"])
+	appFlags.sesSmtpRegions = flag.String("sesSmtpRegions", "", flagUsages["sesSmtpRegions"])
+	appFlags.sesSmtpOnly = flag.Bool("sesSmtpOnly", false, flagUsages["sesSmtpOnly"])
 }
 
 // check Verify that all flags are set appropriately.
 func (af *applicationFlags) check() error {
 	if *(af.region) == "" {
-		return fmt.Errorf(errors.regionMissing)
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.regionMissing))
+	}
+
+	if _, err := formatKeyPair(awsKeyPair{}, *af.fileFormat); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	// Catch a bad recipient now, rather than after a new key is made.
+	if *(af.ageRecipient) != "" {
+		if _, err := encryptForRecipients([]byte{}, *af.ageRecipient); err != nil {
+			return withKind(rotator.ErrConfigInvalid, err)
+		}
+	}
+
+	if *(af.logFormat) != "logfmt" && *(af.logFormat) != "json" {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.logFormatInvalid, *af.logFormat))
+	}
+
+	switch *(af.logLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.logLevelInvalid, *af.logLevel))
+	}
+
+	if *(af.smtpAddr) != "" && *(af.emailFrom) == "" {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.emailFromMissing))
+	}
+
+	if *(af.circleci) != "" && *(af.circleciContext) == "" {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.circleciContextMissing))
+	}
+
+	if err := af.checkBitbucket(); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	if *(af.azureVariableGroup) != "" && (*(af.azureOrg) == "" || *(af.azureProject) == "" || flagOrEnv(af.azurePat, azurePatEnv) == "") {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.azureFlagsMissing))
+	}
+
+	if err := af.checkJenkins(); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	if err := af.checkTerraform(); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	if err := af.checkBuildkiteDroneTravis(); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	if err := af.checkSesSmtp(); err != nil {
+		return withKind(rotator.ErrConfigInvalid, err)
+	}
+
+	for name, value := range af.ciVarFlags() {
+		if _, err := parseCiVars(name, value, *af.region); err != nil {
+			return withKind(rotator.ErrConfigInvalid, err)
+		}
+	}
+
+	if *(af.timeout) < 0 || *(af.callTimeout) < 0 {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.timeoutInvalid))
+	}
+
+	if *(af.retryAttempts) < 1 || *(af.retryBaseDelay) <= 0 || *(af.retryMaxDelay) < *(af.retryBaseDelay) {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.retryInvalid))
+	}
+
+	if *(af.lock) && (*(af.lockLease) <= 0 || *(af.lockWait) < 0) {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.lockInvalid))
+	}
+
+	if *(af.daemon) && *(af.interval) <= 0 {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.intervalInvalid))
+	}
+
+	return nil
+}
+
+// checkBitbucket Verify the Bitbucket flags, when a workspace is given.
+func (af *applicationFlags) checkBitbucket() error {
+	if *(af.bitbucketWorkspace) == "" {
+		return nil
+	}
+
+	if flagOrEnv(af.bitbucketToken, bitbucketTokenEnv) == "" && (*(af.bitbucketUser) == "" || flagOrEnv(af.bitbucketAppPassword, bitbucketAppPasswordEnv) == "") {
+		return fmt.Errorf(errors.bitbucketAuthMissing)
+	}
+
+	if *(af.bitbucketEnvironment) != "" && *(af.bitbucketRepo) == "" {
+		return fmt.Errorf(errors.bitbucketEnvNeedsRepo)
 	}
 
 	return nil
 }
+
+// checkJenkins Verify the Jenkins flags, when a Jenkins URL is given.
+func (af *applicationFlags) checkJenkins() error {
+	if *(af.jenkinsUrl) == "" {
+		return nil
+	}
+
+	if *(af.jenkinsCredentialId) == "" || *(af.jenkinsUser) == "" || flagOrEnv(af.jenkinsToken, jenkinsTokenEnv) == "" {
+		return fmt.Errorf(errors.jenkinsFlagsMissing)
+	}
+
+	if _, ok := jenkinsKinds[*(af.jenkinsCredentialKind)]; !ok {
+		return fmt.Errorf(errors.jenkinsKindInvalid, *af.jenkinsCredentialKind)
+	}
+
+	return nil
+}
+
+// checkTerraform Verify the Terraform Cloud flags, when workspaces or variable sets are given.
+func (af *applicationFlags) checkTerraform() error {
+	if *(af.tfcWorkspaces) == "" && *(af.tfcVariableSets) == "" {
+		return nil
+	}
+
+	if *(af.tfcOrg) == "" || flagOrEnv(af.tfcToken, tfcTokenEnv) == "" {
+		return fmt.Errorf(errors.tfcFlagsMissing)
+	}
+
+	if *(af.tfcPlan) && len(splitList(*af.tfcWorkspaces)) == 0 {
+		return fmt.Errorf(errors.tfcPlanNeedsWorkspace)
+	}
+
+	return nil
+}
+
+// checkBuildkiteDroneTravis Verify the Buildkite, Drone and Travis CI flags, for each service chosen.
+func (af *applicationFlags) checkBuildkiteDroneTravis() error {
+	if *(af.buildkiteOrg) != "" && (flagOrEnv(af.buildkiteToken, buildkiteTokenEnv) == "" || (*(af.buildkiteCluster) == "" && *(af.buildkitePipeline) == "")) {
+		return fmt.Errorf(errors.buildkiteFlagsMissing)
+	}
+
+	if *(af.droneServer) != "" && (flagOrEnv(af.droneToken, droneTokenEnv) == "" || !repoSlugRe.MatchString(*af.droneRepo)) {
+		return fmt.Errorf(errors.droneFlagsMissing)
+	}
+
+	if *(af.travisRepo) != "" && (flagOrEnv(af.travisToken, travisTokenEnv) == "" || !repoSlugRe.MatchString(*af.travisRepo)) {
+		return fmt.Errorf(errors.travisFlagsMissing)
+	}
+
+	return nil
+}
+
+// checkSesSmtp Verify the SES SMTP flags, -sesSmtpOnly cannot be used with a target that needs the secret.
+func (af *applicationFlags) checkSesSmtp() error {
+	regions := splitList(*af.sesSmtpRegions)
+	for _, region := range regions {
+		if !sesRegionRe.MatchString(region) {
+			return fmt.Errorf(errors.sesSmtpRegionInvalid, region)
+		}
+	}
+
+	if !*(af.sesSmtpOnly) {
+		return nil
+	}
+
+	switch {
+	case len(regions) == 0:
+		return fmt.Errorf(errors.sesSmtpOnlyNeedsRegions)
+	case *(af.fileFormat) == "credential_process":
+		return fmt.Errorf(errors.sesSmtpOnlyConflict, "-fileFormat credential_process")
+	case *(af.jenkinsUrl) != "" && *(af.jenkinsCredentialKind) != "usernamePassword":
+		return fmt.Errorf(errors.sesSmtpOnlyConflict, "-jenkinsCredentialKind aws")
+	case !af.hasCiService():
+		return fmt.Errorf(errors.sesSmtpOnlyNeedsCi)
+	}
+
+	for name, value := range af.ciVarFlags() {
+		if vars, _ := parseCiVars(name, value, *af.region); vars != nil && vars.usesSecret() {
+			return fmt.Errorf(errors.sesSmtpOnlyConflict, "-"+name)
+		}
+	}
+
+	return nil
+}
+
+// hasCiService Indicates a CI service is chosen to save new keys to, besides the key file.
+func (af *applicationFlags) hasCiService() bool {
+	return *(af.circleci) != "" || *(af.bitbucketWorkspace) != "" || *(af.azureVariableGroup) != "" ||
+		*(af.jenkinsUrl) != "" || *(af.tfcWorkspaces) != "" || *(af.tfcVariableSets) != "" ||
+		*(af.buildkiteOrg) != "" || *(af.droneServer) != "" || *(af.travisRepo) != ""
+}
+
+// ciVarFlags Get the -*Vars flags that set the variables saved to each CI service, by name.
+func (af *applicationFlags) ciVarFlags() map[string]string {
+	return map[string]string{
+		"azureVars":     *af.azureVars,
+		"bitbucketVars": *af.bitbucketVars,
+		"buildkiteVars": *af.buildkiteVars,
+		"circleciVars":  *af.circleciVars,
+		"droneVars":     *af.droneVars,
+		"tfcVars":       *af.tfcVars,
+		"travisVars":    *af.travisVars,
+	}
+}
+
+// ciVars Get the variables the -*Vars flag of the name sets, checked by check.
+func (af *applicationFlags) ciVars(name string) *ciVars {
+	vars, _ := parseCiVars(name, af.ciVarFlags()[name], *af.region)
+	if vars != nil {
+		vars.smtp = af.sesSmtp()
+	}
+
+	return vars
+}
+
+// sesSmtp Get the SES regions to derive SMTP passwords for, nil when -sesSmtpRegions is not set.
+func (af *applicationFlags) sesSmtp() *sesSmtp {
+	regions := splitList(*af.sesSmtpRegions)
+	if len(regions) == 0 {
+		return nil
+	}
+
+	return &sesSmtp{regions, *af.sesSmtpOnly}
+}
+
+// splitList Split a comma separated flag value, leaving out blanks.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// flagOrEnv Get the value of a flag that holds a secret, or else of the environment variable env. The variable is read
+// here, not as the default of the flag, so -help does not show it.
+func flagOrEnv(value *string, env string) string {
+	if *value != "" {
+		return *value
+	}
+
+	return os.Getenv(env)
+}
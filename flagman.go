@@ -3,10 +3,92 @@ package main
 // All flag usage/instructions/documentation goes in here.
 
 var flagUsages = map[string]string{
-	"help":           "-h, -help\n\tDisplay usage info for all arguments, flags, and subcommands.",
-	"maxDaysAllowed": "[maxDaysAllowed] int\n\tAn integer representing the maximum number of days before this app will remove or rotate the IAM key/secret pair.",
-	"maxKeysAllowed": "[maxKeysAllowed] int\n\tAn integer representing the maximum number of keys that should exist on an IAM user.",
-	"filename":       "[filename] string\n\tPath of a file to store a new IAM key/secret pair.",
-	"region":         "<region> string\n\tAn AWS region.",
-	"circleci":       "[circleci] string\n\tCircle CI personal token used to update context variables.",
+	"ageIdentity":           "[ageIdentity] string\n\tPath of an age identity file used by the `decrypt-backup` subcommand to decrypt the key file.",
+	"ageRecipient":          "[ageRecipient] string\n\tComma separated list of age recipient public keys (age1...) to encrypt the key file to.",
+	"auditKey":              "[auditKey] string\n\tSecret the audit log entries are signed with using HMAC-SHA256, so they cannot be rewritten without it. Give the same key to `audit verify`. Defaults to the AUDIT_LOG_KEY environment variable.",
+	"auditLog":              "[auditLog] string\n\tPath of an append-only JSONL file to record every change made to IAM and every storage target written. Check it with the `audit verify` subcommand.",
+	"help":                  "-h, -help\n\tDisplay usage info for all arguments, flags, and subcommands.",
+	"maxDaysAllowed":        "[maxDaysAllowed] int\n\tAn integer representing the maximum number of days before this app will remove or rotate the IAM key/secret pair.",
+	"maxKeysAllowed":        "[maxKeysAllowed] int\n\tAn integer representing the maximum number of keys that should exist on an IAM user.",
+	"fileFormat":            "[fileFormat] string\n\tFormat of the key file: json, csv, shell, powershell, dotenv or credential_process.",
+	"filename":              "[filename] string\n\tPath of a file to store a new IAM key/secret pair.",
+	"region":                "<region> string\n\tAn AWS region.",
+	"azureOrg":              "[azureOrg] string\n\tURL of the Azure DevOps organization, such as https://dev.azure.com/acme.",
+	"azurePat":              "[azurePat] string\n\tAzure DevOps personal access token with the Variable Groups read and manage scope, defaults to the AZURE_DEVOPS_EXT_PAT environment variable.",
+	"azureProject":          "[azureProject] string\n\tName of the Azure DevOps project the variable group is in.",
+	"azureVariableGroup":    "[azureVariableGroup] string\n\tName of an Azure DevOps variable group to set the key and secret in as secret variables, its other variables are kept. Needs -azureOrg, -azureProject and -azurePat.",
+	"bitbucketWorkspace":    "[bitbucketWorkspace] string\n\tBitbucket Cloud workspace to set the key and secret in as secured Pipelines variables. They are set on the workspace unless -bitbucketRepo is given.",
+	"bitbucketRepo":         "[bitbucketRepo] string\n\tSlug of the Bitbucket repository to set the variables on, instead of the workspace.",
+	"bitbucketEnvironment":  "[bitbucketEnvironment] string\n\tName or {UUID} of the deployment environment of -bitbucketRepo to set the variables on, instead of the repository.",
+	"bitbucketUser":         "[bitbucketUser] string\n\tBitbucket user name to authenticate with, along with -bitbucketAppPassword.",
+	"bitbucketAppPassword":  "[bitbucketAppPassword] string\n\tBitbucket app password with the Pipelines edit scope, defaults to the BITBUCKET_APP_PASSWORD environment variable.",
+	"bitbucketToken":        "[bitbucketToken] string\n\tBitbucket OAuth access token, used instead of an app password. Defaults to the BITBUCKET_TOKEN environment variable.",
+	"buildkiteOrg":          "[buildkiteOrg] string\n\tSlug of a Buildkite organization to save the key in, as secrets of -buildkiteCluster and in the environment of -buildkitePipeline. Needs -buildkiteToken.",
+	"buildkiteCluster":      "[buildkiteCluster] string\n\tID of the Buildkite cluster to set the key and secret in as secrets.",
+	"buildkitePipeline":     "[buildkitePipeline] string\n\tSlug of the Buildkite pipeline to set the key and secret in the environment of, its other variables are kept.",
+	"buildkiteToken":        "[buildkiteToken] string\n\tBuildkite API access token with the write_pipelines and write_clusters scopes it needs, defaults to the BUILDKITE_API_TOKEN environment variable.",
+	"droneServer":           "[droneServer] string\n\tURL of a Drone server to save the key to as secrets of -droneRepo. Needs -droneToken.",
+	"droneRepo":             "[droneRepo] string\n\tDrone repository to set the secrets of, as owner/name.",
+	"droneToken":            "[droneToken] string\n\tDrone personal token, defaults to the DRONE_TOKEN environment variable.",
+	"travisEndpoint":        "[travisEndpoint] string\n\tURL of the Travis CI API.",
+	"travisRepo":            "[travisRepo] string\n\tTravis CI repository to save the key to as private environment variables, as owner/name. Needs -travisToken.",
+	"travisToken":           "[travisToken] string\n\tTravis CI API token, defaults to the TRAVIS_TOKEN environment variable.",
+	"azureVars":             "[azureVars] string\n\tVariables to save to the Azure DevOps variable group as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"bitbucketVars":         "[bitbucketVars] string\n\tVariables to save to Bitbucket as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"buildkiteVars":         "[buildkiteVars] string\n\tVariables to save to Buildkite as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"circleciVars":          "[circleciVars] string\n\tVariables to save to the Circle CI context as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"droneVars":             "[droneVars] string\n\tVariables to save to Drone as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"tfcVars":               "[tfcVars] string\n\tVariables to save to Terraform Cloud as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"travisVars":            "[travisVars] string\n\tVariables to save to Travis CI as comma separated NAME=value entries, a value is key, secret, user, region, rotatedAt, smtpPassword or a Go template of them such as {{.Region}}. Defaults to AWS_ACCESS_KEY_ID=key,AWS_SECRET_ACCESS_KEY=secret.",
+	"circleci":              "[circleci] string\n\tCircle CI personal token used to update context variables.",
+	"circleciContext":       "[circleciContext] string\n\tID of the Circle CI context whose variables are updated, required with -circleci.",
+	"daemon":                "[daemon] bool\n\tKeep running, checking the keys every interval, and serve metrics over HTTP.",
+	"detailedExitCodes":     "[detailedExitCodes] bool\n\tExit with 10 instead of 0 when a new key, SSH key pair or service-specific credential was made, so a pipeline can tell a rotation from a run with nothing to do.",
+	"interval":              "[interval] duration\n\tTime to wait between checks in daemon mode, for example 1h or 30m.",
+	"timeout":               "[timeout] duration\n\tLongest a run may take, it stops before the next change to IAM once it is up. 0 for no limit.",
+	"lock":                  "[lock] bool\n\tLock the IAM user with a tag while rotating, so rotations started at the same time, such as by two CI jobs, do not overlap. Needs iam:ListUserTags, iam:TagUser and iam:UntagUser.",
+	"lockLease":             "[lockLease] duration\n\tHow long the lock is held before another rotation may take it over, in case this one dies without giving it up.",
+	"lockWait":              "[lockWait] duration\n\tHow long to wait for another rotation to give up the lock before failing, 0 to fail at once.",
+	"tagUser":               "[tagUser] bool\n\tTag the IAM user with the time of each rotation, the new key, the storage targets and the version of this program, and warn of keys made some other way. Needs iam:ListUserTags and iam:TagUser, set to false to leave the tags alone.",
+	"retryAttempts":         "[retryAttempts] int\n\tMost times a call to AWS or a storage target is made when it is throttled or fails for a reason that may go away, 1 to never retry.",
+	"retryBaseDelay":        "[retryBaseDelay] duration\n\tLongest random wait before the first retry, it doubles with every attempt. A Retry-After from the server is used instead.",
+	"retryMaxDelay":         "[retryMaxDelay] duration\n\tLongest wait between retries.",
+	"callTimeout":           "[callTimeout] duration\n\tLongest a single call to AWS or a storage target may take. 0 for no limit.",
+	"metricsAddr":           "[metricsAddr] string\n\tAddress to serve the /metrics endpoint on in daemon mode.",
+	"notifyOn":              "[notifyOn] string\n\tComma separated list of events to send notifications for: rotated, warning, failure, rollback.",
+	"slackWebhook":          "[slackWebhook] string\n\tSlack incoming webhook URL to send notifications to.",
+	"teamsWebhook":          "[teamsWebhook] string\n\tMicrosoft Teams incoming webhook URL to send notifications to.",
+	"tfcHost":               "[tfcHost] string\n\tHost of Terraform Cloud, or of a Terraform Enterprise install.",
+	"tfcOrg":                "[tfcOrg] string\n\tTerraform Cloud organization of the workspaces and variable sets.",
+	"tfcToken":              "[tfcToken] string\n\tTerraform Cloud user or team API token, defaults to the TFE_TOKEN environment variable.",
+	"tfcWorkspaces":         "[tfcWorkspaces] string\n\tComma separated list of Terraform Cloud workspaces to set the key and secret on as sensitive environment variables. Needs -tfcOrg and -tfcToken.",
+	"tfcVariableSets":       "[tfcVariableSets] string\n\tComma separated list of Terraform Cloud variable sets to set the key and secret in as sensitive environment variables. Needs -tfcOrg and -tfcToken.",
+	"tfcPlan":               "[tfcPlan] bool\n\tQueue a plan on every workspace of -tfcWorkspaces after setting the variables, and fail when it does not work. The plans are waited for once the variables are all set, see -tfcPlanTimeout.",
+	"tfcPlanTimeout":        "[tfcPlanTimeout] duration\n\tLongest to wait for the plans of -tfcPlan to finish, it is not limited by -callTimeout. 0 for no limit.",
+	"warnDays":              "[warnDays] int\n\tSend a warning notification when the current key is at least this many days old, 0 to disable.",
+	"webhook":               "[webhook] string\n\tURL to POST every notification to as JSON.",
+	"emailFrom":             "[emailFrom] string\n\tAddress emails are sent from, required when -smtpAddr is set.",
+	"emailTag":              "[emailTag] string\n\tIAM user tag holding a comma separated list of owner email addresses.",
+	"emailTemplate":         "[emailTemplate] string\n\tPath of a Go text/template file to use for the email message, headers included.",
+	"emailTo":               "[emailTo] string\n\tComma separated list of addresses to email, in addition to the owners from -emailTag.",
+	"smtpAddr":              "[smtpAddr] string\n\tSMTP server host:port to send email notifications through, STARTTLS is used when offered.",
+	"smtpPassword":          "[smtpPassword] string\n\tSMTP password, defaults to the SMTP_PASSWORD environment variable.",
+	"smtpUser":              "[smtpUser] string\n\tSMTP user name, leave empty to send without authenticating.",
+	"sshKeyFile":            "[sshKeyFile] string\n\tPath of a file to store the private key of a new SSH key pair made by the `rotate-ssh-key` subcommand, the public key is stored next to it with .pub added.",
+	"sshKeyType":            "[sshKeyType] string\n\tType of SSH key pair the `rotate-ssh-key` subcommand makes: rsa or ed25519.",
+	"serviceName":           "[serviceName] string\n\tService whose service-specific credentials the `rotate-service-credential` subcommand rotates, codecommit.amazonaws.com or cassandra.amazonaws.com.",
+	"serviceReset":          "[serviceReset] bool\n\tReset the password of the current service-specific credential instead of making a new one, the old password stops working at once. Needs -tagUser, as when it was reset is kept in the tags of the user.",
+	"sesSmtpRegions":        "[sesSmtpRegions] string\n\tComma separated list of regions to derive SES SMTP passwords for from a new key, saved with the key ID as the SMTP user name to the key file and CI variables.",
+	"sesSmtpOnly":           "[sesSmtpOnly] bool\n\tSave the SES SMTP passwords of -sesSmtpRegions in place of the secret access key.",
+	"jenkinsUrl":            "[jenkinsUrl] string\n\tURL of a Jenkins server to save the key to as a credential, with the credentials plugin. Needs -jenkinsCredentialId, -jenkinsUser and -jenkinsToken.",
+	"jenkinsUser":           "[jenkinsUser] string\n\tJenkins user name to authenticate with, along with -jenkinsToken.",
+	"jenkinsToken":          "[jenkinsToken] string\n\tJenkins API token of -jenkinsUser, defaults to the JENKINS_API_TOKEN environment variable.",
+	"jenkinsFolder":         "[jenkinsFolder] string\n\tPath of the Jenkins folder whose credentials store holds the credential, such as team/app. The system store is used when empty.",
+	"jenkinsDomain":         "[jenkinsDomain] string\n\tCredentials domain of the credential, _ for the global domain.",
+	"jenkinsCredentialId":   "[jenkinsCredentialId] string\n\tID of the Jenkins credential to update, it is made when there is none.",
+	"jenkinsCredentialKind": "[jenkinsCredentialKind] string\n\tKind of Jenkins credential: aws, from the AWS Credentials plugin, or usernamePassword with the key ID as the user name and the secret as the password.",
+	"keepFile":              "[keepFile] bool\n\tKeep the key file after the key is saved to the other storage targets, set to false to delete it once they succeed.",
+	"logFormat":             "[logFormat] string\n\tFormat of log lines, logfmt or json.",
+	"logLevel":              "[logLevel] string\n\tMinimum level to log: debug, info, warn or error.",
+	"metricsTextfile":       "[metricsTextfile] string\n\tPath of a file to write metrics to after a one-shot run, for the node-exporter textfile collector.",
 }
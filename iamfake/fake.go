@@ -0,0 +1,475 @@
+// Package iamfake is an in-memory stand-in for the IAM calls the rotator makes, so rotations can be tested offline. Use
+// a Fake directly as a rotator.IAMClient, or serve it over the IAM Query API with NewServer and point an AWS SDK at it.
+package iamfake
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQuota The most access keys IAM lets a user have.
+const DefaultQuota = 2
+
+// AccountId The account every fake user belongs to.
+const AccountId = "123456789012"
+
+// Fake Holds users with their keys and tags. Calls without a user name act on Caller, like IAM acting on the user
+// signed in. It is safe for concurrent use.
+type Fake struct {
+	// Caller The user calls without a user name act on.
+	Caller string
+	// Quota The most keys a user may have, DefaultQuota when 0.
+	Quota int
+	// Latency How long every call takes, a call stops early when its context is done.
+	Latency time.Duration
+	// Now Tells the time keys are made, defaults to time.Now.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	users map[string]*user
+	fails map[string][]error
+	calls []string
+	seq   int
+}
+
+type user struct {
+	created      time.Time
+	keys         []*key
+	sshKeys      []*types.SSHPublicKey
+	serviceCreds []*types.ServiceSpecificCredential
+	tags         []types.Tag
+}
+
+type key struct {
+	types.AccessKeyMetadata
+	secret   string
+	lastUsed *types.AccessKeyLastUsed
+}
+
+// New Make a fake with no users.
+func New() *Fake {
+	return &Fake{users: map[string]*user{}, fails: map[string][]error{}}
+}
+
+// APIError Make an error like those IAM returns, such as NoSuchEntity or LimitExceeded.
+func APIError(code, format string, a ...interface{}) error {
+	return &smithy.GenericAPIError{Code: code, Message: fmt.Sprintf(format, a...), Fault: smithy.FaultClient}
+}
+
+// AddUser Add a user with the tags given, or add the tags to a user already added. The first user added is the Caller
+// unless one was set.
+func (f *Fake) AddUser(name string, tags ...types.Tag) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[name]
+	if !ok {
+		u = &user{created: f.now()}
+		f.users[name] = u
+	}
+	u.tags = append(u.tags, tags...)
+
+	if f.Caller == "" {
+		f.Caller = name
+	}
+}
+
+// AddKey Give a user a key made at the time given, adding the user when needed. The secret of the key is returned.
+func (f *Fake) AddKey(userName, id string, created time.Time, status types.StatusType) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		u = &user{created: created}
+		f.users[userName] = u
+		if f.Caller == "" {
+			f.Caller = userName
+		}
+	}
+
+	k := f.newKey(userName, id, created, status)
+	u.keys = append(u.keys, k)
+
+	return k.secret
+}
+
+// SetLastUsed Record when and where a key was last used.
+func (f *Fake) SetLastUsed(id string, at time.Time, service, region string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, k := f.findKey(id); k != nil {
+		k.lastUsed = &types.AccessKeyLastUsed{LastUsedDate: aws.Time(at), ServiceName: aws.String(service), Region: aws.String(region)}
+	}
+}
+
+// FailNext Make the next calls to an action, such as "CreateAccessKey", fail with the errors given, one per call.
+func (f *Fake) FailNext(action string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fails[action] = append(f.fails[action], errs...)
+}
+
+// Keys Get the keys a user has, in the order they were made.
+func (f *Fake) Keys(userName string) []types.AccessKeyMetadata {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		return nil
+	}
+
+	keys := make([]types.AccessKeyMetadata, 0, len(u.keys))
+	for _, k := range u.keys {
+		keys = append(keys, k.AccessKeyMetadata)
+	}
+
+	return keys
+}
+
+// Tags Get the tags of a user, sorted by key.
+func (f *Fake) Tags(userName string) []types.Tag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		return nil
+	}
+
+	return sortedTags(u.tags)
+}
+
+// Calls Get the actions called so far, in order, including those that failed.
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.calls...)
+}
+
+// Owner Get the user a key belongs to, empty when no user has it.
+func (f *Fake) Owner(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, _ := f.findKey(id)
+
+	return name
+}
+
+func (f *Fake) ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error) {
+	var out *iam.ListAccessKeysOutput
+	err := f.do(ctx, "ListAccessKeys", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		out = &iam.ListAccessKeysOutput{AccessKeyMetadata: make([]types.AccessKeyMetadata, 0, len(u.keys))}
+		for _, k := range u.keys {
+			out.AccessKeyMetadata = append(out.AccessKeyMetadata, k.AccessKeyMetadata)
+		}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error) {
+	var out *iam.CreateAccessKeyOutput
+	err := f.do(ctx, "CreateAccessKey", func() error {
+		name, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		if len(u.keys) >= f.quota() {
+			return APIError("LimitExceeded", "Cannot exceed quota for AccessKeysPerUser: %v", f.quota())
+		}
+
+		f.seq++
+		k := f.newKey(name, fmt.Sprintf("AKIAFAKE%012d", f.seq), f.now(), types.StatusTypeActive)
+		u.keys = append(u.keys, k)
+
+		out = &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+			AccessKeyId:     k.AccessKeyId,
+			SecretAccessKey: aws.String(k.secret),
+			Status:          k.Status,
+			UserName:        k.UserName,
+			CreateDate:      k.CreateDate,
+		}}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error) {
+	err := f.do(ctx, "DeleteAccessKey", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for i, k := range u.keys {
+			if aws.ToString(k.AccessKeyId) == aws.ToString(params.AccessKeyId) {
+				u.keys = append(u.keys[:i], u.keys[i+1:]...)
+				return nil
+			}
+		}
+
+		return noSuchKey(params.AccessKeyId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.DeleteAccessKeyOutput{}, nil
+}
+
+func (f *Fake) UpdateAccessKey(ctx context.Context, params *iam.UpdateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateAccessKeyOutput, error) {
+	err := f.do(ctx, "UpdateAccessKey", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range u.keys {
+			if aws.ToString(k.AccessKeyId) == aws.ToString(params.AccessKeyId) {
+				k.Status = params.Status
+				return nil
+			}
+		}
+
+		return noSuchKey(params.AccessKeyId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.UpdateAccessKeyOutput{}, nil
+}
+
+func (f *Fake) GetAccessKeyLastUsed(ctx context.Context, params *iam.GetAccessKeyLastUsedInput, optFns ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error) {
+	var out *iam.GetAccessKeyLastUsedOutput
+	err := f.do(ctx, "GetAccessKeyLastUsed", func() error {
+		name, k := f.findKey(aws.ToString(params.AccessKeyId))
+		if k == nil {
+			return noSuchKey(params.AccessKeyId)
+		}
+
+		out = &iam.GetAccessKeyLastUsedOutput{UserName: aws.String(name), AccessKeyLastUsed: k.lastUsed}
+		if out.AccessKeyLastUsed == nil {
+			out.AccessKeyLastUsed = &types.AccessKeyLastUsed{ServiceName: aws.String("N/A"), Region: aws.String("N/A")}
+		}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	var out *iam.GetUserOutput
+	err := f.do(ctx, "GetUser", func() error {
+		name, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		out = &iam.GetUserOutput{User: &types.User{
+			Arn:        aws.String(UserArn(name)),
+			CreateDate: aws.Time(u.created),
+			Path:       aws.String("/"),
+			UserId:     aws.String("AIDA" + strings.ToUpper(name)),
+			UserName:   aws.String(name),
+			Tags:       sortedTags(u.tags),
+		}}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error) {
+	var out *iam.ListUserTagsOutput
+	err := f.do(ctx, "ListUserTags", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		out = &iam.ListUserTagsOutput{Tags: sortedTags(u.tags)}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) TagUser(ctx context.Context, params *iam.TagUserInput, optFns ...func(*iam.Options)) (*iam.TagUserOutput, error) {
+	err := f.do(ctx, "TagUser", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range params.Tags {
+			u.tags = append(removeTag(u.tags, aws.ToString(t.Key)), t)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.TagUserOutput{}, nil
+}
+
+func (f *Fake) UntagUser(ctx context.Context, params *iam.UntagUserInput, optFns ...func(*iam.Options)) (*iam.UntagUserOutput, error) {
+	err := f.do(ctx, "UntagUser", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range params.TagKeys {
+			u.tags = removeTag(u.tags, k)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.UntagUserOutput{}, nil
+}
+
+// UserArn The ARN of a fake user.
+func UserArn(name string) string {
+	return "arn:aws:iam::" + AccountId + ":user/" + name
+}
+
+// do Record the call, wait out the latency, then fail it when asked to or else run fn while holding the lock.
+func (f *Fake) do(ctx context.Context, action string, fn func() error) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, action)
+	latency := f.Latency
+	f.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if errs := f.fails[action]; len(errs) > 0 {
+		f.fails[action] = errs[1:]
+		return errs[0]
+	}
+
+	return fn()
+}
+
+// user Get the user named, or the Caller when name is nil. Only call while holding the lock.
+func (f *Fake) user(name *string) (string, *user, error) {
+	n := aws.ToString(name)
+	if n == "" {
+		n = f.Caller
+	}
+
+	u, ok := f.users[n]
+	if !ok {
+		return n, nil, APIError("NoSuchEntity", "The user with name %v cannot be found.", n)
+	}
+
+	return n, u, nil
+}
+
+// findKey Get a key and the name of its user. Only call while holding the lock.
+func (f *Fake) findKey(id string) (string, *key) {
+	for name, u := range f.users {
+		for _, k := range u.keys {
+			if aws.ToString(k.AccessKeyId) == id {
+				return name, k
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (f *Fake) newKey(userName, id string, created time.Time, status types.StatusType) *key {
+	return &key{
+		AccessKeyMetadata: types.AccessKeyMetadata{
+			AccessKeyId: aws.String(id),
+			CreateDate:  aws.Time(created),
+			Status:      status,
+			UserName:    aws.String(userName),
+		},
+		secret: "fake/" + strings.ToLower(id),
+	}
+}
+
+func (f *Fake) now() time.Time {
+	if f.Now == nil {
+		return time.Now().UTC()
+	}
+
+	return f.Now()
+}
+
+func (f *Fake) quota() int {
+	if f.Quota <= 0 {
+		return DefaultQuota
+	}
+
+	return f.Quota
+}
+
+func noSuchKey(id *string) error {
+	return APIError("NoSuchEntity", "The Access Key with id %v cannot be found.", aws.ToString(id))
+}
+
+func removeTag(tags []types.Tag, k string) []types.Tag {
+	kept := make([]types.Tag, 0, len(tags))
+	for _, t := range tags {
+		if aws.ToString(t.Key) != k {
+			kept = append(kept, t)
+		}
+	}
+
+	return kept
+}
+
+func sortedTags(tags []types.Tag) []types.Tag {
+	sorted := append([]types.Tag{}, tags...)
+	sort.Slice(sorted, func(i, j int) bool { return aws.ToString(sorted[i].Key) < aws.ToString(sorted[j].Key) })
+
+	return sorted
+}
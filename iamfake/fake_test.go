@@ -0,0 +1,202 @@
+package iamfake
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+
+// newTestClients Serve a fake with the user bob, who has one key, and get IAM and STS clients signed in as bob.
+func newTestClients(t *testing.T) (*Fake, *iam.Client, *sts.Client) {
+	f := New()
+	f.Now = func() time.Time { return testNow }
+	secret := f.AddKey("bob", "AKIABOB0000000000001", testNow.AddDate(0, 0, -40), types.StatusTypeActive)
+
+	srv := NewServer(f)
+	t.Cleanup(srv.Close)
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), LoadOptions(srv.URL, "AKIABOB0000000000001", secret)...)
+	if err != nil {
+		t.Fatalf("could not load the AWS config: %v", err)
+	}
+
+	return f, iam.NewFromConfig(cfg, func(o *iam.Options) { o.Retryer = aws.NopRetryer{} }), sts.NewFromConfig(cfg)
+}
+
+func TestServerKeys(tester *testing.T) {
+	f, client, _ := newTestClients(tester)
+	ctx := context.TODO()
+
+	out, err1 := client.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{})
+	if err1 != nil {
+		tester.Fatalf("unexpected error: %v", err1)
+	}
+
+	newKey := out.AccessKey
+	if aws.ToString(newKey.UserName) != "bob" || aws.ToString(newKey.SecretAccessKey) == "" || !aws.ToTime(newKey.CreateDate).Equal(testNow) {
+		tester.Errorf("want a new key for bob made now with a secret, got %+v", newKey)
+	}
+
+	_, err2 := client.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{AccessKeyId: aws.String("AKIABOB0000000000001"), Status: types.StatusTypeInactive})
+	if err2 != nil {
+		tester.Fatalf("unexpected error: %v", err2)
+	}
+
+	lako, err3 := client.ListAccessKeys(ctx, &iam.ListAccessKeysInput{})
+	if err3 != nil {
+		tester.Fatalf("unexpected error: %v", err3)
+	}
+
+	if len(lako.AccessKeyMetadata) != 2 || lako.AccessKeyMetadata[0].Status != types.StatusTypeInactive {
+		tester.Fatalf("want 2 keys with the first inactive, got %+v", lako.AccessKeyMetadata)
+	}
+
+	if !aws.ToTime(lako.AccessKeyMetadata[0].CreateDate).Equal(testNow.AddDate(0, 0, -40)) {
+		tester.Errorf("want the create date of the first key kept, got %v", aws.ToTime(lako.AccessKeyMetadata[0].CreateDate))
+	}
+
+	if _, err := client.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{AccessKeyId: newKey.AccessKeyId}); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	if keys := f.Keys("bob"); len(keys) != 1 {
+		tester.Errorf("want 1 key left, got %v", len(keys))
+	}
+}
+
+func TestServerErrors(tester *testing.T) {
+	cases := []struct {
+		name     string
+		setup    func(f *Fake)
+		call     func(ctx context.Context, client *iam.Client) error
+		wantCode string
+	}{
+		{
+			"quota",
+			func(f *Fake) { f.AddKey("bob", "AKIABOB0000000000002", testNow, types.StatusTypeActive) },
+			func(ctx context.Context, client *iam.Client) error {
+				_, err := client.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{})
+				return err
+			},
+			"LimitExceeded",
+		},
+		{
+			"no_such_key",
+			func(f *Fake) {},
+			func(ctx context.Context, client *iam.Client) error {
+				_, err := client.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{AccessKeyId: aws.String("AKIANOPE")})
+				return err
+			},
+			"NoSuchEntity",
+		},
+		{
+			"no_such_user",
+			func(f *Fake) {},
+			func(ctx context.Context, client *iam.Client) error {
+				_, err := client.ListAccessKeys(ctx, &iam.ListAccessKeysInput{UserName: aws.String("carol")})
+				return err
+			},
+			"NoSuchEntity",
+		},
+		{
+			"injected",
+			func(f *Fake) { f.FailNext("ListAccessKeys", APIError("Throttling", "Rate exceeded")) },
+			func(ctx context.Context, client *iam.Client) error {
+				_, err := client.ListAccessKeys(ctx, &iam.ListAccessKeysInput{})
+				return err
+			},
+			"Throttling",
+		},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f, client, _ := newTestClients(t)
+			test.setup(f)
+
+			err := test.call(context.TODO(), client)
+
+			var ae smithy.APIError
+			if !errors.As(err, &ae) || ae.ErrorCode() != test.wantCode {
+				t.Errorf("want error code %v, got %v", test.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestServerTags(tester *testing.T) {
+	f, client, stsClient := newTestClients(tester)
+	ctx := context.TODO()
+
+	_, err1 := client.TagUser(ctx, &iam.TagUserInput{
+		UserName: aws.String("bob"),
+		Tags:     []types.Tag{{Key: aws.String("owner"), Value: aws.String("bob@example.com")}, {Key: aws.String("team"), Value: aws.String("ops")}},
+	})
+	if err1 != nil {
+		tester.Fatalf("unexpected error: %v", err1)
+	}
+
+	if _, err := client.UntagUser(ctx, &iam.UntagUserInput{UserName: aws.String("bob"), TagKeys: []string{"team"}}); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	luto, err2 := client.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: aws.String("bob")})
+	if err2 != nil {
+		tester.Fatalf("unexpected error: %v", err2)
+	}
+
+	if len(luto.Tags) != 1 || aws.ToString(luto.Tags[0].Value) != "bob@example.com" || len(f.Tags("bob")) != 1 {
+		tester.Errorf("want only the owner tag, got %+v", luto.Tags)
+	}
+
+	gcio, err3 := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err3 != nil {
+		tester.Fatalf("unexpected error: %v", err3)
+	}
+
+	if aws.ToString(gcio.Arn) != UserArn("bob") || aws.ToString(gcio.Account) != AccountId {
+		tester.Errorf("want the ARN of bob, got %v", aws.ToString(gcio.Arn))
+	}
+}
+
+func TestFakeLatency(tester *testing.T) {
+	f := New()
+	f.AddUser("bob")
+	f.Latency = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := f.ListAccessKeys(ctx, &iam.ListAccessKeysInput{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		tester.Errorf("want a deadline exceeded error, got %v", err)
+	}
+
+	if calls := f.Calls(); len(calls) != 1 || calls[0] != "ListAccessKeys" {
+		tester.Errorf("want the call recorded, got %v", calls)
+	}
+}
+
+func TestFakeLastUsed(tester *testing.T) {
+	f := New()
+	f.AddKey("bob", "AKIABOB0000000000001", testNow, types.StatusTypeActive)
+	f.SetLastUsed("AKIABOB0000000000001", testNow.Add(time.Hour), "s3", "us-east-1")
+
+	out, err := f.GetAccessKeyLastUsed(context.TODO(), &iam.GetAccessKeyLastUsedInput{AccessKeyId: aws.String("AKIABOB0000000000001")})
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	if aws.ToString(out.UserName) != "bob" || aws.ToString(out.AccessKeyLastUsed.ServiceName) != "s3" {
+		tester.Errorf("want bob last used s3, got %+v", out.AccessKeyLastUsed)
+	}
+}
@@ -0,0 +1,332 @@
+package iamfake
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"time"
+)
+
+const (
+	iamXmlns = "https://iam.amazonaws.com/doc/2010-05-08/"
+	stsXmlns = "https://sts.amazonaws.com/doc/2011-06-15/"
+	// dateFormat How IAM writes dates.
+	dateFormat = "2006-01-02T15:04:05Z"
+	requestId  = "00000000-0000-0000-0000-000000000000"
+)
+
+// errorStatus The HTTP status IAM responds with for each error code, anything else is a 400.
+var errorStatus = map[string]int{
+	"AccessDenied":       http.StatusForbidden,
+	"InternalError":      http.StatusInternalServerError,
+	"InternalFailure":    http.StatusInternalServerError,
+	"LimitExceeded":      http.StatusConflict,
+	"NoSuchEntity":       http.StatusNotFound,
+	"ServiceFailure":     http.StatusInternalServerError,
+	"ServiceUnavailable": http.StatusServiceUnavailable,
+}
+
+// credentialRe Finds the access key ID in a Signature Version 4 Authorization header.
+var credentialRe = regexp.MustCompile(`Credential=([^/]+)/`)
+
+// NewServer Serve the fake over the IAM Query API, and STS GetCallerIdentity, on a local port. Close it when done.
+func NewServer(f *Fake) *httptest.Server {
+	return httptest.NewServer(f.Handler())
+}
+
+// LoadOptions Options for config.LoadDefaultConfig that send IAM and STS calls to the server at url, signed with the
+// key given. Calls without a user name act on the user who owns that key.
+func LoadOptions(url, accessKeyId, secret string) []func(*config.LoadOptions) error {
+	return []func(*config.LoadOptions) error{
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, secret, "")),
+		config.WithEndpointResolver(aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: url, SigningRegion: region, HostnameImmutable: true}, nil
+		})),
+	}
+}
+
+// Handler Answer IAM Query API requests with the fake.
+func (f *Fake) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeError(w, iamXmlns, APIError("MalformedInput", "%v", err))
+			return
+		}
+
+		action := r.Form.Get("Action")
+		ctx := r.Context()
+
+		// Like IAM, act on the user who signed the request when no user is named.
+		userName := aws.String(r.Form.Get("UserName"))
+		if *userName == "" {
+			if m := credentialRe.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+				if owner := f.Owner(m[1]); owner != "" {
+					userName = aws.String(owner)
+				}
+			}
+		}
+
+		xmlns := iamXmlns
+		if action == "GetCallerIdentity" {
+			xmlns = stsXmlns
+		}
+
+		result, err := f.serve(ctx, action, userName, r)
+		if err != nil {
+			writeError(w, xmlns, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/xml")
+		_ = xml.NewEncoder(w).Encode(envelope{
+			XMLName:   xml.Name{Local: action + "Response"},
+			Xmlns:     xmlns,
+			Result:    result,
+			RequestId: requestId,
+		})
+	})
+}
+
+// serve Call the fake for an action, returning what goes in the result element of the response, if anything.
+func (f *Fake) serve(ctx context.Context, action string, userName *string, r *http.Request) (interface{}, error) {
+	keyId := aws.String(r.Form.Get("AccessKeyId"))
+
+	switch action {
+	case "ListAccessKeys":
+		out, err := f.ListAccessKeys(ctx, &iam.ListAccessKeysInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		res := listAccessKeysResult{UserName: aws.ToString(userName), AccessKeyMetadata: make([]accessKeyXml, 0)}
+		for _, k := range out.AccessKeyMetadata {
+			res.AccessKeyMetadata = append(res.AccessKeyMetadata, accessKeyXml{
+				UserName:    aws.ToString(k.UserName),
+				AccessKeyId: aws.ToString(k.AccessKeyId),
+				Status:      string(k.Status),
+				CreateDate:  formatDate(k.CreateDate),
+			})
+		}
+
+		return res, nil
+	case "CreateAccessKey":
+		out, err := f.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		k := out.AccessKey
+
+		return createAccessKeyResult{AccessKey: accessKeyXml{
+			UserName:        aws.ToString(k.UserName),
+			AccessKeyId:     aws.ToString(k.AccessKeyId),
+			Status:          string(k.Status),
+			SecretAccessKey: aws.ToString(k.SecretAccessKey),
+			CreateDate:      formatDate(k.CreateDate),
+		}}, nil
+	case "DeleteAccessKey":
+		_, err := f.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{UserName: userName, AccessKeyId: keyId})
+		return nil, err
+	case "UpdateAccessKey":
+		status := types.StatusType(r.Form.Get("Status"))
+		_, err := f.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{UserName: userName, AccessKeyId: keyId, Status: status})
+		return nil, err
+	case "GetAccessKeyLastUsed":
+		out, err := f.GetAccessKeyLastUsed(ctx, &iam.GetAccessKeyLastUsedInput{AccessKeyId: keyId})
+		if err != nil {
+			return nil, err
+		}
+
+		lu := out.AccessKeyLastUsed
+
+		return getAccessKeyLastUsedResult{
+			UserName:     aws.ToString(out.UserName),
+			LastUsedDate: formatDate(lu.LastUsedDate),
+			ServiceName:  aws.ToString(lu.ServiceName),
+			Region:       aws.ToString(lu.Region),
+		}, nil
+	case "GetUser":
+		out, err := f.GetUser(ctx, &iam.GetUserInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		u := out.User
+
+		return getUserResult{
+			Path:       aws.ToString(u.Path),
+			UserName:   aws.ToString(u.UserName),
+			UserId:     aws.ToString(u.UserId),
+			Arn:        aws.ToString(u.Arn),
+			CreateDate: formatDate(u.CreateDate),
+			Tags:       tagsXml(u.Tags),
+		}, nil
+	case "ListUserTags":
+		out, err := f.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		return listUserTagsResult{Tags: tagsXml(out.Tags)}, nil
+	case "TagUser":
+		tags := make([]types.Tag, 0)
+		for i := 1; r.Form.Get(fmt.Sprintf("Tags.member.%d.Key", i)) != ""; i++ {
+			tags = append(tags, types.Tag{
+				Key:   aws.String(r.Form.Get(fmt.Sprintf("Tags.member.%d.Key", i))),
+				Value: aws.String(r.Form.Get(fmt.Sprintf("Tags.member.%d.Value", i))),
+			})
+		}
+
+		_, err := f.TagUser(ctx, &iam.TagUserInput{UserName: userName, Tags: tags})
+		return nil, err
+	case "UntagUser":
+		keys := make([]string, 0)
+		for i := 1; r.Form.Get(fmt.Sprintf("TagKeys.member.%d", i)) != ""; i++ {
+			keys = append(keys, r.Form.Get(fmt.Sprintf("TagKeys.member.%d", i)))
+		}
+
+		_, err := f.UntagUser(ctx, &iam.UntagUserInput{UserName: userName, TagKeys: keys})
+		return nil, err
+	case "ListSSHPublicKeys", "UploadSSHPublicKey", "GetSSHPublicKey", "UpdateSSHPublicKey", "DeleteSSHPublicKey":
+		return f.serveSSH(ctx, action, userName, r)
+	case "ListServiceSpecificCredentials", "CreateServiceSpecificCredential", "ResetServiceSpecificCredential", "DeleteServiceSpecificCredential":
+		return f.serveServiceCredential(ctx, action, userName, r)
+	case "GetCallerIdentity":
+		out, err := f.GetUser(ctx, &iam.GetUserInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		return getCallerIdentityResult{
+			Arn:     aws.ToString(out.User.Arn),
+			UserId:  aws.ToString(out.User.UserId),
+			Account: AccountId,
+		}, nil
+	}
+
+	return nil, APIError("InvalidAction", "The action %v is not valid for this web service.", action)
+}
+
+// writeError Respond with an error document, as the AWS SDKs expect from the Query API.
+func writeError(w http.ResponseWriter, xmlns string, err error) {
+	res := errorResponse{Xmlns: xmlns, Type: "Sender", Code: "InternalFailure", Message: err.Error(), RequestId: requestId}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		res.Code = ae.ErrorCode()
+		res.Message = ae.ErrorMessage()
+	}
+
+	status, ok := errorStatus[res.Code]
+	if !ok {
+		status = http.StatusBadRequest
+	}
+
+	if status >= 500 {
+		res.Type = "Receiver"
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(dateFormat)
+}
+
+func tagsXml(tags []types.Tag) []tagXml {
+	res := make([]tagXml, 0, len(tags))
+	for _, t := range tags {
+		res = append(res, tagXml{aws.ToString(t.Key), aws.ToString(t.Value)})
+	}
+
+	return res
+}
+
+type envelope struct {
+	XMLName   xml.Name
+	Xmlns     string      `xml:"xmlns,attr"`
+	Result    interface{} `xml:",omitempty"`
+	RequestId string      `xml:"ResponseMetadata>RequestId"`
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestId string
+}
+
+type accessKeyXml struct {
+	UserName        string
+	AccessKeyId     string
+	Status          string
+	SecretAccessKey string `xml:",omitempty"`
+	CreateDate      string
+}
+
+type tagXml struct {
+	Key   string
+	Value string
+}
+
+type listAccessKeysResult struct {
+	XMLName           xml.Name `xml:"ListAccessKeysResult"`
+	UserName          string
+	AccessKeyMetadata []accessKeyXml `xml:"AccessKeyMetadata>member"`
+	IsTruncated       bool
+}
+
+type createAccessKeyResult struct {
+	XMLName   xml.Name `xml:"CreateAccessKeyResult"`
+	AccessKey accessKeyXml
+}
+
+type getAccessKeyLastUsedResult struct {
+	XMLName      xml.Name `xml:"GetAccessKeyLastUsedResult"`
+	UserName     string
+	LastUsedDate string `xml:"AccessKeyLastUsed>LastUsedDate,omitempty"`
+	ServiceName  string `xml:"AccessKeyLastUsed>ServiceName"`
+	Region       string `xml:"AccessKeyLastUsed>Region"`
+}
+
+type getUserResult struct {
+	XMLName    xml.Name `xml:"GetUserResult"`
+	Path       string   `xml:"User>Path"`
+	UserName   string   `xml:"User>UserName"`
+	UserId     string   `xml:"User>UserId"`
+	Arn        string   `xml:"User>Arn"`
+	CreateDate string   `xml:"User>CreateDate"`
+	Tags       []tagXml `xml:"User>Tags>member"`
+}
+
+type listUserTagsResult struct {
+	XMLName     xml.Name `xml:"ListUserTagsResult"`
+	Tags        []tagXml `xml:"Tags>member"`
+	IsTruncated bool
+}
+
+type getCallerIdentityResult struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResult"`
+	Arn     string
+	UserId  string
+	Account string
+}
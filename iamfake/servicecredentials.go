@@ -0,0 +1,276 @@
+package iamfake
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServiceCredentialQuota The most service-specific credentials IAM lets a user have for each service.
+const ServiceCredentialQuota = 2
+
+// AddServiceCredential Give a user a credential for a service made at the time given, adding the user when needed.
+// The password of the credential is returned.
+func (f *Fake) AddServiceCredential(userName, service, id string, created time.Time, status types.StatusType) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		u = &user{created: created}
+		f.users[userName] = u
+		if f.Caller == "" {
+			f.Caller = userName
+		}
+	}
+
+	c := newServiceCredential(userName, service, id, created, status)
+	u.serviceCreds = append(u.serviceCreds, c)
+
+	return aws.ToString(c.ServicePassword)
+}
+
+// ServiceCredentials Get the credentials a user has for a service, in the order they were made, without passwords.
+func (f *Fake) ServiceCredentials(userName, service string) []types.ServiceSpecificCredentialMetadata {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		return nil
+	}
+
+	return serviceCredentialList(u, service)
+}
+
+func (f *Fake) ListServiceSpecificCredentials(ctx context.Context, params *iam.ListServiceSpecificCredentialsInput, optFns ...func(*iam.Options)) (*iam.ListServiceSpecificCredentialsOutput, error) {
+	var out *iam.ListServiceSpecificCredentialsOutput
+	err := f.do(ctx, "ListServiceSpecificCredentials", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		out = &iam.ListServiceSpecificCredentialsOutput{
+			ServiceSpecificCredentials: serviceCredentialList(u, aws.ToString(params.ServiceName)),
+		}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) CreateServiceSpecificCredential(ctx context.Context, params *iam.CreateServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.CreateServiceSpecificCredentialOutput, error) {
+	var out *iam.CreateServiceSpecificCredentialOutput
+	err := f.do(ctx, "CreateServiceSpecificCredential", func() error {
+		name, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		service := aws.ToString(params.ServiceName)
+		if len(serviceCredentialList(u, service)) >= ServiceCredentialQuota {
+			return APIError("LimitExceeded", "Cannot exceed quota for ServiceSpecificCredentialsPerUserPerService: %v", ServiceCredentialQuota)
+		}
+
+		f.seq++
+		c := newServiceCredential(name, service, fmt.Sprintf("ACCAFAKE%012d", f.seq), f.now(), types.StatusTypeActive)
+		u.serviceCreds = append(u.serviceCreds, c)
+
+		copied := *c
+		out = &iam.CreateServiceSpecificCredentialOutput{ServiceSpecificCredential: &copied}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) ResetServiceSpecificCredential(ctx context.Context, params *iam.ResetServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.ResetServiceSpecificCredentialOutput, error) {
+	var out *iam.ResetServiceSpecificCredentialOutput
+	err := f.do(ctx, "ResetServiceSpecificCredential", func() error {
+		c, err := f.serviceCredential(params.UserName, params.ServiceSpecificCredentialId)
+		if err != nil {
+			return err
+		}
+
+		f.seq++
+		c.ServicePassword = aws.String(fmt.Sprintf("fake/reset-%v", f.seq))
+
+		copied := *c
+		out = &iam.ResetServiceSpecificCredentialOutput{ServiceSpecificCredential: &copied}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) DeleteServiceSpecificCredential(ctx context.Context, params *iam.DeleteServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.DeleteServiceSpecificCredentialOutput, error) {
+	err := f.do(ctx, "DeleteServiceSpecificCredential", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for i, c := range u.serviceCreds {
+			if aws.ToString(c.ServiceSpecificCredentialId) == aws.ToString(params.ServiceSpecificCredentialId) {
+				u.serviceCreds = append(u.serviceCreds[:i], u.serviceCreds[i+1:]...)
+				return nil
+			}
+		}
+
+		return noSuchServiceCredential(params.ServiceSpecificCredentialId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.DeleteServiceSpecificCredentialOutput{}, nil
+}
+
+// serviceCredential Get a credential of a user. Only call while holding the lock.
+func (f *Fake) serviceCredential(userName, id *string) (*types.ServiceSpecificCredential, error) {
+	_, u, err := f.user(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range u.serviceCreds {
+		if aws.ToString(c.ServiceSpecificCredentialId) == aws.ToString(id) {
+			return c, nil
+		}
+	}
+
+	return nil, noSuchServiceCredential(id)
+}
+
+// serveServiceCredential Call the fake for an action on service-specific credentials, returning what goes in the
+// result element of the response.
+func (f *Fake) serveServiceCredential(ctx context.Context, action string, userName *string, r *http.Request) (interface{}, error) {
+	id := aws.String(r.Form.Get("ServiceSpecificCredentialId"))
+	service := aws.String(r.Form.Get("ServiceName"))
+
+	switch action {
+	case "ListServiceSpecificCredentials":
+		out, err := f.ListServiceSpecificCredentials(ctx, &iam.ListServiceSpecificCredentialsInput{UserName: userName, ServiceName: service})
+		if err != nil {
+			return nil, err
+		}
+
+		res := listServiceSpecificCredentialsResult{ServiceSpecificCredentials: make([]serviceCredentialXml, 0)}
+		for _, c := range out.ServiceSpecificCredentials {
+			res.ServiceSpecificCredentials = append(res.ServiceSpecificCredentials, serviceCredentialXml{
+				UserName:                    aws.ToString(c.UserName),
+				ServiceName:                 aws.ToString(c.ServiceName),
+				ServiceUserName:             aws.ToString(c.ServiceUserName),
+				ServiceSpecificCredentialId: aws.ToString(c.ServiceSpecificCredentialId),
+				Status:                      string(c.Status),
+				CreateDate:                  formatDate(c.CreateDate),
+			})
+		}
+
+		return res, nil
+	case "CreateServiceSpecificCredential":
+		out, err := f.CreateServiceSpecificCredential(ctx, &iam.CreateServiceSpecificCredentialInput{UserName: userName, ServiceName: service})
+		if err != nil {
+			return nil, err
+		}
+
+		return createServiceSpecificCredentialResult{ServiceSpecificCredential: newServiceCredentialXml(out.ServiceSpecificCredential)}, nil
+	case "ResetServiceSpecificCredential":
+		out, err := f.ResetServiceSpecificCredential(ctx, &iam.ResetServiceSpecificCredentialInput{UserName: userName, ServiceSpecificCredentialId: id})
+		if err != nil {
+			return nil, err
+		}
+
+		return resetServiceSpecificCredentialResult{ServiceSpecificCredential: newServiceCredentialXml(out.ServiceSpecificCredential)}, nil
+	case "DeleteServiceSpecificCredential":
+		_, err := f.DeleteServiceSpecificCredential(ctx, &iam.DeleteServiceSpecificCredentialInput{UserName: userName, ServiceSpecificCredentialId: id})
+		return nil, err
+	}
+
+	return nil, APIError("InvalidAction", "The action %v is not valid for this web service.", action)
+}
+
+// serviceCredentialList Get the credentials of a user for a service, or every service when it is empty.
+func serviceCredentialList(u *user, service string) []types.ServiceSpecificCredentialMetadata {
+	list := make([]types.ServiceSpecificCredentialMetadata, 0, len(u.serviceCreds))
+	for _, c := range u.serviceCreds {
+		if service != "" && aws.ToString(c.ServiceName) != service {
+			continue
+		}
+
+		list = append(list, types.ServiceSpecificCredentialMetadata{
+			CreateDate:                  c.CreateDate,
+			ServiceName:                 c.ServiceName,
+			ServiceSpecificCredentialId: c.ServiceSpecificCredentialId,
+			ServiceUserName:             c.ServiceUserName,
+			Status:                      c.Status,
+			UserName:                    c.UserName,
+		})
+	}
+
+	return list
+}
+
+// newServiceCredential Make a credential, its service user name is the user name and account, like IAM makes them.
+func newServiceCredential(userName, service, id string, created time.Time, status types.StatusType) *types.ServiceSpecificCredential {
+	return &types.ServiceSpecificCredential{
+		CreateDate:                  aws.Time(created),
+		ServiceName:                 aws.String(service),
+		ServicePassword:             aws.String("fake/" + strings.ToLower(id)),
+		ServiceSpecificCredentialId: aws.String(id),
+		ServiceUserName:             aws.String(userName + "-at-" + AccountId),
+		Status:                      status,
+		UserName:                    aws.String(userName),
+	}
+}
+
+func noSuchServiceCredential(id *string) error {
+	return APIError("NoSuchEntity", "No such credential %v exists.", aws.ToString(id))
+}
+
+func newServiceCredentialXml(c *types.ServiceSpecificCredential) serviceCredentialXml {
+	return serviceCredentialXml{
+		UserName:                    aws.ToString(c.UserName),
+		ServiceName:                 aws.ToString(c.ServiceName),
+		ServiceUserName:             aws.ToString(c.ServiceUserName),
+		ServicePassword:             aws.ToString(c.ServicePassword),
+		ServiceSpecificCredentialId: aws.ToString(c.ServiceSpecificCredentialId),
+		Status:                      string(c.Status),
+		CreateDate:                  formatDate(c.CreateDate),
+	}
+}
+
+type serviceCredentialXml struct {
+	UserName                    string
+	ServiceName                 string
+	ServiceUserName             string
+	ServicePassword             string `xml:",omitempty"`
+	ServiceSpecificCredentialId string
+	Status                      string
+	CreateDate                  string
+}
+
+type listServiceSpecificCredentialsResult struct {
+	XMLName                    xml.Name               `xml:"ListServiceSpecificCredentialsResult"`
+	ServiceSpecificCredentials []serviceCredentialXml `xml:"ServiceSpecificCredentials>member"`
+}
+
+type createServiceSpecificCredentialResult struct {
+	XMLName                   xml.Name `xml:"CreateServiceSpecificCredentialResult"`
+	ServiceSpecificCredential serviceCredentialXml
+}
+
+type resetServiceSpecificCredentialResult struct {
+	XMLName                   xml.Name `xml:"ResetServiceSpecificCredentialResult"`
+	ServiceSpecificCredential serviceCredentialXml
+}
@@ -0,0 +1,290 @@
+package iamfake
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"golang.org/x/crypto/ssh"
+	"net/http"
+	"time"
+)
+
+// SSHQuota The most SSH public keys IAM lets a user have.
+const SSHQuota = 5
+
+// AddSSHKey Give a user an SSH public key uploaded at the time given, adding the user when needed. The body need not
+// be a valid key.
+func (f *Fake) AddSSHKey(userName, id, body string, uploaded time.Time, status types.StatusType) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		u = &user{created: uploaded}
+		f.users[userName] = u
+		if f.Caller == "" {
+			f.Caller = userName
+		}
+	}
+
+	u.sshKeys = append(u.sshKeys, &types.SSHPublicKey{
+		SSHPublicKeyId:   aws.String(id),
+		SSHPublicKeyBody: aws.String(body),
+		Fingerprint:      aws.String(""),
+		Status:           status,
+		UploadDate:       aws.Time(uploaded),
+		UserName:         aws.String(userName),
+	})
+}
+
+// SSHKeys Get the SSH public keys a user has, in the order they were uploaded.
+func (f *Fake) SSHKeys(userName string) []types.SSHPublicKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	u, ok := f.users[userName]
+	if !ok {
+		return nil
+	}
+
+	keys := make([]types.SSHPublicKey, 0, len(u.sshKeys))
+	for _, k := range u.sshKeys {
+		keys = append(keys, *k)
+	}
+
+	return keys
+}
+
+func (f *Fake) ListSSHPublicKeys(ctx context.Context, params *iam.ListSSHPublicKeysInput, optFns ...func(*iam.Options)) (*iam.ListSSHPublicKeysOutput, error) {
+	var out *iam.ListSSHPublicKeysOutput
+	err := f.do(ctx, "ListSSHPublicKeys", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		out = &iam.ListSSHPublicKeysOutput{SSHPublicKeys: make([]types.SSHPublicKeyMetadata, 0, len(u.sshKeys))}
+		for _, k := range u.sshKeys {
+			out.SSHPublicKeys = append(out.SSHPublicKeys, types.SSHPublicKeyMetadata{
+				SSHPublicKeyId: k.SSHPublicKeyId,
+				Status:         k.Status,
+				UploadDate:     k.UploadDate,
+				UserName:       k.UserName,
+			})
+		}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) UploadSSHPublicKey(ctx context.Context, params *iam.UploadSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UploadSSHPublicKeyOutput, error) {
+	var out *iam.UploadSSHPublicKeyOutput
+	err := f.do(ctx, "UploadSSHPublicKey", func() error {
+		name, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		body := aws.ToString(params.SSHPublicKeyBody)
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body))
+		if err != nil {
+			return APIError("InvalidPublicKey", "The public key is not valid: %v", err)
+		}
+
+		for _, k := range u.sshKeys {
+			if aws.ToString(k.SSHPublicKeyBody) == body {
+				return APIError("DuplicateSSHPublicKey", "The public key is already uploaded.")
+			}
+		}
+
+		if len(u.sshKeys) >= SSHQuota {
+			return APIError("LimitExceeded", "Cannot exceed quota for SSHPublicKeysPerUser: %v", SSHQuota)
+		}
+
+		f.seq++
+		k := &types.SSHPublicKey{
+			SSHPublicKeyId:   aws.String(fmt.Sprintf("APKAFAKE%012d", f.seq)),
+			SSHPublicKeyBody: aws.String(body),
+			Fingerprint:      aws.String(ssh.FingerprintLegacyMD5(pub)),
+			Status:           types.StatusTypeActive,
+			UploadDate:       aws.Time(f.now()),
+			UserName:         aws.String(name),
+		}
+		u.sshKeys = append(u.sshKeys, k)
+
+		copied := *k
+		out = &iam.UploadSSHPublicKeyOutput{SSHPublicKey: &copied}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) GetSSHPublicKey(ctx context.Context, params *iam.GetSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.GetSSHPublicKeyOutput, error) {
+	var out *iam.GetSSHPublicKeyOutput
+	err := f.do(ctx, "GetSSHPublicKey", func() error {
+		k, err := f.sshKey(params.UserName, params.SSHPublicKeyId)
+		if err != nil {
+			return err
+		}
+
+		copied := *k
+		out = &iam.GetSSHPublicKeyOutput{SSHPublicKey: &copied}
+
+		return nil
+	})
+
+	return out, err
+}
+
+func (f *Fake) UpdateSSHPublicKey(ctx context.Context, params *iam.UpdateSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateSSHPublicKeyOutput, error) {
+	err := f.do(ctx, "UpdateSSHPublicKey", func() error {
+		k, err := f.sshKey(params.UserName, params.SSHPublicKeyId)
+		if err != nil {
+			return err
+		}
+
+		k.Status = params.Status
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.UpdateSSHPublicKeyOutput{}, nil
+}
+
+func (f *Fake) DeleteSSHPublicKey(ctx context.Context, params *iam.DeleteSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteSSHPublicKeyOutput, error) {
+	err := f.do(ctx, "DeleteSSHPublicKey", func() error {
+		_, u, err := f.user(params.UserName)
+		if err != nil {
+			return err
+		}
+
+		for i, k := range u.sshKeys {
+			if aws.ToString(k.SSHPublicKeyId) == aws.ToString(params.SSHPublicKeyId) {
+				u.sshKeys = append(u.sshKeys[:i], u.sshKeys[i+1:]...)
+				return nil
+			}
+		}
+
+		return noSuchSSHKey(params.SSHPublicKeyId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &iam.DeleteSSHPublicKeyOutput{}, nil
+}
+
+// sshKey Get an SSH public key of a user. Only call while holding the lock.
+func (f *Fake) sshKey(userName, id *string) (*types.SSHPublicKey, error) {
+	_, u, err := f.user(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range u.sshKeys {
+		if aws.ToString(k.SSHPublicKeyId) == aws.ToString(id) {
+			return k, nil
+		}
+	}
+
+	return nil, noSuchSSHKey(id)
+}
+
+// serveSSH Call the fake for an action on SSH public keys, returning what goes in the result element of the response.
+func (f *Fake) serveSSH(ctx context.Context, action string, userName *string, r *http.Request) (interface{}, error) {
+	keyId := aws.String(r.Form.Get("SSHPublicKeyId"))
+
+	switch action {
+	case "ListSSHPublicKeys":
+		out, err := f.ListSSHPublicKeys(ctx, &iam.ListSSHPublicKeysInput{UserName: userName})
+		if err != nil {
+			return nil, err
+		}
+
+		res := listSSHPublicKeysResult{SSHPublicKeys: make([]sshPublicKeyXml, 0)}
+		for _, k := range out.SSHPublicKeys {
+			res.SSHPublicKeys = append(res.SSHPublicKeys, sshPublicKeyXml{
+				UserName:       aws.ToString(k.UserName),
+				SSHPublicKeyId: aws.ToString(k.SSHPublicKeyId),
+				Status:         string(k.Status),
+				UploadDate:     formatDate(k.UploadDate),
+			})
+		}
+
+		return res, nil
+	case "UploadSSHPublicKey":
+		body := aws.String(r.Form.Get("SSHPublicKeyBody"))
+		out, err := f.UploadSSHPublicKey(ctx, &iam.UploadSSHPublicKeyInput{UserName: userName, SSHPublicKeyBody: body})
+		if err != nil {
+			return nil, err
+		}
+
+		return uploadSSHPublicKeyResult{SSHPublicKey: newSSHPublicKeyXml(out.SSHPublicKey)}, nil
+	case "GetSSHPublicKey":
+		out, err := f.GetSSHPublicKey(ctx, &iam.GetSSHPublicKeyInput{UserName: userName, SSHPublicKeyId: keyId})
+		if err != nil {
+			return nil, err
+		}
+
+		return getSSHPublicKeyResult{SSHPublicKey: newSSHPublicKeyXml(out.SSHPublicKey)}, nil
+	case "UpdateSSHPublicKey":
+		status := types.StatusType(r.Form.Get("Status"))
+		_, err := f.UpdateSSHPublicKey(ctx, &iam.UpdateSSHPublicKeyInput{UserName: userName, SSHPublicKeyId: keyId, Status: status})
+		return nil, err
+	case "DeleteSSHPublicKey":
+		_, err := f.DeleteSSHPublicKey(ctx, &iam.DeleteSSHPublicKeyInput{UserName: userName, SSHPublicKeyId: keyId})
+		return nil, err
+	}
+
+	return nil, APIError("InvalidAction", "The action %v is not valid for this web service.", action)
+}
+
+func noSuchSSHKey(id *string) error {
+	return APIError("NoSuchEntity", "The Public Key with id %v cannot be found.", aws.ToString(id))
+}
+
+func newSSHPublicKeyXml(k *types.SSHPublicKey) sshPublicKeyXml {
+	return sshPublicKeyXml{
+		UserName:         aws.ToString(k.UserName),
+		SSHPublicKeyId:   aws.ToString(k.SSHPublicKeyId),
+		Fingerprint:      aws.ToString(k.Fingerprint),
+		SSHPublicKeyBody: aws.ToString(k.SSHPublicKeyBody),
+		Status:           string(k.Status),
+		UploadDate:       formatDate(k.UploadDate),
+	}
+}
+
+type sshPublicKeyXml struct {
+	UserName         string
+	SSHPublicKeyId   string
+	Fingerprint      string `xml:",omitempty"`
+	SSHPublicKeyBody string `xml:",omitempty"`
+	Status           string
+	UploadDate       string
+}
+
+type listSSHPublicKeysResult struct {
+	XMLName       xml.Name          `xml:"ListSSHPublicKeysResult"`
+	SSHPublicKeys []sshPublicKeyXml `xml:"SSHPublicKeys>member"`
+	IsTruncated   bool
+}
+
+type uploadSSHPublicKeyResult struct {
+	XMLName      xml.Name `xml:"UploadSSHPublicKeyResult"`
+	SSHPublicKey sshPublicKeyXml
+}
+
+type getSSHPublicKeyResult struct {
+	XMLName      xml.Name `xml:"GetSSHPublicKeyResult"`
+	SSHPublicKey sshPublicKeyXml
+}
@@ -0,0 +1,15 @@
+package main
+
+var errMsgs = struct {
+	configErr,
+	parameterErr,
+	policyInvalid,
+	putSecretErr,
+	usersMissing string
+}{
+	configErr:     "could not get AWS configuration with default methods; %v",
+	parameterErr:  "could not get the policy from SSM parameter %q; %v",
+	policyInvalid: "the policy is not valid; %v",
+	putSecretErr:  "could not write secret %q; %w",
+	usersMissing:  "the policy lists no users to rotate, set %v or %v",
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"log"
+	"strings"
+	"time"
+)
+
+// handler Rotates the keys of every user in the policy when invoked, usually by an EventBridge schedule.
+type handler struct {
+	iam rotator.IAMClient
+	// tags Records rotations in tags of the users, nil to leave the tags alone.
+	tags    rotator.TagClient
+	secrets secretsClient
+	params  parameterGetter
+	getenv  func(string) string
+	clock   rotator.Clock
+	log     rotator.Logger
+}
+
+// report What happened to each user, returned as the result of the invocation.
+type report struct {
+	EventId string       `json:"eventId"`
+	Time    time.Time    `json:"time"`
+	Users   []userReport `json:"users"`
+	Failed  int          `json:"failed"`
+}
+
+type userReport struct {
+	User       string `json:"user"`
+	Secret     string `json:"secret"`
+	Rotated    bool   `json:"rotated"`
+	NewKeyId   string `json:"newKeyId,omitempty"`
+	DaysOld    int    `json:"daysOld"`
+	Warn       bool   `json:"warn"`
+	RolledBack bool   `json:"rolledBack"`
+	Error      string `json:"error,omitempty"`
+	// UnmanagedKeys The masked IDs of keys made outside the rotator.
+	UnmanagedKeys []string      `json:"unmanagedKeys,omitempty"`
+	Stages        []stageReport `json:"stages"`
+}
+
+type stageReport struct {
+	Name     string `json:"name"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// lambdaLogger Writes logfmt lines to CloudWatch Logs through the standard log package, masking anything that looks
+// like a secret.
+type lambdaLogger struct{}
+
+func (lambdaLogger) Debug(msg string, kv ...interface{}) { logLine("debug", msg, kv) }
+func (lambdaLogger) Info(msg string, kv ...interface{})  { logLine("info", msg, kv) }
+func (lambdaLogger) Warn(msg string, kv ...interface{})  { logLine("warn", msg, kv) }
+func (lambdaLogger) Error(msg string, kv ...interface{}) { logLine("error", msg, kv) }
+
+func logLine(level, msg string, kv []interface{}) {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "level=%v msg=%q", level, rotator.Redact(msg))
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(b, " %v=%q", kv[i], rotator.Redact(fmt.Sprint(kv[i+1])))
+	}
+
+	log.Println(b.String())
+}
+
+// handle Load the policy then rotate each user in turn. A user that fails does not stop the others, their errors are
+// in the report. An error is only returned when the policy cannot be loaded.
+func (h *handler) handle(ctx context.Context, event events.CloudWatchEvent) (*report, error) {
+	p, err1 := loadPolicy(ctx, h.getenv, h.params)
+	if err1 != nil {
+		return nil, err1
+	}
+
+	rep := &report{EventId: event.ID, Time: event.Time, Users: make([]userReport, 0, len(p.Users))}
+	if rep.Time.IsZero() {
+		rep.Time = h.now()
+	}
+
+	h.log.Info("rotating users", "event_id", event.ID, "users", len(p.Users))
+
+	for _, user := range p.Users {
+		ur := h.rotateUser(ctx, p, user)
+		if ur.Error != "" {
+			rep.Failed++
+		}
+		rep.Users = append(rep.Users, ur)
+	}
+
+	return rep, nil
+}
+
+// rotateUser Rotate the keys of one user, writing a new key to their secret.
+func (h *handler) rotateUser(ctx context.Context, p *policy, user string) userReport {
+	ur := userReport{User: user, Secret: p.SecretPrefix + user, Stages: make([]stageReport, 0)}
+
+	var tags rotator.TagClient
+	if p.TagUsers {
+		tags = h.tags
+	}
+
+	r, err1 := rotator.New(rotator.Options{
+		IAM:         h.iam,
+		UserName:    user,
+		Stores:      []rotator.Store{&secretStore{h.secrets, ur.Secret}},
+		Clock:       h.clock,
+		Logger:      h.log,
+		Tagger:      tags,
+		Version:     version,
+		CallTimeout: time.Duration(p.CallTimeoutSeconds) * time.Second,
+		Retry:       rotator.RetryPolicy{MaxAttempts: p.RetryAttempts},
+		Policy: rotator.Policy{
+			MaxDaysAllowed: p.MaxDaysAllowed,
+			MaxKeysAllowed: p.MaxKeysAllowed,
+			WarnDays:       p.WarnDays,
+		},
+	})
+	if err1 != nil {
+		ur.Error = rotator.Redact(err1.Error())
+		return ur
+	}
+
+	res, err2 := r.Rotate(ctx)
+	if err2 != nil {
+		ur.Error = rotator.Redact(err2.Error())
+		h.log.Error("rotation failed", "user", user, "error", err2)
+	}
+
+	ur.Rotated = res.Rotated()
+	ur.DaysOld = res.CurrentKeyDays
+	ur.Warn = res.Warn
+	ur.RolledBack = res.RolledBack
+	if res.Rotated() {
+		ur.NewKeyId = rotator.MaskKeyId(*res.NewKey.AccessKeyId)
+	}
+
+	for _, k := range res.Keys {
+		if k.Unmanaged {
+			ur.UnmanagedKeys = append(ur.UnmanagedKeys, rotator.MaskKeyId(k.AccessKeyId))
+		}
+	}
+
+	for _, s := range res.Stages {
+		sr := stageReport{Name: s.Name, Attempts: s.Attempts}
+		if s.Err != nil {
+			sr.Error = rotator.Redact(s.Err.Error())
+		}
+		ur.Stages = append(ur.Stages, sr)
+	}
+
+	return ur
+}
+
+func (h *handler) now() time.Time {
+	if h.clock == nil {
+		return time.Now()
+	}
+
+	return h.clock.Now()
+}
@@ -0,0 +1,40 @@
+// The Lambda entrypoint of iam-user-key-rotator. Triggered by an EventBridge schedule, it rotates the keys of the
+// users in its policy and writes each new key to Secrets Manager.
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"log"
+	"os"
+)
+
+// version Of this program, set when building with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+func main() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf(errMsgs.configErr, err.Error())
+	}
+
+	// The rotator retries calls itself, and only retries a create when it was throttled. The SDK retrying as well could
+	// make a second key.
+	iamClient := iam.NewFromConfig(cfg, func(o *iam.Options) { o.Retryer = aws.NopRetryer{} })
+
+	h := &handler{
+		iam:     iamClient,
+		tags:    iamClient,
+		secrets: secretsmanager.NewFromConfig(cfg),
+		params:  ssm.NewFromConfig(cfg),
+		getenv:  os.Getenv,
+		log:     lambdaLogger{},
+	}
+
+	lambda.Start(h.handle)
+}
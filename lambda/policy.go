@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"strconv"
+	"strings"
+)
+
+// Environment variables the Lambda is configured with.
+const (
+	envPolicyParameter = "POLICY_PARAMETER"
+	envUsers           = "ROTATE_USERS"
+	envMaxDaysAllowed  = "MAX_DAYS_ALLOWED"
+	envMaxKeysAllowed  = "MAX_KEYS_ALLOWED"
+	envWarnDays        = "WARN_DAYS"
+	envSecretPrefix    = "SECRET_PREFIX"
+	envCallTimeout     = "CALL_TIMEOUT_SECONDS"
+	envRetryAttempts   = "RETRY_ATTEMPTS"
+	envTagUsers        = "TAG_USERS"
+)
+
+const defaultSecretPrefix = "iam-user-key-rotator/"
+
+// policy Which users to rotate, when, and where their new keys are written.
+type policy struct {
+	Users          []string `json:"users"`
+	MaxDaysAllowed int      `json:"maxDaysAllowed"`
+	MaxKeysAllowed int      `json:"maxKeysAllowed"`
+	WarnDays       int      `json:"warnDays"`
+	// SecretPrefix The new key of a user is written to the secret named by the prefix followed by the user name.
+	SecretPrefix string `json:"secretPrefix"`
+	// CallTimeoutSeconds Longest each attempt at a call to IAM or Secrets Manager may take.
+	CallTimeoutSeconds int `json:"callTimeoutSeconds"`
+	// RetryAttempts The most times a call is made, 1 to never retry.
+	RetryAttempts int `json:"retryAttempts"`
+	// TagUsers Record each rotation in tags of the user, which needs iam:ListUserTags and iam:TagUser.
+	TagUsers bool `json:"tagUsers"`
+}
+
+// parameterGetter Gets a parameter from SSM, *ssm.Client implements it.
+type parameterGetter interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// defaultPolicy The same defaults as the CLI flags.
+func defaultPolicy() *policy {
+	return &policy{
+		MaxDaysAllowed:     30,
+		MaxKeysAllowed:     1,
+		SecretPrefix:       defaultSecretPrefix,
+		CallTimeoutSeconds: 30,
+		RetryAttempts:      rotator.DefaultMaxAttempts,
+		TagUsers:           true,
+	}
+}
+
+// loadPolicy Read the policy as JSON from the SSM parameter named in POLICY_PARAMETER, or else from the other
+// environment variables.
+func loadPolicy(ctx context.Context, getenv func(string) string, params parameterGetter) (*policy, error) {
+	p := defaultPolicy()
+
+	if name := getenv(envPolicyParameter); name != "" {
+		gpo, err1 := params.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: true})
+		if err1 != nil {
+			return nil, fmt.Errorf(errMsgs.parameterErr, name, err1.Error())
+		}
+
+		if err := json.Unmarshal([]byte(aws.ToString(gpo.Parameter.Value)), p); err != nil {
+			return nil, fmt.Errorf(errMsgs.policyInvalid, err.Error())
+		}
+	} else if err := p.fromEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	if len(p.Users) == 0 {
+		return nil, fmt.Errorf(errMsgs.usersMissing, envPolicyParameter, envUsers)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// validate Check the numbers of the policy are in range, a zero maxDaysAllowed would rotate every user on every run.
+func (p *policy) validate() error {
+	positive := []struct {
+		name  string
+		value int
+	}{
+		{"maxDaysAllowed", p.MaxDaysAllowed},
+		{"maxKeysAllowed", p.MaxKeysAllowed},
+		{"callTimeoutSeconds", p.CallTimeoutSeconds},
+		{"retryAttempts", p.RetryAttempts},
+	}
+	for _, v := range positive {
+		if v.value < 1 {
+			return fmt.Errorf(errMsgs.policyInvalid, fmt.Sprintf("%v must be more than 0, got %v", v.name, v.value))
+		}
+	}
+
+	if p.WarnDays < 0 {
+		return fmt.Errorf(errMsgs.policyInvalid, fmt.Sprintf("warnDays must not be negative, got %v", p.WarnDays))
+	}
+
+	return nil
+}
+
+// fromEnv Set the policy from environment variables, leaving defaults for any that are not set.
+func (p *policy) fromEnv(getenv func(string) string) error {
+	for _, u := range strings.Split(getenv(envUsers), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			p.Users = append(p.Users, u)
+		}
+	}
+
+	ints := map[string]*int{
+		envMaxDaysAllowed: &p.MaxDaysAllowed,
+		envMaxKeysAllowed: &p.MaxKeysAllowed,
+		envWarnDays:       &p.WarnDays,
+		envCallTimeout:    &p.CallTimeoutSeconds,
+		envRetryAttempts:  &p.RetryAttempts,
+	}
+	for name, v := range ints {
+		s := getenv(name)
+		if s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf(errMsgs.policyInvalid, name+" must be a number")
+		}
+		*v = n
+	}
+
+	if s := getenv(envSecretPrefix); s != "" {
+		p.SecretPrefix = s
+	}
+
+	if s := getenv(envTagUsers); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf(errMsgs.policyInvalid, envTagUsers+" must be true or false")
+		}
+		p.TagUsers = b
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// secretsClient The Secrets Manager calls made to store new keys, *secretsmanager.Client implements it.
+type secretsClient interface {
+	CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
+	PutSecretValue(ctx context.Context, params *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+}
+
+// secretValue What is written to the secret, the same as the JSON key file the CLI writes.
+type secretValue struct {
+	Id       string `json:"aws_access_key_id"`
+	Key      string `json:"aws_secret_access_key"`
+	Username string `json:"username"`
+}
+
+// secretStore Writes new keys to a Secrets Manager secret, making the secret when it does not exist yet.
+type secretStore struct {
+	client secretsClient
+	name   string
+}
+
+func (s *secretStore) Name() string {
+	return "secretsmanager"
+}
+
+func (s *secretStore) Save(ctx context.Context, key *types.AccessKey) error {
+	content, _ := json.Marshal(secretValue{
+		aws.ToString(key.AccessKeyId),
+		aws.ToString(key.SecretAccessKey),
+		aws.ToString(key.UserName),
+	})
+
+	_, err1 := s.client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(s.name),
+		SecretString: aws.String(string(content)),
+	})
+
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err1, &notFound) {
+		_, err1 = s.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(s.name),
+			Description:  aws.String("IAM access key of " + aws.ToString(key.UserName) + ", rotated by iam-user-key-rotator"),
+			SecretString: aws.String(string(content)),
+		})
+	}
+
+	if err1 != nil {
+		return fmt.Errorf(errMsgs.putSecretErr, s.name, err1)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, from the most to the least verbose.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[int]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// structuredLogger Writes leveled log lines as logfmt or JSON, with fields, masking anything that looks like a secret.
+type structuredLogger struct {
+	level  int
+	json   bool
+	fields []interface{}
+	mu     *sync.Mutex
+	// out Where to write, when nil it writes to the same place as the standard log package.
+	out io.Writer
+}
+
+// appLog Is what you use at runtime to log, it is configured from flags in main.
+var appLog = newLogger("logfmt", "info", nil)
+
+// newLogger Make a logger with the given format (logfmt or json) and minimum level.
+func newLogger(format, level string, out io.Writer) *structuredLogger {
+	sl := &structuredLogger{level: levelInfo, json: format == "json", mu: &sync.Mutex{}, out: out}
+
+	for k, v := range levelNames {
+		if v == level {
+			sl.level = k
+		}
+	}
+
+	return sl
+}
+
+// with Get a logger that adds the key/value fields to every line.
+func (sl *structuredLogger) with(kv ...interface{}) *structuredLogger {
+	c := *sl
+	c.fields = append(append([]interface{}{}, sl.fields...), kv...)
+
+	return &c
+}
+
+// Debug, Info, Warn and Error Write a line at that level, structuredLogger satisfies rotator.Logger.
+func (sl *structuredLogger) Debug(msg string, kv ...interface{}) { sl.write(levelDebug, msg, kv) }
+func (sl *structuredLogger) Info(msg string, kv ...interface{})  { sl.write(levelInfo, msg, kv) }
+func (sl *structuredLogger) Warn(msg string, kv ...interface{})  { sl.write(levelWarn, msg, kv) }
+func (sl *structuredLogger) Error(msg string, kv ...interface{}) { sl.write(levelError, msg, kv) }
+
+// write Format and write a log line when the level is enabled.
+func (sl *structuredLogger) write(level int, msg string, kv []interface{}) {
+	if level < sl.level {
+		return
+	}
+
+	keys := []string{"time", "level", "msg"}
+	values := map[string]string{
+		"time":  time.Now().UTC().Format(time.RFC3339),
+		"level": levelNames[level],
+		"msg":   rotator.Redact(msg),
+	}
+
+	all := append(append([]interface{}{}, sl.fields...), kv...)
+	for i := 0; i+1 < len(all); i += 2 {
+		k := fmt.Sprint(all[i])
+		if _, ok := values[k]; !ok {
+			keys = append(keys, k)
+		}
+		values[k] = rotator.Redact(fieldString(all[i+1]))
+	}
+
+	var line string
+	if sl.json {
+		line = jsonLine(keys, values)
+	} else {
+		line = logfmtLine(keys, values)
+	}
+
+	out := sl.out
+	if out == nil {
+		out = log.Writer()
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	_, _ = io.WriteString(out, line+"\n")
+}
+
+// fieldString Render a field value as a string.
+func fieldString(v interface{}) string {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case *string:
+		if t == nil {
+			return ""
+		}
+		return *t
+	case *time.Time:
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	case time.Time:
+		return t.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// logfmtLine Render fields as key=value pairs, quoting values when needed.
+func logfmtLine(keys []string, values map[string]string) string {
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := values[k]
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// jsonLine Render fields as a JSON object, in the order they were given.
+func jsonLine(keys []string, values map[string]string) string {
+	b := &strings.Builder{}
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		kj, _ := json.Marshal(k)
+		vj, _ := json.Marshal(values[k])
+		b.Write(kj)
+		b.WriteString(":")
+		b.Write(vj)
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
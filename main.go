@@ -2,39 +2,41 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
-	"io/ioutil"
-	"log"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// version Of this program, set when building with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
 const keyVarName = "AWS_ACCESS_KEY_ID"
 const secretVarName = "AWS_SECRET_ACCESS_KEY"
 
 type awsKeyPair struct {
-	Id       string `json:"aws_access_key_id" csv:"User Name"`
-	Key      string `json:"aws_secret_access_key" csv:"Access key ID"`
-	Username string `json:"username" csv:"Secret access key"`
-}
-
-type iamStats struct {
-	current    string
-	keys       []iamKeyInfo
-	old, valid []*iamKeyInfo
+	Id            string            `json:"aws_access_key_id" csv:"Access key ID"`
+	Key           string            `json:"aws_secret_access_key,omitempty" csv:"Secret access key"`
+	Username      string            `json:"username" csv:"-"`
+	SMTPUsername  string            `json:"smtp_username,omitempty" csv:"-"`
+	SMTPPasswords map[string]string `json:"smtp_passwords,omitempty" csv:"-"`
 }
 
-type iamKeyInfo struct {
-	*types.AccessKeyMetadata
-	Days    int
-	Expired bool
+// credentialProcessOutput The JSON document AWS SDKs expect from a credential_process program.
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string `json:",omitempty"`
+	Expiration      string `json:",omitempty"`
 }
 
 // awsConfigOpts shorthand to set an array of config.LoadOptionsFunc to override defaults
@@ -43,322 +45,314 @@ type awsConfigOpts []func(*config.LoadOptions) error
 var httpComm httpCommunicator
 var optFns []func(*config.LoadOptions) error
 
-// RemoveKeyByIndex Removed IAM key from stats.
-func (is *iamStats) RemoveKeyByIndex(idx int) {
-	tmpK := is.keys[idx]
-	is.keys = append(is.keys[:idx], is.keys[idx+1:]...)
-
-	// Remove reference in old array.
-	for i, v := range is.old {
-		if v == &tmpK {
-			is.old = append(is.old[:i], is.old[i+1:]...)
-		}
-	}
-	// Remove reference in valid array.
-	for i, v := range is.valid {
-		if v == &tmpK {
-			is.valid = append(is.valid[:i], is.valid[i+1:]...)
-		}
-	}
-}
-
-// IsCurrentKeyExpired Indicates the current key is less than maxDaysOld.
-func (is *iamStats) IsCurrentKeyExpired() bool {
-	for _, v := range is.keys {
-		if *v.AccessKeyId == is.current {
-			return v.Expired
-		}
-	}
-
-	// The current key should always be present, unless there is broken logic that deleted it before it was time.
-	// Do NOT call this after the current key has been replaced. Which happens when the key has expired,
-	// and it must be replaced. Some lines later in main function.
-	panic("could not find current key")
-}
-
 func init() {
 	appFlags.define()
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run Run the program, returning the code to exit with. A panic is logged and exits with exitFailed, instead of being
+// lost to the exit.
+func run() (code int) {
 	var mainErr error
+	rotated := false
 
 	defer func() {
+		if p := recover(); p != nil {
+			mainErr, rotated = fmt.Errorf(errors.panicked, p), false
+		}
+
+		code = exitCode(mainErr, rotated && *appFlags.detailedExitCodes)
 		if mainErr != nil {
-			log.Fatal(mainErr)
+			appLog.Error(mainErr.Error(), "exit_code", code)
+			return
 		}
 
-		log.Println("exiting with code 0")
-		os.Exit(0)
+		appLog.Info(stdMsgs.exiting, "code", code)
 	}()
 
 	flag.Parse()
 
-	if err := appFlags.check(); err != nil {
-		mainErr = err
-		return
-	}
+	// Stop before the next change to IAM on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	maxDaysAllowed := *appFlags.maxDaysAllowed
-	maxKeysAllowed := *appFlags.maxKeysAllowed
-	filename := *appFlags.filename
+	appLog = newLogger(*appFlags.logFormat, *appFlags.logLevel, nil)
 
-	// Make a new AWS config to load the Shared AWS Configuration (such as ~/.aws/config).
-	awsConfig, err0 := getAwsConfig(appFlags)
-	if err0 != nil {
-		mainErr = fmt.Errorf("could not get AWS configuration with default methods; %v", err0.Error())
-		return
+	if httpComm == nil {
+		httpComm = &http.Client{}
 	}
 
-	// Get current access key id.
-	creds, err6 := awsConfig.Credentials.Retrieve(context.TODO())
-	if err6 != nil {
-		mainErr = fmt.Errorf("could not get current AWS key ID; %v", err6.Error())
+	if flag.NArg() > 0 {
+		rotated, mainErr = runSubcommand(ctx, flag.Args(), appFlags)
 		return
 	}
 
-	currentId := creds.AccessKeyID
-
-	// Init a new IAM client.
-	iamClient := iam.NewFromConfig(awsConfig)
-
-	// Query IAM for any keys.
-	liko, err2 := iamClient.ListAccessKeys(context.TODO(), &iam.ListAccessKeysInput{})
-	if err2 != nil {
-		mainErr = err2
+	if err := appFlags.check(); err != nil {
+		mainErr = err
 		return
 	}
 
-	// Determine which keys are older than days allowed
-	iamKeyStats := getIamKeyStats(liko.AccessKeyMetadata, maxDaysAllowed, creds.AccessKeyID)
-	displayIamStats(iamKeyStats)
-
-	// make sure there is room to make a new key.
-	if errX := makeRoomForKey(currentId, iamKeyStats.old, iamClient); errX != nil {
-		mainErr = errX
+	if *appFlags.daemon {
+		mainErr = runDaemon(ctx, appFlags)
 		return
 	}
 
-	if errX := removeExcessKeys(iamKeyStats, maxKeysAllowed, currentId, iamClient); errX != nil {
-		mainErr = errX
-		return
+	rotated, mainErr = rotateKeys(ctx, appFlags)
+
+	if *appFlags.metricsTextfile != "" {
+		if err := metrics.writeTextfile(*appFlags.metricsTextfile); err != nil && mainErr == nil {
+			mainErr = err
+		}
 	}
 
-	// Make a new key when the current one has expired.
-	if iamKeyStats.IsCurrentKeyExpired() {
-		log.Println("no valid keys, making a new key")
+	return
+}
 
-		newKey, errX := makeNewKey(iamKeyStats, iamClient)
-		if errX != nil {
-			mainErr = errX
-			return
-		}
+// runDaemon Check the keys every interval, serving metrics over HTTP in between, until the context is done.
+func runDaemon(ctx context.Context, ac *applicationFlags) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Addr: *ac.metricsAddr, Handler: mux}
 
-		if httpComm == nil {
-			httpComm = &http.Client{}
-		}
+	srvErr := make(chan error, 1)
+	go func() {
+		srvErr <- srv.ListenAndServe()
+	}()
 
-		if err := save(newKey, appFlags, httpComm, filename); err != nil {
-			mainErr = err
-			return
+	appLog.Info("serving metrics", "addr", *ac.metricsAddr, "path", "/metrics")
+
+	for {
+		if _, err := rotateKeys(ctx, ac); err != nil {
+			appLog.Error(err.Error())
 		}
-	}
 
-	// Delete any remaining keys (which should only be the current key if any).
-	if err := deleteKeys(iamKeyStats.old, iamClient); err != nil {
-		mainErr = err
-		return
+		select {
+		case err := <-srvErr:
+			return err
+		case <-ctx.Done():
+			appLog.Info(stdMsgs.stopping)
+			sctx, cancel := withTimeout(context.Background(), *ac.callTimeout)
+			defer cancel()
+
+			return srv.Shutdown(sctx)
+		case <-time.After(*ac.interval):
+		}
 	}
 }
 
-// DaysOld Calculate the days passed since the date.
-func DaysOld(someDate *time.Time) int {
-	days := time.Now().Sub(*someDate).Hours() / 24
+// rotateKeys Run one check of the IAM keys, rotating the current key when it has expired. Indicates whether a new key
+// was made.
+func rotateKeys(ctx context.Context, ac *applicationFlags) (bool, error) {
+	ctx, cancel := withTimeout(ctx, *ac.timeout)
+	defer cancel()
+
+	newKey, err := rotate(ctx, ac, nil, newStores(ac, httpComm, true))
 
-	return int(days)
+	return newKey != nil, err
 }
 
-func deleteKeys(deleteKeys []*iamKeyInfo, iamClient awsCaller) error {
-	for _, v := range deleteKeys {
-		daki := &iam.DeleteAccessKeyInput{AccessKeyId: v.AccessKeyId}
-		_, err7 := iamClient.DeleteAccessKey(context.TODO(), daki)
-		if err7 != nil {
-			return fmt.Errorf("could not delete key %q; %v", *v.AccessKeyId, err7.Error())
-		}
-		log.Printf("removed key %v\n", *v.AccessKeyId)
+// withTimeout Get a context that is done after the timeout, a timeout of 0 means it is only done when the parent is.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	return nil
+	return context.WithTimeout(ctx, timeout)
 }
 
-// getAwsConfig Get an AWS Config, with optional overrides.
-func getAwsConfig(ac *applicationFlags) (aws.Config, error) {
-	if optFns == nil {
-		optFns = awsConfigOpts{
-			config.WithRegion(*ac.region),
-			config.WithSharedConfigProfile(*ac.profile),
-		}
-	}
+// rotate Check the IAM keys with the AWS config given, or the default config when nil, rotating the current key when
+// it has expired. The new key is saved to the stores, in order, and returned when one was made.
+func rotate(ctx context.Context, ac *applicationFlags, cfg *aws.Config, stores []rotator.Store) (newKey *types.AccessKey, rotateErr error) {
+	var notices *notifications
+	user := ""
 
-	return config.LoadDefaultConfig(context.TODO(), optFns...)
-}
+	defer func() {
+		if rotateErr == nil {
+			return
+		}
 
-type awsCaller interface {
-	DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error)
-	CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error)
-}
+		// The AWS config could not be loaded, so there is no IAM client to find the owner of the user with.
+		if notices == nil {
+			notices = newNotifications(ac, httpComm, nil)
+		}
+		notices.send(&rotationEvent{Event: eventFailure, User: user, Message: rotateErr.Error()})
+	}()
 
-// makeRoomForKey Deletes all IAM keys in the delete key list except for the current access ID in use.
-func makeRoomForKey(currentId string, deleteKeys []*iamKeyInfo, iamClient awsCaller) error {
-	for _, v := range deleteKeys {
-		// delete all keys marked for deletion, except the one we are using.
-		if *v.AccessKeyId != currentId {
-			daki := &iam.DeleteAccessKeyInput{AccessKeyId: v.AccessKeyId}
-			_, err7 := iamClient.DeleteAccessKey(context.TODO(), daki)
-			if err7 != nil {
-				return fmt.Errorf("could not delete key %q; %v", *v.AccessKeyId, err7.Error())
-			}
-			log.Printf("removed key %v\n", *v.AccessKeyId)
+	// Make a new AWS config to load the Shared AWS Configuration (such as ~/.aws/config).
+	if cfg == nil {
+		awsConfig, err0 := getAwsConfig(ctx, ac)
+		if err0 != nil {
+			return nil, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.awsConfigErr, err0))
 		}
+		cfg = &awsConfig
 	}
+	awsConfig := *cfg
 
-	return nil
-}
+	// Init a new IAM client.
+	iamClient := newIamClient(awsConfig)
+	notices = newNotifications(ac, httpComm, iamClient)
 
-// makeNewKey Add a new IAM key.
-func makeNewKey(stats *iamStats, iamClient awsCaller) (*iam.CreateAccessKeyOutput, error) {
+	// Get current access key id.
+	callCtx, cancel := withTimeout(ctx, *ac.callTimeout)
+	defer cancel()
 
-	// Skip if not expired.
-	if !stats.IsCurrentKeyExpired() {
-		return nil, nil
+	creds, err6 := awsConfig.Credentials.Retrieve(callCtx)
+	if err6 != nil {
+		return nil, withKind(rotator.ErrAuthFailed, fmt.Errorf(errors.currentKeyIdErr, err6))
 	}
 
-	log.Println(stdMsgs.expireKey)
+	currentId := creds.AccessKeyID
 
-	newKey, err1 := iamClient.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{})
-	if err1 != nil {
-		return nil, fmt.Errorf(errors.probMakingNewKey, err1.Error())
+	// Record every change made to IAM when asked to.
+	if err := openAuditTrail(callCtx, ac, awsConfig); err != nil {
+		return nil, err
 	}
 
-	return newKey, nil
-}
-
-// save AWS credentials to a medium.
-func save(creds *iam.CreateAccessKeyOutput, ac *applicationFlags, hc httpCommunicator, filename string) error {
-	saveMode := ""
-	if *(appFlags.circleci) != "" {
-		saveMode = "circleci"
+	var keyClient rotator.IAMClient = iamClient
+	if auditTrail != nil {
+		keyClient = &auditedIamClient{iamClient, auditTrail}
 	}
 
-	// Always save to a local file.
-	if err := saveToFile(creds, filename); err != nil {
-		return err
+	opts := rotatorOptions(ac, iamClient)
+	opts.IAM = keyClient
+	opts.CurrentKeyId = currentId
+	opts.Stores = stores
+	opts.Tagger = newTagger(ac, newTagClient(iamClient))
+
+	r, err1 := rotator.New(opts)
+	if err1 != nil {
+		return nil, err1
 	}
 
-	switch saveMode {
-	case "circleci":
-		log.Println("saving to Circle CI context")
-		return saveToCircleContext(creds, *ac.circleci, hc)
-	default:
-		log.Println("saving to local credentials/profile")
-		return saveToLocalProfile(creds)
+	res, err2 := r.Rotate(ctx)
+	user = res.User
+	recordStages(res.Stages)
+	if len(res.Keys) > 0 {
+		recordKeyStats(res.Keys)
 	}
-}
 
-// saveToFile Save the new key to a local file as JSON.
-func saveToFile(newKey *iam.CreateAccessKeyOutput, filename string) error {
-	nk := awsKeyPair{*newKey.AccessKey.AccessKeyId, *newKey.AccessKey.SecretAccessKey, *newKey.AccessKey.UserName}
+	newId := ""
+	if res.NewKey != nil {
+		newId = aws.ToString(res.NewKey.AccessKeyId)
+	}
+	notifyResult(notices, ac, res, err2, newId)
 
-	content, err1 := json.Marshal(nk)
-	if err1 != nil {
-		return fmt.Errorf(errors.translateKeyToJsonErr, err1.Error())
+	if err2 != nil {
+		return nil, err2
 	}
 
-	if err := ioutil.WriteFile(filename, content, 0774); err != nil {
-		return fmt.Errorf(errors.writingNewKeyErr, err.Error())
+	if res.Rotated() {
+		// The key is safely stored elsewhere, so the local file is no longer needed.
+		if !*ac.keepFile {
+			if err := removeKeyFile(*ac.filename); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return nil
+	metrics.set(metricLastSuccessTime, float64(time.Now().Unix()))
+
+	return res.NewKey, nil
 }
 
-func newIamStats(c string) *iamStats {
-	stats := &iamStats{
-		current: c,
-		keys:    make([]iamKeyInfo, 0),
-		old:     make([]*iamKeyInfo, 0),
-		valid:   make([]*iamKeyInfo, 0),
+// newIamClient Get an IAM client for the AWS config. The rotator retries IAM calls itself, so the SDK must not retry
+// them as well.
+func newIamClient(awsConfig aws.Config) *iam.Client {
+	return iam.NewFromConfig(awsConfig, func(o *iam.Options) { o.Retryer = aws.NopRetryer{} })
+}
+
+// rotatorOptions Get the rotator options the flags set that every kind of credential shares, for the IAM client.
+func rotatorOptions(ac *applicationFlags, iamClient *iam.Client) rotator.Options {
+	return rotator.Options{
+		IAM:         iamClient,
+		Logger:      appLog,
+		CallTimeout: *ac.callTimeout,
+		Locker:      newLocker(ac, newTagClient(iamClient)),
+		Version:     version,
+		Retry: rotator.RetryPolicy{
+			MaxAttempts: *ac.retryAttempts,
+			BaseDelay:   *ac.retryBaseDelay,
+			MaxDelay:    *ac.retryMaxDelay,
+		},
+		Policy: rotator.Policy{
+			MaxDaysAllowed: *ac.maxDaysAllowed,
+			MaxKeysAllowed: *ac.maxKeysAllowed,
+			WarnDays:       *ac.warnDays,
+		},
 	}
-	return stats
 }
 
-func getIamKeyStats(ak []types.AccessKeyMetadata, daysAllowed int, currentId string) *iamStats {
-	stats := newIamStats(currentId)
+// notifyResult Send the notices for what a rotation did, whose new credential has the ID newId: a warning when the
+// current credential is about to expire, a rollback, or the rotation. Failures are left to the caller.
+func notifyResult(notices *notifications, ac *applicationFlags, res *rotator.Result, err error, newId string) {
+	// Warn the owner before the key expires.
+	if res.Warn {
+		notices.send(&rotationEvent{
+			Event:   eventWarning,
+			User:    res.User,
+			KeyId:   res.CurrentKeyId,
+			Message: fmt.Sprintf(stdMsgs.keyAboutToExpire, res.CurrentKeyDays, *ac.maxDaysAllowed),
+		})
+	}
 
-	for _, v := range ak {
-		daysOld := DaysOld(v.CreateDate)
-		k := iamKeyInfo{
-			&v,
-			daysOld,
-			daysOld > daysAllowed,
+	if res.RolledBack {
+		e := &rotationEvent{Event: eventRollback, User: res.User, KeyId: newId}
+		e.Message = fmt.Sprintf(stdMsgs.rolledBack, err.Error())
+		if res.RollbackErr != nil {
+			e.Message = res.RollbackErr.Error()
 		}
-		stats.keys = append(stats.keys, k)
+		notices.send(e)
+	}
 
-		// When older than daysAllowed, then mark for deletion.
-		if daysOld > daysAllowed {
-			stats.old = append(stats.old, &k)
-			continue
-		}
+	if err == nil && res.Rotated() {
+		notices.send(&rotationEvent{
+			Event:   eventRotated,
+			User:    res.User,
+			KeyId:   newId,
+			Message: fmt.Sprintf(stdMsgs.keyRotated, res.CurrentKeyId),
+		})
+	}
+}
 
-		// otherwise, its valid.
-		stats.valid = append(stats.old, &k)
+// newLocker Get a lock on the IAM user when asked for one with the -lock flag, else nil.
+func newLocker(ac *applicationFlags, client rotator.TagClient) rotator.Locker {
+	if !*ac.lock {
+		return nil
 	}
 
-	return stats
+	return &rotator.TagLocker{IAM: client, Lease: *ac.lockLease, Wait: *ac.lockWait}
 }
 
-// displayIamStats Display info that allows the user to understand what is happening.
-func displayIamStats(stats *iamStats) {
-	// Header
-	log.Println("key id               | status | username | days old | date")
-
-	for _, v := range stats.keys {
-		// Calculate how many days old the key is.
-		daysOld := DaysOld(v.CreateDate)
-		log.Printf("%s | %v | %s | %v | %v\n", *v.AccessKeyId, v.Status, *v.UserName, daysOld, v.CreateDate)
+// newTagClient Get the client the tags of the user are read and written with, recording each change in the audit log
+// when it is open.
+func newTagClient(iamClient *iam.Client) rotator.TagClient {
+	if auditTrail == nil {
+		return iamClient
 	}
 
-	log.Printf("number of keys %v", len(stats.keys))
-	log.Printf("\t%v are valid keys", len(stats.valid))
-	log.Printf("\t%v will be removed", len(stats.old))
+	return &auditedTagClient{iamClient, auditTrail}
 }
 
-func removeExcessKeys(stats *iamStats, maxKeysAllowed int, currentId string, iamClient awsCaller) error {
-	numKeys := len(stats.keys)
-
-	if numKeys <= maxKeysAllowed {
+// newTagger Get the client to record rotations in tags of the IAM user with, nil when the -tagUser flag is false.
+func newTagger(ac *applicationFlags, client rotator.TagClient) rotator.TagClient {
+	if !*ac.tagUser {
 		return nil
 	}
-	// delete keys that we are not using, until we get to the max allowed.
-	for i, v := range stats.keys {
-		if *v.AccessKeyId == currentId {
-			continue
-		}
-		if v.Expired || len(stats.keys) > maxKeysAllowed {
-			daki := &iam.DeleteAccessKeyInput{AccessKeyId: v.AccessKeyId}
-			_, err7 := iamClient.DeleteAccessKey(context.TODO(), daki)
-			if err7 != nil {
-				return fmt.Errorf("could not delete key %q; %v", *v.AccessKeyId, err7.Error())
-			}
-			// Remove any reference to the deleted key.
-			stats.RemoveKeyByIndex(i)
 
-			log.Printf("removed key %v\n", *v.AccessKeyId)
-			numKeys--
-			if numKeys <= maxKeysAllowed {
-				break
-			}
+	return client
+}
+
+// getAwsConfig Get an AWS Config, with optional overrides.
+func getAwsConfig(ctx context.Context, ac *applicationFlags) (aws.Config, error) {
+	if optFns == nil {
+		optFns = awsConfigOpts{
+			config.WithRegion(*ac.region),
+			config.WithSharedConfigProfile(*ac.profile),
 		}
 	}
 
-	return nil
+	return config.LoadDefaultConfig(ctx, optFns...)
 }
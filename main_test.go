@@ -9,11 +9,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/aws/smithy-go/middleware"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +29,11 @@ const (
 	dirMode            = 0700
 	localStackEndpoint = "http://localstack:4566"
 	localStackGood     = "{\"status\": \"running\"}"
+	// fakeIamEnv Runs the main program against a fake IAM service, the value is an action to fail and the error code
+	// to fail it with, such as "CreateAccessKey=LimitExceeded", "locked" to have another rotation hold the lock, or
+	// empty to fail nothing.
+	fakeIamEnv = "FAKE_IAM"
+	fakeKeyId  = "AKIAFAKEBOB000000001"
 )
 
 var throwErr = false
@@ -68,6 +76,10 @@ func TestMain(m *testing.M) {
 				}
 			}
 		}
+		// Or use a fake IAM service, so the main flow can be tested offline.
+		if fail, ok := os.LookupEnv(fakeIamEnv); ok && optFns == nil {
+			optFns = startFakeIam(fail)
+		}
 		// mock HTTP client to spy and assert.
 		httpComm = &mockHttpClient{0}
 		runAppMain()
@@ -84,24 +96,6 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
-func TestDaysOld(tester *testing.T) {
-	cases := []struct {
-		name string
-		fix  time.Time
-	}{
-		{"10days", time.Now().AddDate(0, 0, -10)},
-	}
-
-	for _, test := range cases {
-		tester.Run(test.name, func(t *testing.T) {
-			got := DaysOld(&test.fix)
-			if got != 10 {
-				t.Errorf("want %v, got %v", test.fix, got)
-			}
-		})
-	}
-}
-
 func TestFlags(tester *testing.T) {
 	if !isLocalStackAvailable() {
 		tester.Skip("unknown environment, skipping")
@@ -113,9 +107,9 @@ func TestFlags(tester *testing.T) {
 		wantCode int
 		args     []string
 	}{
-		{"noFlags", 1, []string{}},
+		{"noFlags", exitConfigInvalid, []string{}},
 		{"withRegion", 0, []string{"-region", "us-east-2"}},
-		{"withCircleSuccess", 0, []string{"-region", "us-east-2", "--circleci", "1234"}},
+		{"withCircleSuccess", 0, []string{"-region", "us-east-2", "--circleci", "1234", "--circleciContext", "ctx-id"}},
 	}
 
 	for _, test := range tests {
@@ -136,43 +130,6 @@ func TestFlags(tester *testing.T) {
 	}
 }
 
-func TestGetIamKeyStats(tester *testing.T) {
-	s1 := "ABC123"
-	t1 := time.Now()
-	s2 := "ABCD1234"
-	t2 := time.Date(2021, 12, 10, 1, 0, 0, 0, time.UTC)
-
-	var tests = []struct {
-		name        string
-		keys        []types.AccessKeyMetadata
-		daysAllowed int
-		currentId   string
-		wantOld     int
-		wantValid   int
-	}{
-		{"1_valid", []types.AccessKeyMetadata{{AccessKeyId: &s1, CreateDate: &t1}}, 1, s1, 0, 1},
-		{"1_old", []types.AccessKeyMetadata{{AccessKeyId: &s2, CreateDate: &t2}}, 1, s2, 1, 0},
-	}
-
-	for _, test := range tests {
-		tester.Run(test.name, func(t *testing.T) {
-			got := getIamKeyStats(test.keys, test.daysAllowed, *test.keys[0].AccessKeyId)
-
-			if got.current != test.currentId {
-				t.Errorf("current ids do now match. want %q, got %q", test.currentId, got.current)
-			}
-
-			if len(got.old) != test.wantOld {
-				t.Errorf("old array not what expected; want %q, got %q", test.wantOld, len(got.old))
-			}
-
-			if len(got.valid) != test.wantValid {
-				t.Errorf("valid array not what expected; want %q, got %q", test.wantValid, len(got.valid))
-			}
-		})
-	}
-}
-
 type mockIamClient struct {
 	iam.Client
 }
@@ -200,135 +157,6 @@ func (c *mockIamClient) CreateAccessKey(ctx context.Context, params *iam.CreateA
 	return &i, nil
 }
 
-func TestMakeRoomForKey(tester *testing.T) {
-	s1 := "ABC123"
-	t1 := time.Date(2021, 12, 1, 1, 0, 0, 0, time.UTC)
-	s2 := "DERR"
-	s3 := "DEF456"
-	t2 := time.Date(2021, 12, 10, 1, 0, 0, 0, time.UTC)
-	k1 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s1, CreateDate: &t1}, Expired: true}
-	k2 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s2, CreateDate: &t2}, Expired: true}
-	k3 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s3, CreateDate: &t2}, Expired: true}
-	var tests = []struct {
-		name      string
-		currentId string
-		deletes   []*iamKeyInfo
-		throw     bool
-	}{
-		{
-			"2_old",
-			s1,
-			[]*iamKeyInfo{&k1, &k3},
-			false,
-		},
-		{
-			"1_old",
-			s2,
-			[]*iamKeyInfo{&k1},
-			false,
-		},
-		{
-			"1_err",
-			s1,
-			[]*iamKeyInfo{&k1, &k2},
-			true,
-		},
-	}
-
-	for _, test := range tests {
-		tester.Run(test.name, func(t *testing.T) {
-			aic := &mockIamClient{}
-			err := makeRoomForKey(test.currentId, test.deletes, aic)
-
-			if err != nil && !test.throw {
-				t.Errorf("test failed deletion simulation %v", err.Error())
-			}
-		})
-	}
-}
-
-func TestMakeNewKey(tester *testing.T) {
-	s1 := "ABC123"
-	t1 := time.Date(2021, 12, 10, 1, 0, 0, 0, time.UTC)
-	s3 := "DERR"
-	t2 := time.Now()
-	k1 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s1, CreateDate: &t1}, Expired: true}
-	k3 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s3, CreateDate: &t2}, Expired: false}
-
-	mockStats := &iamStats{
-		current: s1,
-		keys:    []iamKeyInfo{k1, k3},
-		old:     []*iamKeyInfo{&k1, &k3},
-		valid:   []*iamKeyInfo{&k1},
-	}
-
-	var tests = []struct {
-		name  string
-		throw bool
-	}{
-		{
-			"is_expired",
-			false,
-		},
-	}
-
-	for _, test := range tests {
-		tester.Run(test.name, func(t *testing.T) {
-			aic := &mockIamClient{}
-			got, err := makeNewKey(mockStats, aic)
-
-			if err != nil {
-				t.Errorf("make new key test failed simulation: %v", err.Error())
-			}
-
-			if *got.AccessKey.AccessKeyId != "test1234" {
-				t.Errorf("want %v, got %v", "test1234", *got.AccessKey.AccessKeyId)
-			}
-		})
-	}
-}
-
-func TestRemoveExcessKeys(tester *testing.T) {
-	s1 := "ABC123"
-	t1 := time.Now()
-	s3 := "DEF456"
-	t2 := time.Now()
-	k1 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s1, CreateDate: &t1}, Expired: false}
-	k3 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s3, CreateDate: &t2}, Expired: false}
-
-	var tests = []struct {
-		name  string
-		stats *iamStats
-		maxKeys int
-		currentId string
-		throw bool
-	}{
-		{
-			"is_expired",
-			&iamStats{
-				current: s1,
-				keys:    []iamKeyInfo{k1, k3},
-				old:     []*iamKeyInfo{},
-				valid:   []*iamKeyInfo{&k1, &k3},
-			},
-			0,
-			s1,
-			false,
-		},
-	}
-
-	for _, test := range tests {
-		tester.Run(test.name, func(t *testing.T) {
-			aic := &mockIamClient{}
-			err := removeExcessKeys(test.stats, test.maxKeys, test.currentId, aic)
-
-			if err != nil {
-				t.Errorf("test failed simulation: %v", err.Error())
-			}
-		})
-	}
-}
-
 func TestSaveToFile(tester *testing.T) {
 	s1 := "ABC123"
 	t1 := time.Now()
@@ -353,7 +181,7 @@ func TestSaveToFile(tester *testing.T) {
 
 	for _, test := range tests {
 		tester.Run(test.name, func(t *testing.T) {
-			if err := saveToFile(test.key, test.file); err != nil {
+			if err := saveToFile(test.key, test.file, "json", "", nil); err != nil {
 				t.Errorf("test failed simulation: %v", err.Error())
 			}
 
@@ -367,30 +195,78 @@ func TestSaveToFile(tester *testing.T) {
 	}
 }
 
-func TestDeleteKeys(tester *testing.T) {
-	s1 := "ABC123"
-	t1 := time.Now()
-	k1 := iamKeyInfo{AccessKeyMetadata: &types.AccessKeyMetadata{AccessKeyId: &s1, CreateDate: &t1}, Expired: false}
+// startFakeIam Serve a fake IAM with the user bob, whose only key, SSH public key and CodeCommit credential are 45 days
+// old, and get options to sign in as bob.
+func startFakeIam(fail string) awsConfigOpts {
+	f := iamfake.New()
+	secret := f.AddKey("bob", fakeKeyId, time.Now().AddDate(0, 0, -45), types.StatusTypeActive)
+	f.AddSSHKey("bob", "APKAFAKEOLD", "ssh-rsa old", time.Now().AddDate(0, 0, -45), types.StatusTypeActive)
+	f.AddServiceCredential("bob", rotator.ServiceCodeCommit, "ACCAFAKEOLD", time.Now().AddDate(0, 0, -45), types.StatusTypeActive)
+
+	if fail == "locked" {
+		f.AddUser("bob", types.Tag{Key: aws.String(rotator.LockOwnerTag), Value: aws.String("other")},
+			types.Tag{Key: aws.String(rotator.LockExpiresTag), Value: aws.String(time.Now().Add(time.Hour).Format(time.RFC3339))})
+	}
+
+	if parts := strings.SplitN(fail, "=", 2); len(parts) == 2 {
+		f.FailNext(parts[0], iamfake.APIError(parts[1], "a test error occurred"))
+	}
+
+	srv := iamfake.NewServer(f)
+	log.Printf("using a fake IAM at %v", srv.URL)
+
+	return iamfake.LoadOptions(srv.URL, fakeKeyId, secret)
+}
+
+func TestMainWithFakeIam(tester *testing.T) {
+	keyFile := testTmp + "/fake-iam-key.json"
+	base := []string{"-region", "us-east-1", "-circleci", "1234", "-circleciContext", "ctx-id", "-filename", keyFile, "-detailedExitCodes"}
 
 	var tests = []struct {
-		name  string
-		del []*iamKeyInfo
-		throw bool
+		name     string
+		fail     string
+		args     []string
+		wantCode int
+		wantKey  string
 	}{
-		{
-			"del_1",
-			[]*iamKeyInfo{&k1},
-			false,
-		},
+		{"nothingToDo", "", []string{"-maxDaysAllowed", "90"}, exitNothingToDo, ""},
+		{"rotated", "", []string{}, exitRotated, "AKIAFAKE000000000001"},
+		{"rotatedExitsZero", "", []string{"-detailedExitCodes=false"}, exitNothingToDo, "AKIAFAKE000000000001"},
+		{"quotaReached", "CreateAccessKey=LimitExceeded", []string{}, exitQuotaReached, ""},
+		{"authFailed", "ListAccessKeys=AccessDenied", []string{}, exitAuthFailed, ""},
+		{"rotatedWithLock", "", []string{"-lock"}, exitRotated, "AKIAFAKE000000000001"},
+		{"locked", "locked", []string{"-lock", "-lockWait", "0s"}, exitLocked, ""},
+		{"storageFailed", "", []string{"-fileFormat", "csv", "-filename", testTmp}, exitStorageFailed, ""},
+		{"sesSmtp", "", []string{"-sesSmtpRegions", "us-east-1,eu-west-1", "-sesSmtpOnly"}, exitRotated, "AKIAFAKE000000000001"},
+		{"sesSmtpRegionInvalid", "", []string{"-sesSmtpRegions", "us-east"}, exitConfigInvalid, ""},
+		{"sesSmtpOnlyCredentialProcess", "", []string{"-sesSmtpRegions", "us-east-1", "-sesSmtpOnly", "-fileFormat", "credential_process"}, exitConfigInvalid, ""},
 	}
 
 	for _, test := range tests {
 		tester.Run(test.name, func(t *testing.T) {
-			err := deleteKeys(test.del, &mockIamClient{})
+			_ = os.Remove(keyFile)
 
-			if err != nil {
-				t.Errorf("test failed simulation: %v", err.Error())
+			cmd := getTestBinCmd(append(append([]string{}, base...), test.args...))
+			cmd.Env = append(cmd.Env, fakeIamEnv+"="+test.fail)
+
+			cmdOut, cmdErr := cmd.CombinedOutput()
+
+			got := cmd.ProcessState.ExitCode()
+			if got != test.wantCode {
+				showCmdOutput(cmdOut, cmdErr)
+				t.Fatalf("want exit code %v, got %v", test.wantCode, got)
+			}
+
+			if test.wantKey == "" {
+				return
+			}
+
+			content, _ := ioutil.ReadFile(keyFile)
+			kp := awsKeyPair{}
+			_ = json.Unmarshal(content, &kp)
+			if kp.Id != test.wantKey || kp.Username != "bob" {
+				t.Errorf("want key %v for bob in the key file, got %+v", test.wantKey, kp)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of all metrics exported by this application.
+const (
+	metricKeyAge          = "iam_key_rotator_key_age_days"
+	metricKeys            = "iam_key_rotator_keys"
+	metricUnmanagedKeys   = "iam_key_rotator_unmanaged_keys"
+	metricStage           = "iam_key_rotator_stage_total"
+	metricCallAttempts    = "iam_key_rotator_call_attempts_total"
+	metricStorageSeconds  = "iam_key_rotator_storage_write_seconds"
+	metricStorageErrors   = "iam_key_rotator_storage_write_errors_total"
+	metricLastSuccessTime = "iam_key_rotator_last_success_timestamp_seconds"
+)
+
+// metricDef Describes a metric in the Prometheus text exposition format.
+type metricDef struct {
+	help, kind string
+}
+
+var metricDefs = map[string]metricDef{
+	metricKeyAge:          {"Age in days of each IAM access key.", "gauge"},
+	metricKeys:            {"Number of IAM access keys per user.", "gauge"},
+	metricUnmanagedKeys:   {"Number of IAM access keys per user made outside the rotator.", "gauge"},
+	metricStage:           {"Rotation stages attempted, succeeded or failed.", "counter"},
+	metricCallAttempts:    {"Calls made to IAM and storage targets per stage, counting retries.", "counter"},
+	metricStorageSeconds:  {"Time in seconds taken by the last write to a storage target.", "gauge"},
+	metricStorageErrors:   {"Number of failed writes to a storage target.", "counter"},
+	metricLastSuccessTime: {"Unix time of the last successful run.", "gauge"},
+}
+
+// metricsRegistry Holds the current value of every metric, keyed by name then by rendered labels.
+type metricsRegistry struct {
+	mu     sync.Mutex
+	values map[string]map[string]float64
+}
+
+// metrics Is what you use at runtime to record metrics.
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{values: make(map[string]map[string]float64)}
+}
+
+// labelString Render label key/value pairs, for example: {user="bob",key_id="ABC"}.
+func labelString(labels ...string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], v))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// set Set a metric to value.
+func (mr *metricsRegistry) set(name string, value float64, labels ...string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.values[name] == nil {
+		mr.values[name] = make(map[string]float64)
+	}
+
+	mr.values[name][labelString(labels...)] = value
+}
+
+// add Increase a metric by value.
+func (mr *metricsRegistry) add(name string, value float64, labels ...string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.values[name] == nil {
+		mr.values[name] = make(map[string]float64)
+	}
+
+	mr.values[name][labelString(labels...)] += value
+}
+
+// reset Remove all values of a metric, such as key ages for keys that no longer exist.
+func (mr *metricsRegistry) reset(name string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	delete(mr.values, name)
+}
+
+// writeTo Write all metrics in the Prometheus text exposition format.
+func (mr *metricsRegistry) writeTo(w io.Writer) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	names := make([]string, 0, len(mr.values))
+	for name := range mr.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		def := metricDefs[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, def.help, name, def.kind); err != nil {
+			return err
+		}
+
+		series := make([]string, 0, len(mr.values[name]))
+		for labels := range mr.values[name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+
+		for _, labels := range series {
+			if _, err := fmt.Fprintf(w, "%s%s %v\n", name, labels, mr.values[name][labels]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ServeHTTP Serve the /metrics endpoint.
+func (mr *metricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = mr.writeTo(w)
+}
+
+// writeTextfile Write all metrics to a file for the node-exporter textfile collector.
+// The file is replaced atomically, so the collector never reads a partial file.
+func (mr *metricsRegistry) writeTextfile(filename string) error {
+	buf := &bytes.Buffer{}
+	if err := mr.writeTo(buf); err != nil {
+		return fmt.Errorf(errors.metricsTextfileErr, err.Error())
+	}
+
+	if err := writeFileAtomic(filename, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf(errors.metricsTextfileErr, err.Error())
+	}
+
+	return nil
+}
+
+// recordStages Count the attempts, successes and failures of the stages of a rotation, and the calls each made.
+func recordStages(stages []rotator.StageResult) {
+	for _, s := range stages {
+		metrics.add(metricStage, 1, "stage", s.Name, "result", "attempted")
+		metrics.add(metricCallAttempts, float64(s.Attempts), "stage", s.Name)
+
+		if s.Err != nil {
+			metrics.add(metricStage, 1, "stage", s.Name, "result", "failed")
+			continue
+		}
+
+		metrics.add(metricStage, 1, "stage", s.Name, "result", "succeeded")
+	}
+}
+
+// recordStorage Run a write of a key to a storage target, recording how long it took, any error, and an audit entry.
+func recordStorage(target, keyId string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	metrics.set(metricStorageSeconds, time.Since(start).Seconds(), "target", target)
+
+	if err != nil {
+		metrics.add(metricStorageErrors, 1, "target", target)
+	}
+
+	auditTrail.recordOrLog("store:"+target, keyId, err)
+
+	return err
+}
+
+// recordKeyStats Record the age of each key, by its masked ID, and the number of keys per user.
+func recordKeyStats(keys []rotator.KeyInfo) {
+	metrics.reset(metricKeyAge)
+	metrics.reset(metricKeys)
+	metrics.reset(metricUnmanagedKeys)
+
+	counts := make(map[string]int)
+	unmanaged := make(map[string]int)
+	for _, v := range keys {
+		metrics.set(metricKeyAge, float64(v.Days), "user", v.UserName, "key_id", rotator.MaskKeyId(v.AccessKeyId))
+		counts[v.UserName]++
+		if v.Unmanaged {
+			unmanaged[v.UserName]++
+		}
+	}
+
+	for user, n := range counts {
+		metrics.set(metricKeys, float64(n), "user", user)
+		metrics.set(metricUnmanagedKeys, float64(unmanaged[user]), "user", user)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsWriteTo(tester *testing.T) {
+	cases := []struct {
+		name   string
+		record func(mr *metricsRegistry)
+		want   []string
+	}{
+		{
+			"gauge",
+			func(mr *metricsRegistry) { mr.set(metricKeys, 2, "user", "bob") },
+			[]string{"# TYPE iam_key_rotator_keys gauge", `iam_key_rotator_keys{user="bob"} 2`},
+		},
+		{
+			"counter",
+			func(mr *metricsRegistry) {
+				mr.add(metricStorageErrors, 1, "target", "circleci")
+				mr.add(metricStorageErrors, 1, "target", "circleci")
+			},
+			[]string{"# TYPE iam_key_rotator_storage_write_errors_total counter", `iam_key_rotator_storage_write_errors_total{target="circleci"} 2`},
+		},
+		{
+			"no_labels",
+			func(mr *metricsRegistry) { mr.set(metricLastSuccessTime, 1639098000) },
+			[]string{"iam_key_rotator_last_success_timestamp_seconds 1.639098e+09"},
+		},
+		{
+			"escaped_label",
+			func(mr *metricsRegistry) { mr.set(metricKeys, 1, "user", `a"b`) },
+			[]string{`iam_key_rotator_keys{user="a\"b"} 1`},
+		},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			mr := newMetricsRegistry()
+			test.record(mr)
+
+			buf := &bytes.Buffer{}
+			if err := mr.writeTo(buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("want %q in output, got %q", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsServeHTTP(tester *testing.T) {
+	mr := newMetricsRegistry()
+	mr.set(metricKeys, 1, "user", "bob")
+
+	rec := httptest.NewRecorder()
+	mr.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), `iam_key_rotator_keys{user="bob"} 1`) {
+		tester.Errorf("metric missing from response, got %q", rec.Body.String())
+	}
+}
+
+func TestMetricsWriteTextfile(tester *testing.T) {
+	mr := newMetricsRegistry()
+	mr.set(metricKeys, 1, "user", "bob")
+	filename := testTmp + "/metrics.prom"
+
+	if err := mr.writeTextfile(filename); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	content, _ := ioutil.ReadFile(filename)
+	if !strings.Contains(string(content), `iam_key_rotator_keys{user="bob"} 1`) {
+		tester.Errorf("metric missing from textfile, got %q", string(content))
+	}
+
+	if err := mr.writeTextfile(testTmp + "/does-not-exist/metrics.prom"); err == nil {
+		tester.Errorf("want an error writing to a missing directory, got nil")
+	}
+}
+
+func TestRecordStages(tester *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"succeeded", nil, "succeeded"},
+		{"failed", fmt.Errorf("a test error occurred"), "failed"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			metrics = newMetricsRegistry()
+
+			recordStages([]rotator.StageResult{{Name: "create", Err: test.err, Attempts: 2}})
+
+			buf := &bytes.Buffer{}
+			_ = metrics.writeTo(buf)
+			for _, want := range []string{
+				`iam_key_rotator_stage_total{stage="create",result="attempted"} 1`,
+				`iam_key_rotator_stage_total{stage="create",result="` + test.want + `"} 1`,
+				`iam_key_rotator_call_attempts_total{stage="create"} 2`,
+			} {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("want %q in output, got %q", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRecordKeyStats(tester *testing.T) {
+	metrics = newMetricsRegistry()
+	u1 := "bob"
+	s1 := "AKIAABC1230000000001"
+	s2 := "AKIADEF4560000000002"
+
+	recordKeyStats([]rotator.KeyInfo{
+		{AccessKeyId: s1, UserName: u1, Days: 10},
+		{AccessKeyId: s2, UserName: u1, Days: 40, Unmanaged: true},
+	})
+
+	buf := &bytes.Buffer{}
+	_ = metrics.writeTo(buf)
+	for _, want := range []string{
+		`iam_key_rotator_key_age_days{user="bob",key_id="AKIA************0001"} 10`,
+		`iam_key_rotator_key_age_days{user="bob",key_id="AKIA************0002"} 40`,
+		`iam_key_rotator_keys{user="bob"} 2`,
+		`iam_key_rotator_unmanaged_keys{user="bob"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			tester.Errorf("want %q in output, got %q", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Events a notification can be sent for.
+const (
+	eventRotated  = "rotated"
+	eventWarning  = "warning"
+	eventFailure  = "failure"
+	eventRollback = "rollback"
+)
+
+// rotationEvent Describes something that happened during a run. It must never hold the secret access key.
+type rotationEvent struct {
+	Event   string    `json:"event"`
+	User    string    `json:"user"`
+	KeyId   string    `json:"key_id,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// notifier Sends an event somewhere a person will see it.
+type notifier interface {
+	notify(ctx context.Context, e *rotationEvent) error
+}
+
+// notifications Sends events to all configured notifiers, but only for the events asked for.
+type notifications struct {
+	events    map[string]bool
+	notifiers []notifier
+	// timeout Longest each notifier may take, 0 for no limit.
+	timeout time.Duration
+}
+
+type slackNotifier struct {
+	url string
+	hc  httpCommunicator
+}
+
+type teamsNotifier struct {
+	url string
+	hc  httpCommunicator
+}
+
+type webhookNotifier struct {
+	url string
+	hc  httpCommunicator
+}
+
+// newNotifications Build the notifiers configured by flags. Tags are used to look up key owners, and may be nil.
+func newNotifications(ac *applicationFlags, hc httpCommunicator, tags userTagLister) *notifications {
+	n := &notifications{events: make(map[string]bool), timeout: *ac.callTimeout}
+
+	for _, e := range strings.Split(*ac.notifyOn, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			n.events[e] = true
+		}
+	}
+
+	if *ac.slackWebhook != "" {
+		n.notifiers = append(n.notifiers, &slackNotifier{*ac.slackWebhook, hc})
+	}
+
+	if *ac.teamsWebhook != "" {
+		n.notifiers = append(n.notifiers, &teamsNotifier{*ac.teamsWebhook, hc})
+	}
+
+	if *ac.webhook != "" {
+		n.notifiers = append(n.notifiers, &webhookNotifier{*ac.webhook, hc})
+	}
+
+	if *ac.smtpAddr != "" {
+		if en, err := newEmailNotifier(ac, tags); err != nil {
+			appLog.Warn(err.Error())
+		} else {
+			n.notifiers = append(n.notifiers, en)
+		}
+	}
+
+	return n
+}
+
+// send Send the event to every notifier. A failed notification is logged, it does not fail the run. It does not use
+// the context of the run, so failures are still reported after the run is cancelled or times out.
+func (n *notifications) send(e *rotationEvent) {
+	if !n.events[e.Event] {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	// Messages can hold errors with responses from a CI service, which may echo the secret back.
+	e.Message = rotator.Redact(e.Message)
+
+	for _, v := range n.notifiers {
+		ctx, cancel := context.WithCancel(context.Background())
+		if n.timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), n.timeout)
+		}
+
+		if err := v.notify(ctx, e); err != nil {
+			appLog.Warn(fmt.Sprintf(errors.notifyErr, e.Event, err.Error()), "user", e.User)
+		}
+		cancel()
+	}
+}
+
+// summary A one line description of the event for chat messages.
+func (e *rotationEvent) summary() string {
+	s := fmt.Sprintf("IAM key rotator [%s] user %q", e.Event, e.User)
+	if e.KeyId != "" {
+		s += fmt.Sprintf(" key %s", e.KeyId)
+	}
+
+	return s + ": " + e.Message
+}
+
+// notify Post to a Slack incoming webhook.
+func (sn *slackNotifier) notify(ctx context.Context, e *rotationEvent) error {
+	return postJSON(ctx, sn.url, map[string]string{"text": e.summary()}, sn.hc)
+}
+
+// notify Post a message card to a Microsoft Teams incoming webhook.
+func (tn *teamsNotifier) notify(ctx context.Context, e *rotationEvent) error {
+	color := "2EB886"
+	if e.Event == eventFailure || e.Event == eventRollback {
+		color = "D00000"
+	} else if e.Event == eventWarning {
+		color = "DAA038"
+	}
+
+	card := map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    e.summary(),
+		"themeColor": color,
+		"title":      "IAM key rotator: " + e.Event,
+		"text":       e.summary(),
+	}
+
+	return postJSON(ctx, tn.url, card, tn.hc)
+}
+
+// notify Post the event as JSON to a generic webhook.
+func (wn *webhookNotifier) notify(ctx context.Context, e *rotationEvent) error {
+	return postJSON(ctx, wn.url, e, wn.hc)
+}
+
+// postJSON Post a payload as JSON, any response other than 2xx is an error.
+func postJSON(ctx context.Context, url string, payload interface{}, hc httpCommunicator) error {
+	content, err1 := json.Marshal(payload)
+	if err1 != nil {
+		return err1
+	}
+
+	req, err2 := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(content))
+	if err2 != nil {
+		return err2
+	}
+
+	req.Header.Add("content-type", "application/json")
+
+	res, err3 := hc.Do(req)
+	if err3 != nil {
+		return err3
+	}
+
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf(errors.webhookResponseErr, res.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spyHttpClient Records every request so tests can assert on payloads.
+type spyHttpClient struct {
+	StatusCode int
+	requests   []*http.Request
+	bodies     []string
+}
+
+func (shc *spyHttpClient) Do(req *http.Request) (*http.Response, error) {
+	body, _ := ioutil.ReadAll(req.Body)
+	shc.requests = append(shc.requests, req)
+	shc.bodies = append(shc.bodies, string(body))
+
+	code := shc.StatusCode
+	if code == 0 {
+		code = 200
+	}
+
+	return &http.Response{StatusCode: code, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+}
+
+func TestNotifiers(tester *testing.T) {
+	e := &rotationEvent{Event: eventRotated, User: "bob", KeyId: "AKIANEW", Message: "made a new key to replace AKIAOLD"}
+
+	cases := []struct {
+		name     string
+		notifier func(hc httpCommunicator) notifier
+		want     string
+	}{
+		{"slack", func(hc httpCommunicator) notifier { return &slackNotifier{"https://hooks.slack.test", hc} }, `"text":"IAM key rotator [rotated] user \"bob\" key AKIANEW`},
+		{"teams", func(hc httpCommunicator) notifier { return &teamsNotifier{"https://teams.test", hc} }, `"@type":"MessageCard"`},
+		{"webhook", func(hc httpCommunicator) notifier { return &webhookNotifier{"https://webhook.test", hc} }, `"event":"rotated","user":"bob","key_id":"AKIANEW"`},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			hc := &spyHttpClient{}
+
+			if err := test.notifier(hc).notify(context.TODO(), e); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(hc.bodies) != 1 {
+				t.Fatalf("want 1 request, got %v", len(hc.bodies))
+			}
+
+			if hc.requests[0].Method != "POST" {
+				t.Errorf("want POST, got %v", hc.requests[0].Method)
+			}
+
+			if !strings.Contains(hc.bodies[0], test.want) {
+				t.Errorf("want %v in payload, got %v", test.want, hc.bodies[0])
+			}
+
+			if !json.Valid([]byte(hc.bodies[0])) {
+				t.Errorf("payload is not valid JSON: %v", hc.bodies[0])
+			}
+		})
+	}
+}
+
+func TestNotificationsSend(tester *testing.T) {
+	cases := []struct {
+		name     string
+		notifyOn string
+		event    string
+		want     int
+	}{
+		{"allowed", "rotated,failure", eventFailure, 1},
+		{"filtered", "rotated", eventWarning, 0},
+		{"none", "", eventRotated, 0},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			hc := &spyHttpClient{}
+			empty := ""
+			timeout := time.Second
+			ac := &applicationFlags{notifyOn: &test.notifyOn, slackWebhook: &empty, teamsWebhook: &empty, smtpAddr: &empty, callTimeout: &timeout}
+			url := "https://webhook.test"
+			ac.webhook = &url
+
+			newNotifications(ac, hc, nil).send(&rotationEvent{Event: test.event, User: "bob"})
+
+			if len(hc.bodies) != test.want {
+				t.Errorf("want %v requests, got %v", test.want, len(hc.bodies))
+			}
+		})
+	}
+}
+
+func TestNotificationsSendRedacts(tester *testing.T) {
+	hc := &spyHttpClient{}
+	empty := ""
+	notifyOn := eventFailure
+	timeout := time.Second
+	url := "https://webhook.test"
+	ac := &applicationFlags{notifyOn: &notifyOn, slackWebhook: &empty, teamsWebhook: &empty, smtpAddr: &empty, callTimeout: &timeout, webhook: &url}
+	secret := "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+
+	newNotifications(ac, hc, nil).send(&rotationEvent{Event: eventFailure, User: "bob", Message: "response: {\"value\":\"" + secret + "\"}"})
+
+	if len(hc.bodies) != 1 {
+		tester.Fatalf("want 1 request, got %v", len(hc.bodies))
+	}
+
+	if strings.Contains(hc.bodies[0], secret) || !strings.Contains(hc.bodies[0], "[REDACTED]") {
+		tester.Errorf("want the secret redacted, got %v", hc.bodies[0])
+	}
+}
+
+func TestPostJSONError(tester *testing.T) {
+	hc := &spyHttpClient{StatusCode: 500}
+
+	if err := postJSON(context.TODO(), "https://webhook.test", map[string]string{}, hc); err == nil {
+		tester.Errorf("want an error for a 500 response, got nil")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// smtpPasswordEnv The environment variable the SMTP password is read from when -smtpPassword is not given.
+const smtpPasswordEnv = "SMTP_PASSWORD"
+
+// defaultEmailTemplate The message sent to key owners, override it with the -emailTemplate flag.
+const defaultEmailTemplate = `From: {{.From}}
+To: {{join .To ", "}}
+Subject: AWS access key {{.Event.Event}} for IAM user {{.Event.User}}
+Content-Type: text/plain; charset=UTF-8
+
+Hello,
+
+{{if eq .Event.Event "rotated"}}The AWS access key for IAM user {{.Event.User}} was rotated, the new key ID is {{.Event.KeyId}}.
+{{- else}}The AWS access key {{.Event.KeyId}} for IAM user {{.Event.User}} is about to expire.
+{{- end}}
+
+{{.Event.Message}}
+
+Sent by iam-user-key-rotator at {{.Event.Time.Format "2006-01-02T15:04:05Z07:00"}}.
+`
+
+// userTagLister Reads the tags on an IAM user, used to find who owns a key.
+type userTagLister interface {
+	ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error)
+}
+
+// emailNotifier Sends an email to the key owner when a key is rotated or about to expire.
+type emailNotifier struct {
+	addr, from, username, password, tagKey string
+	to                                     []string
+	tags                                   userTagLister
+	tmpl                                   *template.Template
+}
+
+// emailData Is what the email template is rendered with.
+type emailData struct {
+	From  string
+	To    []string
+	Event *rotationEvent
+}
+
+// newEmailNotifier Build an email notifier from flags, loading the template override when one is set.
+func newEmailNotifier(ac *applicationFlags, tags userTagLister) (*emailNotifier, error) {
+	text := defaultEmailTemplate
+
+	if *ac.emailTemplate != "" {
+		content, err := ioutil.ReadFile(*ac.emailTemplate)
+		if err != nil {
+			return nil, fmt.Errorf(errors.emailTemplateErr, err.Error())
+		}
+		text = string(content)
+	}
+
+	tmpl, err1 := template.New("email").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err1 != nil {
+		return nil, fmt.Errorf(errors.emailTemplateErr, err1.Error())
+	}
+
+	en := &emailNotifier{
+		addr:     *ac.smtpAddr,
+		from:     *ac.emailFrom,
+		username: *ac.smtpUser,
+		password: *ac.smtpPassword,
+		tagKey:   *ac.emailTag,
+		tags:     tags,
+		tmpl:     tmpl,
+	}
+
+	// Read from the environment here, not as the default of the flag, so -help does not show it.
+	if en.password == "" {
+		en.password = os.Getenv(smtpPasswordEnv)
+	}
+
+	for _, v := range strings.Split(*ac.emailTo, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			en.to = append(en.to, v)
+		}
+	}
+
+	return en, nil
+}
+
+// recipients Get who to email about a user, from the -emailTo flag and the owner tag on the IAM user.
+func (en *emailNotifier) recipients(ctx context.Context, user string) ([]string, error) {
+	to := append([]string{}, en.to...)
+
+	if en.tags == nil || en.tagKey == "" || user == "" {
+		return to, nil
+	}
+
+	luto, err := en.tags.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: &user})
+	if err != nil {
+		return to, err
+	}
+
+	for _, t := range luto.Tags {
+		if aws.ToString(t.Key) != en.tagKey {
+			continue
+		}
+		for _, v := range strings.Split(aws.ToString(t.Value), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				to = append(to, v)
+			}
+		}
+	}
+
+	return to, nil
+}
+
+// notify Email the owners of the key, only when the key was rotated or is about to expire.
+func (en *emailNotifier) notify(ctx context.Context, e *rotationEvent) error {
+	if e.Event != eventRotated && e.Event != eventWarning {
+		return nil
+	}
+
+	to, err1 := en.recipients(ctx, e.User)
+	if err1 != nil {
+		return err1
+	}
+
+	if len(to) == 0 {
+		return fmt.Errorf(errors.emailNoRecipients, e.User)
+	}
+
+	msg := &bytes.Buffer{}
+	if err := en.tmpl.Execute(msg, &emailData{en.from, to, e}); err != nil {
+		return fmt.Errorf(errors.emailTemplateErr, err.Error())
+	}
+
+	return en.send(ctx, to, bytes.ReplaceAll(msg.Bytes(), []byte("\n"), []byte("\r\n")))
+}
+
+// send Deliver a message over SMTP, upgrading to TLS with STARTTLS when the server supports it.
+func (en *emailNotifier) send(ctx context.Context, to []string, msg []byte) error {
+	host, _, err1 := net.SplitHostPort(en.addr)
+	if err1 != nil {
+		return err1
+	}
+
+	conn, err2 := (&net.Dialer{}).DialContext(ctx, "tcp", en.addr)
+	if err2 != nil {
+		return err2
+	}
+
+	// The SMTP client does not take a context, so the connection stops working at its deadline instead.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err4 := smtp.NewClient(conn, host)
+	if err4 != nil {
+		_ = conn.Close()
+		return err4
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if en.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", en.username, en.password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(en.from); err != nil {
+		return err
+	}
+
+	for _, v := range to {
+		if err := c.Rcpt(v); err != nil {
+			return err
+		}
+	}
+
+	w, err3 := c.Data()
+	if err3 != nil {
+		return err3
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// fakeSmtpServer A minimal SMTP server that accepts one message and records it.
+type fakeSmtpServer struct {
+	ln    net.Listener
+	rcpts []string
+	data  string
+	done  chan struct{}
+}
+
+func newFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start fake SMTP server: %v", err)
+	}
+
+	fs := &fakeSmtpServer{ln: ln, done: make(chan struct{})}
+	go fs.serve()
+
+	return fs
+}
+
+func (fs *fakeSmtpServer) serve() {
+	defer close(fs.done)
+
+	conn, err := fs.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost fake ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			_ = tp.PrintfLine("235 accepted")
+		case "MAIL":
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			fs.rcpts = append(fs.rcpts, strings.Trim(strings.SplitN(line, ":", 2)[1], "<> "))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			lines, _ := tp.ReadDotLines()
+			fs.data = strings.Join(lines, "\n")
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+type mockTagLister struct {
+	tags []types.Tag
+}
+
+func (mtl *mockTagLister) ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error) {
+	if *params.UserName == "DERR" {
+		return nil, fmt.Errorf("a test error occurred")
+	}
+
+	return &iam.ListUserTagsOutput{Tags: mtl.tags}, nil
+}
+
+func testEmailFlags(addr, to, tmpl string) *applicationFlags {
+	from := "rotator@example.com"
+	user := "rotator"
+	pass := "secret"
+	tag := "owner-email"
+
+	return &applicationFlags{
+		smtpAddr:      &addr,
+		smtpUser:      &user,
+		smtpPassword:  &pass,
+		emailFrom:     &from,
+		emailTo:       &to,
+		emailTag:      &tag,
+		emailTemplate: &tmpl,
+	}
+}
+
+func TestEmailNotifier(tester *testing.T) {
+	k, v := "owner-email", "owner@example.com, lead@example.com"
+	tags := &mockTagLister{[]types.Tag{{Key: &k, Value: &v}}}
+
+	cases := []struct {
+		name      string
+		event     string
+		wantRcpts int
+		wantBody  string
+	}{
+		{"rotated", eventRotated, 3, "was rotated, the new key ID is AKIANEW"},
+		{"warning", eventWarning, 3, "is about to expire"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fs := newFakeSmtpServer(t)
+			defer fs.ln.Close()
+
+			en, err1 := newEmailNotifier(testEmailFlags(fs.ln.Addr().String(), "ops@example.com", ""), tags)
+			if err1 != nil {
+				t.Fatalf("unexpected error: %v", err1)
+			}
+
+			if err := en.notify(context.TODO(), &rotationEvent{Event: test.event, User: "bob", KeyId: "AKIANEW"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			<-fs.done
+
+			if len(fs.rcpts) != test.wantRcpts {
+				t.Errorf("want %v recipients, got %v", test.wantRcpts, fs.rcpts)
+			}
+
+			if !strings.Contains(fs.data, test.wantBody) {
+				t.Errorf("want %q in message, got %q", test.wantBody, fs.data)
+			}
+
+			if !strings.Contains(fs.data, "To: ops@example.com, owner@example.com, lead@example.com") {
+				t.Errorf("want all recipients in the To header, got %q", fs.data)
+			}
+		})
+	}
+}
+
+func TestEmailNotifierSkipsOtherEvents(tester *testing.T) {
+	en := &emailNotifier{addr: "127.0.0.1:1", to: []string{"ops@example.com"}, tmpl: template.Must(template.New("").Parse(""))}
+
+	if err := en.notify(context.TODO(), &rotationEvent{Event: eventFailure, User: "bob"}); err != nil {
+		tester.Errorf("want failure events to be ignored, got %v", err)
+	}
+}
+
+func TestEmailNotifierRecipients(tester *testing.T) {
+	cases := []struct {
+		name  string
+		to    string
+		user  string
+		tags  userTagLister
+		want  int
+		throw bool
+	}{
+		{"flag_only", "a@example.com,b@example.com", "bob", nil, 2, false},
+		{"no_tag", "", "bob", &mockTagLister{}, 0, false},
+		{"tag_err", "a@example.com", "DERR", &mockTagLister{}, 1, true},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			en, _ := newEmailNotifier(testEmailFlags("127.0.0.1:1", test.to, ""), test.tags)
+
+			got, err := en.recipients(context.TODO(), test.user)
+			if (err != nil) != test.throw {
+				t.Errorf("want error %v, got %v", test.throw, err)
+			}
+
+			if len(got) != test.want {
+				t.Errorf("want %v recipients, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestEmailNotifierPasswordFromEnv(tester *testing.T) {
+	tester.Setenv(smtpPasswordEnv, "from-env")
+
+	cases := []struct {
+		name, flag, want string
+	}{
+		{"flag", "from-flag", "from-flag"},
+		{"env", "", "from-env"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := testEmailFlags("127.0.0.1:1", "", "")
+			ac.smtpPassword = &test.flag
+
+			en, err := newEmailNotifier(ac, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if en.password != test.want {
+				t.Errorf("want password %q, got %q", test.want, en.password)
+			}
+		})
+	}
+}
+
+func TestEmailTemplateOverride(tester *testing.T) {
+	if _, err := newEmailNotifier(testEmailFlags("127.0.0.1:1", "", testTmp+"/missing.tmpl"), nil); err == nil {
+		tester.Errorf("want an error for a missing template, got nil")
+	}
+
+	filename := testTmp + "/email.tmpl"
+	_ = ioutil.WriteFile(filename, []byte("Subject: {{.Event.User}}\n\ncustom"), 0600)
+	en, err := newEmailNotifier(testEmailFlags("127.0.0.1:1", "", filename), nil)
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	_ = en.tmpl.Execute(buf, &emailData{Event: &rotationEvent{User: "bob"}})
+	if buf.String() != "Subject: bob\n\ncustom" {
+		tester.Errorf("want the custom template, got %q", buf.String())
+	}
+}
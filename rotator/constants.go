@@ -0,0 +1,41 @@
+package rotator
+
+// Stages of a rotation, in the order they run.
+const (
+	StageList         = "list"
+	StageLock         = "lock"
+	StageMakeRoom     = "make_room"
+	StageRemoveExcess = "remove_excess"
+	StageCreate       = "create"
+	// StageVerify Checks IAM has the new SSH public key before it is saved, only SSH key rotations run it.
+	StageVerify = "verify"
+	StageSave   = "save"
+	StageDelete = "delete"
+	// StageTag Records the rotation in tags of the user. It runs after the rotation succeeded, so a failure is only
+	// recorded in the result, and not returned.
+	StageTag = "tag"
+)
+
+var stdMsgs = struct {
+	expireKey,
+	expireServiceCredential,
+	expireSSHKey,
+	keptNewKey,
+	noValidKeys,
+	removedKey,
+	rolledBack,
+	saving,
+	tagsUnread,
+	unmanagedKey string
+}{
+	expireKey:               "current IAM key has expired, making a new key",
+	expireServiceCredential: "current service credential has expired, making a new one",
+	expireSSHKey:            "current SSH key has expired, making a new key pair",
+	keptNewKey:              "kept the new key, a store has saved it, save it to the rest by hand",
+	noValidKeys:             "no valid keys, making a new key",
+	removedKey:              "removed key",
+	rolledBack:              "rolled back new key",
+	saving:                  "saving new key",
+	tagsUnread:              "could not read the tags of the user, keys made outside the rotator are not found",
+	unmanagedKey:            "key was not made by the rotator",
+}
@@ -0,0 +1,237 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"strings"
+)
+
+// credentialKind What rotateCredential needs to know about a kind of credential, such as access keys or SSH keys.
+// Credentials are described as access keys, so their age and number are judged the same way.
+type credentialKind interface {
+	// list Get the credentials of the user.
+	list(ctx context.Context) ([]types.AccessKeyMetadata, error)
+	// current Get the ID of the credential in use.
+	current(keys []types.AccessKeyMetadata) (string, error)
+	// deleter Get what deletes the credentials of the user.
+	deleter(user string) keyDeleter
+	// create Make a new credential in place of the current one, recording it in the result, and return its ID.
+	create(ctx context.Context, res *Result, user string, stats *iamStats) (string, error)
+	// save Save the new credential in the result to the stores.
+	save(ctx context.Context, res *Result) error
+}
+
+// verifier A kind of credential that is checked in IAM before it is saved.
+type verifier interface {
+	verify(ctx context.Context, res *Result) error
+}
+
+// resetter A kind of credential that may change the one in use instead of making another, which cannot be rolled back.
+type resetter interface {
+	reset() bool
+}
+
+// recorder A kind of credential whose rotations are recorded in the tags of the user.
+type recorder interface {
+	// metadata Read what the tags of the user say about the last rotation.
+	metadata(ctx context.Context, user string) *Metadata
+	// record Record the rotation in the result in the tags of the user.
+	record(ctx context.Context, res *Result) error
+}
+
+// rotateCredential Remove credentials of a kind that are too old or too many, then replace the current one when it has
+// expired, recording what happened in the result. A new credential that cannot be verified, or saved to any store, is
+// deleted again.
+func (r *Rotator) rotateCredential(ctx context.Context, res *Result, kind credentialKind) error {
+	currentId := ""
+	var keys []types.AccessKeyMetadata
+	listKeys := func() error {
+		err := r.call(ctx, func(ctx context.Context) (err error) {
+			keys, err = kind.list(ctx)
+			return
+		})
+		if err != nil {
+			return err
+		}
+
+		if currentId, err = kind.current(keys); err != nil {
+			return err
+		}
+		res.CurrentKeyId = currentId
+
+		return nil
+	}
+
+	if err := r.stage(ctx, res, StageList, listKeys); err != nil {
+		return err
+	}
+
+	user := r.userName
+	if user == "" {
+		user = keyUser(keys, currentId)
+	}
+	res.User = user
+
+	// Keep other rotations of the user from making or deleting credentials until this one is done. They are listed
+	// again once locked, as another rotation may have changed them in the meantime.
+	if r.locker != nil {
+		locked := false
+		err := r.stage(ctx, res, StageLock, func() error {
+			if user == "" {
+				return fmt.Errorf(errMsgs.lockUserUnknown, MaskKeyId(currentId))
+			}
+
+			if err := r.locker.Lock(ctx, user); err != nil {
+				return err
+			}
+			locked = true
+
+			if err := listKeys(); err != nil {
+				return err
+			}
+
+			// Another rotation replaced the current credential while this one waited, so there is nothing left to do.
+			if keyUser(keys, currentId) == "" {
+				return withKind(ErrLocked, fmt.Errorf(errMsgs.rotatedElsewhere, MaskKeyId(currentId)))
+			}
+
+			return nil
+		})
+		if locked {
+			defer r.unlock(user)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	rec, recorded := kind.(recorder)
+	if recorded {
+		res.Metadata = rec.metadata(ctx, user)
+	}
+
+	stats := getIamKeyStats(keys, r.policy.MaxDaysAllowed, currentId, res.Metadata.managedKey(), r.clock.Now())
+	r.displayIamStats(stats)
+	r.reportStats(res, stats)
+
+	expired, err := stats.IsCurrentKeyExpired()
+	if err != nil {
+		return err
+	}
+
+	// Make sure there is room to make a new credential.
+	del := kind.deleter(user)
+	if err := r.trimKeys(ctx, res, del, stats); err != nil {
+		return err
+	}
+
+	newId := ""
+	if expired {
+		if err := r.stage(ctx, res, StageCreate, func() (err error) {
+			newId, err = kind.create(ctx, res, user, stats)
+			return
+		}); err != nil {
+			// The credential may have been made all the same, with a secret that was never seen.
+			if ambiguous(err) {
+				return r.reconcile(res, kind, del, stats, err)
+			}
+
+			return err
+		}
+
+		// Check the new credential before saving it, so a store is not left holding one that does not work.
+		if v, ok := kind.(verifier); ok {
+			if err := r.stage(ctx, res, StageVerify, func() error { return v.verify(ctx, res) }); err != nil {
+				return r.rollback(res, kind, del, newId, err)
+			}
+		}
+
+		if err := r.stage(ctx, res, StageSave, func() error { return kind.save(ctx, res) }); err != nil {
+			return r.rollback(res, kind, del, newId, err)
+		}
+	}
+
+	// A credential that was reset is the current one, so there is nothing left to delete.
+	if newId != currentId {
+		// Delete any remaining credentials, which should only be the current one if any.
+		if err := r.stage(ctx, res, StageDelete, func() error {
+			return r.deleteKeys(ctx, del, stats.old)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Record the rotation on the user, the rotation stands when this fails.
+	if recorded && res.Rotated() && r.tagger != nil {
+		_ = r.stage(ctx, res, StageTag, func() error {
+			return rec.record(ctx, res)
+		})
+	}
+
+	return nil
+}
+
+// rollback Delete a new credential that could not be saved or verified, returning the error that stopped the rotation.
+// A credential that was reset cannot be put back, only reset again by hand. One that a store has saved is kept, along
+// with the current one, as deleting it would leave that store holding a credential that does not work.
+func (r *Rotator) rollback(res *Result, kind credentialKind, del keyDeleter, newId string, err error) error {
+	if rs, ok := kind.(resetter); ok && rs.reset() {
+		return withKind(ErrRollbackNeeded, fmt.Errorf(errMsgs.resetNotSaved, newId, err))
+	}
+
+	if len(res.Saved) > 0 {
+		r.log.Warn(stdMsgs.keptNewKey, "key_id", MaskKeyId(newId), "saved_to", strings.Join(res.Saved, ","))
+		return err
+	}
+
+	// The current credential is still in place, so remove the new one to leave IAM as it was.
+	res.RolledBack = true
+	res.RollbackErr = r.rollbackKey(del, newId)
+	if res.RollbackErr != nil {
+		return withKind(ErrRollbackNeeded, err)
+	}
+
+	return err
+}
+
+// reconcile List the credentials again after a create failed in a way that may have made one all the same, and
+// delete any that were not there before, as its secret cannot be saved. It returns the error that stopped the
+// rotation. Like rollbackKey, it does not use the context of the run.
+func (r *Rotator) reconcile(res *Result, kind credentialKind, del keyDeleter, stats *iamStats, err error) error {
+	var keys []types.AccessKeyMetadata
+	if e := r.call(context.Background(), func(ctx context.Context) (e error) {
+		keys, e = kind.list(ctx)
+		return
+	}); e != nil {
+		res.RollbackErr = fmt.Errorf(errMsgs.reconcileErr, e)
+		return withKind(ErrRollbackNeeded, err)
+	}
+
+	for _, k := range keys {
+		id := aws.ToString(k.AccessKeyId)
+		if stats.findKey(id) != nil {
+			continue
+		}
+
+		if res.RollbackErr = r.rollbackKey(del, id); res.RollbackErr != nil {
+			return withKind(ErrRollbackNeeded, err)
+		}
+	}
+
+	return err
+}
+
+// rollbackKey Delete a new key with del. It does not use the context of the run, so a key is not left behind when the
+// run is cancelled or times out while saving.
+func (r *Rotator) rollbackKey(del keyDeleter, id string) error {
+	if err := del(context.Background(), aws.String(id)); err != nil {
+		return fmt.Errorf(errMsgs.rollbackErr, id, err)
+	}
+
+	r.log.Warn(stdMsgs.rolledBack, "key_id", MaskKeyId(id))
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package rotator
+
+import (
+	"errors"
+	"github.com/aws/smithy-go"
+)
+
+// Kinds of failure, test for them with errors.Is. Errors returned by the rotator are of at most one kind, except that
+// ErrRollbackNeeded is also ErrStorageFailed.
+var (
+	// ErrConfigInvalid The options or flags given cannot work, nothing was changed.
+	ErrConfigInvalid = errors.New("configuration invalid")
+	// ErrAuthFailed The credentials were missing, expired or not allowed to make a call, nothing more was changed.
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrQuotaReached The user already has as many keys as IAM allows, so no new key could be made.
+	ErrQuotaReached = errors.New("IAM quota reached")
+	// ErrStorageFailed A new key was made but could not be saved, so it was deleted and the current key kept.
+	ErrStorageFailed = errors.New("storage failed after key created")
+	// ErrVerificationFailed A check of work already done did not pass, such as verifying the audit log.
+	ErrVerificationFailed = errors.New("verification failed")
+	// ErrRollbackNeeded A new key could not be saved and could not be deleted either, delete it by hand.
+	ErrRollbackNeeded = errors.New("rollback needed")
+	// ErrLocked Another rotation of the same user holds the lock, or rotated the current key while waiting for it,
+	// nothing was changed.
+	ErrLocked = errors.New("locked by another rotation")
+)
+
+// authCodes AWS error codes that mean the credentials are missing, wrong or not allowed.
+var authCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// Error An error of a kind above. Its message is that of the error it wraps, the kind only changes what errors.Is
+// matches.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// withKind Mark err as a kind of failure, nil stays nil.
+func withKind(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{kind, err}
+}
+
+// classify Mark an error from IAM with the kind it is, when it is one and does not have a kind already.
+func classify(err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return err
+	}
+
+	switch {
+	case authCodes[ae.ErrorCode()]:
+		return withKind(ErrAuthFailed, err)
+	case ae.ErrorCode() == "LimitExceeded":
+		return withKind(ErrQuotaReached, err)
+	}
+
+	return err
+}
+
+var errMsgs = struct {
+	checkKeyErr,
+	currentKeyMissing,
+	currentKeyNotFound,
+	deleteKeyErr,
+	iamClientMissing,
+	lockErr,
+	locked,
+	lockUserUnknown,
+	noActiveKey,
+	noActiveServiceCredential,
+	noActiveSSHKey,
+	probMakingNewKey,
+	probMakingServiceCredential,
+	reconcileErr,
+	resetNeedsTagger,
+	resetNotSaved,
+	rollbackErr,
+	rotatedElsewhere,
+	saveKeyErr,
+	serviceClientMissing,
+	sshClientMissing,
+	sshKeyInactive,
+	sshKeyMismatch,
+	sshKeyTypeInvalid,
+	sshUserMissing,
+	stopped,
+	unlockErr,
+	uploadSSHKeyErr string
+}{
+	checkKeyErr:                 "the new key saved to %v did not work; %w",
+	currentKeyMissing:           "the ID of the access key currently in use, or a user name, is required",
+	currentKeyNotFound:          "current key %q is not one of the keys of the user",
+	deleteKeyErr:                "could not delete key %q; %w",
+	iamClientMissing:            "an IAM client is required",
+	lockErr:                     "could not lock user %q; %w",
+	locked:                      "user %q is locked by %v until %v, another rotation is running",
+	lockUserUnknown:             "could not lock, the user of key %q is unknown",
+	noActiveKey:                 "user %q has no active access key to rotate",
+	noActiveServiceCredential:   "user %q has no active credential for %v to rotate",
+	noActiveSSHKey:              "user %q has no active SSH public key to rotate",
+	probMakingNewKey:            "problem with making a new access key: %w",
+	probMakingServiceCredential: "problem with making a new credential for %v: %w",
+	reconcileErr:                "could not list the credentials again to find one the failed create made, check for it by hand; %w",
+	resetNeedsTagger:            "resetting a service-specific credential needs a tagger, to record when it was reset",
+	resetNotSaved:               "the password of service credential %q was reset but could not be saved, reset it again by hand; %w",
+	rollbackErr:                 "could not roll back new key %q, delete it manually; %w",
+	rotatedElsewhere:            "key %q was rotated by another run while waiting for the lock",
+	saveKeyErr:                  "could not save the new key to %v; %w",
+	serviceClientMissing:        "an IAM client for service-specific credentials is required",
+	sshClientMissing:            "an IAM client for SSH public keys is required",
+	sshKeyInactive:              "SSH public key %q is %v in IAM, want Active",
+	sshKeyMismatch:              "SSH public key %q in IAM is not the one uploaded",
+	sshKeyTypeInvalid:           "SSH key type %q is not valid, use rsa or ed25519",
+	sshUserMissing:              "a user name is required to rotate SSH public keys",
+	stopped:                     "stopped before the %v stage; %w",
+	unlockErr:                   "could not unlock user %q; %w",
+	uploadSSHKeyErr:             "problem with uploading a new SSH public key: %w",
+}
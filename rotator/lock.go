@@ -0,0 +1,220 @@
+package rotator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Tags of the IAM user that hold the lock.
+const (
+	LockOwnerTag   = "iam-user-key-rotator:lock-owner"
+	LockExpiresTag = "iam-user-key-rotator:lock-expires"
+)
+
+// Defaults used for any TagLocker field left as zero.
+const (
+	DefaultLockLease  = 15 * time.Minute
+	DefaultLockPoll   = 5 * time.Second
+	DefaultLockSettle = 2 * time.Second
+)
+
+// ownerRe Characters not allowed in an IAM tag value.
+var ownerRe = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+
+// Locker Keeps rotations of the same user from overlapping. The rotator locks the user after listing their keys and
+// unlocks when it is done, so only one rotation at a time makes or deletes keys.
+type Locker interface {
+	// Lock Take the lock on the user, waiting while another rotation holds it.
+	Lock(ctx context.Context, user string) error
+	// Unlock Give up the lock on the user, when still held.
+	Unlock(ctx context.Context, user string) error
+}
+
+// TagClient The IAM calls a TagLocker makes, *iam.Client implements it.
+type TagClient interface {
+	ListUserTags(ctx context.Context, params *iam.ListUserTagsInput, optFns ...func(*iam.Options)) (*iam.ListUserTagsOutput, error)
+	TagUser(ctx context.Context, params *iam.TagUserInput, optFns ...func(*iam.Options)) (*iam.TagUserOutput, error)
+	UntagUser(ctx context.Context, params *iam.UntagUserInput, optFns ...func(*iam.Options)) (*iam.UntagUserOutput, error)
+}
+
+// TagLocker A lease on a user kept in tags of the IAM user, so rotations on different hosts or CI jobs do not overlap.
+// A lease that expired, because its holder died, is taken over. IAM has no conditional writes, so after tagging the
+// user the lock waits for Settle then checks it is still the owner, the rotation that tagged last wins.
+type TagLocker struct {
+	// IAM The client used to read and write the tags, required.
+	IAM TagClient
+	// Owner Tells lock holders apart, defaults to the host name, process ID and a random suffix.
+	Owner string
+	// Lease How long the lock is held before another rotation may take it over.
+	Lease time.Duration
+	// Wait How long to wait for another rotation to give up the lock, 0 to fail at once.
+	Wait time.Duration
+	// Poll How often to check whether the lock is free while waiting.
+	Poll time.Duration
+	// Settle How long to wait after tagging before checking the lock was not taken by another rotation too, negative for
+	// no wait.
+	Settle time.Duration
+	// Clock Defaults to the system clock.
+	Clock Clock
+}
+
+// lease Who holds the lock on a user and until when.
+type lease struct {
+	owner   string
+	expires time.Time
+}
+
+// Lock Take the lock on the user, waiting up to Wait while another rotation holds it. The error is ErrLocked when the
+// lock could not be taken in time.
+func (l *TagLocker) Lock(ctx context.Context, user string) error {
+	l = l.withDefaults()
+
+	deadline := l.Clock.Now().Add(l.Wait)
+
+	for {
+		held, err1 := l.read(ctx, user)
+		if err1 != nil {
+			return fmt.Errorf(errMsgs.lockErr, user, err1)
+		}
+
+		now := l.Clock.Now()
+		if held == nil || held.owner == l.Owner || !now.Before(held.expires) {
+			if err := l.write(ctx, user, now.Add(l.Lease)); err != nil {
+				return fmt.Errorf(errMsgs.lockErr, user, err)
+			}
+
+			if err := sleepContext(ctx, l.Settle); err != nil {
+				return fmt.Errorf(errMsgs.lockErr, user, err)
+			}
+
+			// Another rotation may have tagged the user at the same time.
+			if held, err1 = l.read(ctx, user); err1 != nil {
+				return fmt.Errorf(errMsgs.lockErr, user, err1)
+			}
+
+			if held != nil && held.owner == l.Owner {
+				return nil
+			}
+		}
+
+		if !l.Clock.Now().Before(deadline) || sleepContext(ctx, l.Poll) != nil {
+			return withKind(ErrLocked, lockedErr(user, held))
+		}
+	}
+}
+
+// lockedErr Tell who holds the lock on the user.
+func lockedErr(user string, held *lease) error {
+	if held == nil {
+		return fmt.Errorf(errMsgs.locked, user, "another rotation", "unknown")
+	}
+
+	return fmt.Errorf(errMsgs.locked, user, held.owner, held.expires.Format(time.RFC3339))
+}
+
+// Unlock Remove the lock tags from the user, unless another rotation has taken over the lock.
+func (l *TagLocker) Unlock(ctx context.Context, user string) error {
+	l = l.withDefaults()
+
+	held, err1 := l.read(ctx, user)
+	if err1 != nil {
+		return fmt.Errorf(errMsgs.unlockErr, user, err1)
+	}
+
+	if held == nil || held.owner != l.Owner {
+		return nil
+	}
+
+	if _, err := l.IAM.UntagUser(ctx, &iam.UntagUserInput{
+		UserName: aws.String(user),
+		TagKeys:  []string{LockOwnerTag, LockExpiresTag},
+	}); err != nil {
+		return fmt.Errorf(errMsgs.unlockErr, user, err)
+	}
+
+	return nil
+}
+
+// read Get the lease on the user, nil when there is none.
+func (l *TagLocker) read(ctx context.Context, user string) (*lease, error) {
+	out, err := l.IAM.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: aws.String(user)})
+	if err != nil {
+		return nil, err
+	}
+
+	held := &lease{}
+	for _, t := range out.Tags {
+		switch aws.ToString(t.Key) {
+		case LockOwnerTag:
+			held.owner = aws.ToString(t.Value)
+		case LockExpiresTag:
+			// A lease that cannot be read has expired, so it is taken over.
+			held.expires, _ = time.Parse(time.RFC3339, aws.ToString(t.Value))
+		}
+	}
+
+	if held.owner == "" {
+		return nil, nil
+	}
+
+	return held, nil
+}
+
+// write Tag the user with this owner, holding the lock until expires.
+func (l *TagLocker) write(ctx context.Context, user string, expires time.Time) error {
+	_, err := l.IAM.TagUser(ctx, &iam.TagUserInput{
+		UserName: aws.String(user),
+		Tags: []types.Tag{
+			{Key: aws.String(LockOwnerTag), Value: aws.String(l.Owner)},
+			{Key: aws.String(LockExpiresTag), Value: aws.String(expires.UTC().Format(time.RFC3339))},
+		},
+	})
+
+	return err
+}
+
+// withDefaults Get a copy with any field left as zero filled in. The owner is kept, so unlocking knows who locked.
+func (l *TagLocker) withDefaults() *TagLocker {
+	if l.Owner == "" {
+		l.Owner = defaultLockOwner()
+	}
+
+	c := *l
+
+	if c.Lease <= 0 {
+		c.Lease = DefaultLockLease
+	}
+
+	if c.Poll <= 0 {
+		c.Poll = DefaultLockPoll
+	}
+
+	if c.Settle < 0 {
+		c.Settle = 0
+	} else if c.Settle == 0 {
+		c.Settle = DefaultLockSettle
+	}
+
+	if c.Clock == nil {
+		c.Clock = systemClock{}
+	}
+
+	return &c
+}
+
+// defaultLockOwner Name this process, unique enough to tell it apart from any other rotation.
+func defaultLockOwner() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return ownerRe.ReplaceAllString(fmt.Sprintf("%v-%v-%v", host, os.Getpid(), hex.EncodeToString(suffix)), "_")
+}
@@ -0,0 +1,153 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"testing"
+	"time"
+)
+
+var lockNow = time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+
+// lockTags Tag a user as locked by owner until expires.
+func lockTags(owner string, expires time.Time) []types.Tag {
+	return []types.Tag{
+		{Key: aws.String(LockOwnerTag), Value: aws.String(owner)},
+		{Key: aws.String(LockExpiresTag), Value: aws.String(expires.Format(time.RFC3339))},
+	}
+}
+
+func TestTagLocker(tester *testing.T) {
+	cases := []struct {
+		name      string
+		tags      []types.Tag
+		wantErr   error
+		wantOwner string
+	}{
+		{"free", nil, nil, "me"},
+		{"held_by_me", lockTags("me", lockNow.Add(time.Minute)), nil, "me"},
+		{"held", lockTags("other", lockNow.Add(time.Minute)), ErrLocked, "other"},
+		{"expired", lockTags("other", lockNow.Add(-time.Minute)), nil, "me"},
+		{"unreadable", append(lockTags("other", lockNow)[:1], types.Tag{Key: aws.String(LockExpiresTag), Value: aws.String("soon")}), nil, "me"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			f.AddUser("bob", test.tags...)
+			l := &TagLocker{IAM: f, Owner: "me", Settle: -1, Clock: fixedClock{lockNow}}
+
+			err := l.Lock(context.TODO(), "bob")
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("want error %v, got %v", test.wantErr, err)
+			}
+
+			held, _ := l.read(context.TODO(), "bob")
+			if held == nil || held.owner != test.wantOwner {
+				t.Fatalf("want the lock held by %v, got %+v", test.wantOwner, held)
+			}
+
+			if held.owner == "me" && !held.expires.Equal(lockNow.Add(DefaultLockLease)) {
+				t.Errorf("want the lease to end at %v, got %v", lockNow.Add(DefaultLockLease), held.expires)
+			}
+
+			// Only the owner gives up the lock.
+			if err := l.Unlock(context.TODO(), "bob"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			held, _ = l.read(context.TODO(), "bob")
+			if (held == nil) != (test.wantOwner == "me") {
+				t.Errorf("want the lock given up only when held by me, got %+v", held)
+			}
+		})
+	}
+}
+
+func TestTagLockerWaits(tester *testing.T) {
+	f := iamfake.New()
+	f.AddUser("bob", lockTags("other", lockNow.Add(time.Minute))...)
+	l := &TagLocker{IAM: f, Owner: "me", Settle: -1, Wait: time.Second, Poll: time.Millisecond}
+
+	// The other rotation finishes while this one waits.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = f.UntagUser(context.TODO(), &iam.UntagUserInput{UserName: aws.String("bob"), TagKeys: []string{LockOwnerTag, LockExpiresTag}})
+	}()
+
+	if err := l.Lock(context.TODO(), "bob"); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	if held, _ := l.read(context.TODO(), "bob"); held == nil || held.owner != "me" {
+		tester.Errorf("want the lock held by me, got %+v", held)
+	}
+}
+
+func TestRotateLocked(tester *testing.T) {
+	cases := []struct {
+		name        string
+		tags        []types.Tag
+		elsewhere   bool
+		wantErr     error
+		wantRotated bool
+		wantStages  int
+		wantTags    int
+	}{
+		{"unlocked", nil, false, nil, true, 7, 0},
+		{"locked", lockTags("other", lockNow.Add(time.Minute)), false, ErrLocked, false, 2, 2},
+		// The other rotation replaces the current key, then gives up the lock while this one waits.
+		{"rotatedElsewhere", lockTags("other", lockNow.Add(time.Minute)), true, ErrLocked, false, 2, 0},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			f.Now = func() time.Time { return lockNow }
+			f.AddUser("bob", test.tags...)
+			f.AddKey("bob", "ABC123", lockNow.AddDate(0, 0, -40), types.StatusTypeActive)
+
+			locker := &TagLocker{IAM: f, Owner: "me", Settle: -1, Clock: fixedClock{lockNow}}
+			if test.elsewhere {
+				locker.Wait, locker.Poll = time.Second, time.Millisecond
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					f.AddKey("bob", "DEF456", lockNow, types.StatusTypeActive)
+					_, _ = f.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{UserName: aws.String("bob"), AccessKeyId: aws.String("ABC123")})
+					_, _ = f.UntagUser(context.TODO(), &iam.UntagUserInput{UserName: aws.String("bob"), TagKeys: []string{LockOwnerTag, LockExpiresTag}})
+				}()
+			}
+
+			r, _ := New(Options{
+				IAM:          f,
+				CurrentKeyId: "ABC123",
+				Stores:       []Store{&mockStore{}},
+				Clock:        fixedClock{lockNow},
+				Locker:       locker,
+				Policy:       Policy{MaxDaysAllowed: 30, MaxKeysAllowed: 2},
+			})
+
+			res, err := r.Rotate(context.TODO())
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("want error %v, got %v", test.wantErr, err)
+			}
+
+			if res.Rotated() != test.wantRotated || len(res.Stages) != test.wantStages || res.Stages[1].Name != StageLock {
+				t.Errorf("want rotated %v after %v stages, got %+v", test.wantRotated, test.wantStages, res.Stages)
+			}
+
+			// The lock is given up when done, and a lock held by another is left alone.
+			if len(f.Tags("bob")) != test.wantTags {
+				t.Errorf("want %v tags, got %v", test.wantTags, f.Tags("bob"))
+			}
+
+			if !test.wantRotated && len(f.Keys("bob")) != 1 {
+				t.Errorf("want no key made or deleted, got %v keys", len(f.Keys("bob")))
+			}
+		})
+	}
+}
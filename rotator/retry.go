@@ -0,0 +1,239 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/smithy-go"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults used for any RetryPolicy field left as zero.
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 200 * time.Millisecond
+	DefaultMaxDelay    = 20 * time.Second
+)
+
+// slowDownCodes AWS error codes that mean the call was turned away without being carried out, so it is safe to make
+// again even when it creates something.
+var slowDownCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottledException":              true,
+	"TooManyRequestsException":               true,
+	"RequestLimitExceeded":                   true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// throttleCodes AWS error codes that mean slow down or try again, the call may have been carried out for those not in
+// slowDownCodes.
+var throttleCodes = map[string]bool{
+	"ServiceUnavailable":          true,
+	"ServiceUnavailableException": true,
+	"InternalFailure":             true,
+	"InternalError":               true,
+	"InternalServerError":         true,
+	"RequestTimeout":              true,
+	"RequestTimeoutException":     true,
+	"ConcurrentModification":      true,
+}
+
+// RetryPolicy How often and how long to wait before a failed call is tried again. Only retryable errors are retried,
+// see Retryable.
+type RetryPolicy struct {
+	// MaxAttempts The most times a call is made, 1 to never retry.
+	MaxAttempts int
+	// BaseDelay The longest wait before the first retry, it doubles with every attempt.
+	BaseDelay time.Duration
+	// MaxDelay The longest wait between attempts.
+	MaxDelay time.Duration
+
+	// sleep Waits between attempts, replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// StatusError An HTTP response that was not a success. Stores return it so the rotator can tell whether to retry.
+type StatusError struct {
+	StatusCode int
+	// RetryAfter How long the server asked to wait before trying again, from the Retry-After header.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// NewStatusError Wrap err with the status and Retry-After header of the response.
+func NewStatusError(res *http.Response, err error) *StatusError {
+	return &StatusError{
+		StatusCode: res.StatusCode,
+		RetryAfter: ParseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		Err:        err,
+	}
+}
+
+// ParseRetryAfter Read a Retry-After header, which is either a number of seconds or an HTTP date.
+func ParseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// Retryable Indicates the error is likely to go away when the call is made again, such as throttling, a 5xx response
+// or a network timeout. Everything else, such as access denied or a missing entity, is terminal.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// Only the attempt timed out, the caller checks whether the whole run did.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return retryableStatus(se.StatusCode)
+	}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) && (slowDownCodes[ae.ErrorCode()] || throttleCodes[ae.ErrorCode()]) {
+		return true
+	}
+
+	var hs interface{ HTTPStatusCode() int }
+	if errors.As(err, &hs) && retryableStatus(hs.HTTPStatusCode()) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// Throttled Indicates the call was turned away without being carried out, such as throttling or a 429 response. Unlike
+// other retryable errors, such as a timeout or a 5xx response, a call that creates something can be made again.
+func Throttled(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) && slowDownCodes[ae.ErrorCode()] {
+		return true
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests
+	}
+
+	var hs interface{ HTTPStatusCode() int }
+	return errors.As(err, &hs) && hs.HTTPStatusCode() == http.StatusTooManyRequests
+}
+
+// ambiguous Indicates a failed call may have been carried out all the same, such as when the response was lost to a
+// timeout, a network error or a 5xx response.
+func ambiguous(err error) bool {
+	return Retryable(err) && !Throttled(err)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// withDefaults Fill in any field left as zero.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultBaseDelay
+	}
+
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+
+	if p.sleep == nil {
+		p.sleep = sleepContext
+	}
+
+	return p
+}
+
+// Do Call fn until it succeeds, returns a terminal error, the attempts run out, or the context is done. The number of
+// attempts made is returned with the last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) (int, error) {
+	return p.do(ctx, Retryable, fn)
+}
+
+// do Call fn like Do, retrying only the errors retry accepts.
+func (p RetryPolicy) do(ctx context.Context, retry func(err error) bool, fn func(ctx context.Context) error) (int, error) {
+	p = p.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !retry(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		d := p.delay(attempt, err)
+
+		// Give up now rather than wait past the deadline of the whole run.
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return attempt, err
+		}
+
+		if e := p.sleep(ctx, d); e != nil {
+			return attempt, err
+		}
+	}
+}
+
+// delay How long to wait after the attempt failed. A Retry-After from the server is respected, otherwise it is a random
+// wait of up to BaseDelay doubled for each attempt made (full jitter), never more than MaxDelay.
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return se.RetryAfter
+	}
+
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		if d := p.BaseDelay << uint(attempt-1); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// sleepContext Wait for the duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
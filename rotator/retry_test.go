@@ -0,0 +1,151 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/smithy-go"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryable(tester *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain", fmt.Errorf("a test error occurred"), false},
+		{"throttled", &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}, true},
+		{"throttled_wrapped", fmt.Errorf("could not delete key; %w", &smithy.GenericAPIError{Code: "Throttling"}), true},
+		{"access_denied", &smithy.GenericAPIError{Code: "AccessDenied"}, false},
+		{"no_such_entity", &smithy.GenericAPIError{Code: "NoSuchEntity"}, false},
+		{"too_many_requests", &StatusError{StatusCode: http.StatusTooManyRequests, Err: fmt.Errorf("slow down")}, true},
+		{"bad_gateway", &StatusError{StatusCode: http.StatusBadGateway, Err: fmt.Errorf("bad gateway")}, true},
+		{"bad_request", &StatusError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("bad request")}, false},
+		{"unauthorized", &StatusError{StatusCode: http.StatusUnauthorized, Err: fmt.Errorf("unauthorized")}, false},
+		{"network", &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, true},
+		{"attempt_timed_out", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			if got := Retryable(test.err); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestThrottled(tester *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"throttled", fmt.Errorf("could not make key; %w", &smithy.GenericAPIError{Code: "Throttling"}), true},
+		{"too_many_requests", &StatusError{StatusCode: http.StatusTooManyRequests, Err: fmt.Errorf("slow down")}, true},
+		{"internal_failure", &smithy.GenericAPIError{Code: "InternalFailure"}, false},
+		{"bad_gateway", &StatusError{StatusCode: http.StatusBadGateway, Err: fmt.Errorf("bad gateway")}, false},
+		{"network", &net.OpError{Op: "read", Err: fmt.Errorf("connection reset")}, false},
+		{"attempt_timed_out", context.DeadlineExceeded, false},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			if got := Throttled(test.err); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(tester *testing.T) {
+	now := time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name, header string
+		want         time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"date", "Mon, 31 Jan 2022 01:00:30 GMT", 30 * time.Second},
+		{"past_date", "Mon, 31 Jan 2022 00:59:00 GMT", 0},
+		{"garbage", "soon", 0},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			if got := ParseRetryAfter(test.header, now); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(tester *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "Throttling"}
+	slowDown := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 3 * time.Second, Err: fmt.Errorf("slow down")}
+	denied := &smithy.GenericAPIError{Code: "AccessDenied"}
+
+	cases := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+		wantWaits    []time.Duration
+	}{
+		{"succeeds", []error{nil}, 1, nil, nil},
+		{"throttled_then_succeeds", []error{throttled, throttled, nil}, 3, nil, nil},
+		{"terminal", []error{denied, nil}, 1, denied, nil},
+		{"gives_up", []error{throttled, throttled, throttled, throttled}, 3, throttled, nil},
+		{"retry_after", []error{slowDown, nil}, 2, nil, []time.Duration{3 * time.Second}},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			var waits []time.Duration
+			p := RetryPolicy{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+			p.sleep = func(ctx context.Context, d time.Duration) error {
+				if d < 0 || d > time.Second && d != slowDown.RetryAfter {
+					t.Errorf("wait of %v is out of bounds", d)
+				}
+				waits = append(waits, d)
+				return nil
+			}
+
+			calls := 0
+			attempts, err := p.Do(context.Background(), func(ctx context.Context) error {
+				calls++
+				return test.errs[calls-1]
+			})
+
+			if attempts != test.wantAttempts || calls != test.wantAttempts {
+				t.Errorf("want %v attempts, got %v (%v calls)", test.wantAttempts, attempts, calls)
+			}
+
+			if err != test.wantErr {
+				t.Errorf("want error %v, got %v", test.wantErr, err)
+			}
+
+			if test.wantWaits != nil && fmt.Sprint(waits) != fmt.Sprint(test.wantWaits) {
+				t.Errorf("want waits %v, got %v", test.wantWaits, waits)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsAtDeadline(tester *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	slowDown := &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour, Err: fmt.Errorf("unavailable")}
+
+	attempts, err := RetryPolicy{}.Do(ctx, func(ctx context.Context) error { return slowDown })
+	if attempts != 1 || err != slowDown {
+		tester.Errorf("want to give up after 1 attempt rather than wait past the deadline, got %v %v", attempts, err)
+	}
+}
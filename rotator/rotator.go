@@ -0,0 +1,602 @@
+// Package rotator rotates the access keys of an IAM user. It is the engine behind the iam-user-key-rotator CLI, and can
+// be embedded in other programs, such as a Lambda, by giving it an IAM client and the stores to save new keys to.
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"strings"
+	"time"
+)
+
+// IAMClient The IAM calls the rotator makes, *iam.Client implements it.
+type IAMClient interface {
+	ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error)
+	CreateAccessKey(ctx context.Context, params *iam.CreateAccessKeyInput, optFns ...func(*iam.Options)) (*iam.CreateAccessKeyOutput, error)
+	DeleteAccessKey(ctx context.Context, params *iam.DeleteAccessKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteAccessKeyOutput, error)
+}
+
+// Store Somewhere a new key is saved, such as a file or a CI context.
+type Store interface {
+	// Name A short name for the store, used in logs and results.
+	Name() string
+	// Save Save the new key, it holds the secret access key.
+	Save(ctx context.Context, key *types.AccessKey) error
+}
+
+// Checker A store that can check the key it saved works, such as by running a job with it. Check is called once after
+// Save, limited only by the context of the rotation, so a long check sets its own deadline and is not retried.
+type Checker interface {
+	Check(ctx context.Context, key *types.AccessKey) error
+}
+
+// Clock Tells the time, replace it to test key ages.
+type Clock interface {
+	Now() time.Time
+}
+
+// Logger Receives leveled log lines with key/value fields.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// Policy When keys are rotated and removed.
+type Policy struct {
+	// MaxDaysAllowed Keys older than this are rotated or removed.
+	MaxDaysAllowed int
+	// MaxKeysAllowed The most keys the user should have.
+	MaxKeysAllowed int
+	// WarnDays Flag the current key in the result when it is at least this many days old, 0 to disable.
+	WarnDays int
+}
+
+// Options Everything a Rotator needs.
+type Options struct {
+	// IAM The client used to list, make and delete keys, required.
+	IAM IAMClient
+	// CurrentKeyId The ID of the key in use, it is only deleted after a new key is saved. Required, unless UserName is
+	// set, then the newest active key of the user is taken to be the one in use.
+	CurrentKeyId string
+	// UserName The IAM user whose keys are rotated, empty for the user the IAM client is signed in as.
+	UserName string
+	// Stores Where a new key is saved, in order. When the first of them fails the new key is deleted again, once one has
+	// saved it the new key is kept, so no store is left holding a key that was deleted.
+	Stores []Store
+	// Clock Defaults to the system clock.
+	Clock Clock
+	// Logger Defaults to discarding all logs.
+	Logger Logger
+	// CallTimeout Longest each attempt at a call to IAM or a store may take, 0 for no limit.
+	CallTimeout time.Duration
+	// Retry When calls to IAM and stores that fail are tried again, zero fields take the defaults.
+	Retry RetryPolicy
+	// Locker Keeps rotations of the same user from overlapping, nil for no lock.
+	Locker Locker
+	// Tagger Records each rotation in tags of the IAM user, and reads them to find keys made outside the rotator. Nil
+	// to leave the tags alone.
+	Tagger TagClient
+	// Version The version of the program, recorded in the tags of the user.
+	Version string
+	// SSH The client used to list, upload and delete SSH public keys, required by RotateSSHKeys along with UserName.
+	SSH SSHClient
+	// SSHStores Where the private key of a new SSH key pair is saved, in order.
+	SSHStores []SSHStore
+	// SSHKeyType The type of SSH key pair RotateSSHKeys makes, SSHKeyRSA when empty.
+	SSHKeyType string
+	// Services The client used to list, make and delete service-specific credentials, required by
+	// RotateServiceCredential.
+	Services ServiceCredentialClient
+	// ResetServiceCredential Have RotateServiceCredential reset the password of the credential in use, instead of
+	// making a new credential.
+	ResetServiceCredential bool
+	Policy                 Policy
+}
+
+// Rotator Rotates the access keys of one IAM user. It runs one rotation at a time.
+type Rotator struct {
+	iam          IAMClient
+	currentKeyId string
+	userName     string
+	stores       []Store
+	clock        Clock
+	log          Logger
+	callTimeout  time.Duration
+	retry        RetryPolicy
+	locker       Locker
+	tagger       TagClient
+	version      string
+	policy       Policy
+	ssh          SSHClient
+	sshStores    []SSHStore
+	sshKeyType   string
+	services     ServiceCredentialClient
+	resetService bool
+	// attempts Calls made during the current stage, counting retries.
+	attempts int
+}
+
+// KeyInfo What was found out about a key.
+type KeyInfo struct {
+	AccessKeyId string
+	UserName    string
+	Status      types.StatusType
+	CreateDate  time.Time
+	Days        int
+	Expired     bool
+	// Unmanaged The key was made outside the rotator, only known once the rotator has tagged the user.
+	Unmanaged bool
+}
+
+// StageResult The outcome of a stage of the rotation.
+type StageResult struct {
+	Name string
+	Err  error
+	// Attempts The calls made to IAM or stores during the stage, counting each retry.
+	Attempts int
+}
+
+// Result What happened during a rotation.
+type Result struct {
+	// User The name of the IAM user, when it could be found.
+	User string
+	// CurrentKeyId The ID of the key in use when the rotation started.
+	CurrentKeyId string
+	// Keys The keys the user had when the rotation started.
+	Keys []KeyInfo
+	// Warn The current key is at least Policy.WarnDays old, but has not expired.
+	Warn bool
+	// CurrentKeyDays How many days old the current key is.
+	CurrentKeyDays int
+	// NewKey The key that was made, it holds the secret access key. Nil when no key was made.
+	NewKey *types.AccessKey
+	// NewSSHKey The SSH key pair that was made by RotateSSHKeys, it holds the private key. Nil when none was made.
+	NewSSHKey *SSHKey
+	// NewServiceCredential The service-specific credential made or reset by RotateServiceCredential, it holds the
+	// password. Nil when none was made.
+	NewServiceCredential *types.ServiceSpecificCredential
+	// Saved The names of the stores that saved the new key, in order.
+	Saved []string
+	// RolledBack The new key could not be saved to any store, so it was deleted.
+	RolledBack bool
+	// RollbackErr Why the new key could not be deleted after it could not be saved.
+	RollbackErr error
+	// Metadata What the tags of the user said about the last rotation, before this one. Nil when unknown.
+	Metadata *Metadata
+	// Stages Every stage that ran, in order. The last one holds the error when the rotation failed.
+	Stages []StageResult
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...interface{}) {}
+func (discardLogger) Info(string, ...interface{})  {}
+func (discardLogger) Warn(string, ...interface{})  {}
+func (discardLogger) Error(string, ...interface{}) {}
+
+// New Make a Rotator from options.
+func New(o Options) (*Rotator, error) {
+	if o.IAM == nil {
+		return nil, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.iamClientMissing))
+	}
+
+	if o.CurrentKeyId == "" && o.UserName == "" {
+		return nil, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.currentKeyMissing))
+	}
+
+	r := &Rotator{
+		iam:          o.IAM,
+		currentKeyId: o.CurrentKeyId,
+		userName:     o.UserName,
+		stores:       o.Stores,
+		clock:        o.Clock,
+		log:          o.Logger,
+		callTimeout:  o.CallTimeout,
+		retry:        o.Retry,
+		locker:       o.Locker,
+		tagger:       o.Tagger,
+		version:      o.Version,
+		policy:       o.Policy,
+		ssh:          o.SSH,
+		sshStores:    o.SSHStores,
+		sshKeyType:   o.SSHKeyType,
+		services:     o.Services,
+		resetService: o.ResetServiceCredential,
+	}
+
+	if r.clock == nil {
+		r.clock = systemClock{}
+	}
+
+	if r.log == nil {
+		r.log = discardLogger{}
+	}
+
+	return r, nil
+}
+
+// Rotated Indicates a new key was made and saved.
+func (r *Result) Rotated() bool {
+	return (r.NewKey != nil || r.NewSSHKey != nil || r.NewServiceCredential != nil) && !r.RolledBack
+}
+
+// MaskKeyId Show only the start and end of an access key ID, enough to tell keys apart.
+func MaskKeyId(id string) string {
+	if len(id) <= 8 {
+		return id
+	}
+
+	return id[:4] + strings.Repeat("*", len(id)-8) + id[len(id)-4:]
+}
+
+// stage Run a stage of the rotation, recording its outcome in the result. Once the context is done no more stages
+// start.
+func (r *Rotator) stage(ctx context.Context, res *Result, name string, fn func() error) error {
+	r.log.Debug("stage started", "stage", name)
+
+	r.attempts = 0
+
+	var err error
+	if ctx.Err() != nil {
+		err = fmt.Errorf(errMsgs.stopped, name, ctx.Err())
+	} else {
+		err = classify(fn())
+	}
+	res.Stages = append(res.Stages, StageResult{name, err, r.attempts})
+
+	if err != nil {
+		r.log.Error("stage failed", "stage", name, "error", err, "attempts", r.attempts)
+		return err
+	}
+
+	r.log.Debug("stage succeeded", "stage", name, "attempts", r.attempts)
+
+	return nil
+}
+
+// Rotate Remove keys that are too old or too many, then replace the current key when it has expired. When the context
+// is cancelled or times out, it stops before the next change to IAM. A new key that cannot be saved is still deleted.
+// The error returned is of a kind declared in error.go when it is known, such as ErrStorageFailed.
+func (r *Rotator) Rotate(ctx context.Context) (*Result, error) {
+	res := &Result{CurrentKeyId: r.currentKeyId}
+
+	return res, r.rotateCredential(ctx, res, accessKeys{r})
+}
+
+// accessKeys The access keys of the user, as rotated by Rotate.
+type accessKeys struct {
+	r *Rotator
+}
+
+func (k accessKeys) list(ctx context.Context) ([]types.AccessKeyMetadata, error) {
+	liko, err := k.r.iam.ListAccessKeys(ctx, &iam.ListAccessKeysInput{UserName: k.r.userNameInput()})
+	if err != nil {
+		return nil, err
+	}
+
+	return liko.AccessKeyMetadata, nil
+}
+
+func (k accessKeys) current(keys []types.AccessKeyMetadata) (string, error) {
+	if k.r.currentKeyId != "" {
+		return k.r.currentKeyId, nil
+	}
+
+	return newestActiveKey(k.r.userName, keys)
+}
+
+func (k accessKeys) deleter(string) keyDeleter {
+	return k.r.deleteKey
+}
+
+func (k accessKeys) create(ctx context.Context, res *Result, _ string, stats *iamStats) (string, error) {
+	k.r.log.Info(stdMsgs.noValidKeys, "user", res.User)
+
+	newKey, err := k.r.makeNewKey(ctx, stats)
+	if err != nil {
+		return "", err
+	}
+	res.NewKey = newKey.AccessKey
+
+	return aws.ToString(newKey.AccessKey.AccessKeyId), nil
+}
+
+func (k accessKeys) save(ctx context.Context, res *Result) error {
+	return k.r.save(ctx, res, res.NewKey)
+}
+
+func (k accessKeys) metadata(ctx context.Context, user string) *Metadata {
+	return k.r.readMetadata(ctx, user)
+}
+
+func (k accessKeys) record(ctx context.Context, res *Result) error {
+	return k.r.writeMetadata(ctx, aws.ToString(res.NewKey.UserName), res.NewKey)
+}
+
+// trimKeys Run the stages that delete, with del, the keys that are too old or too many, except the current key. The
+// keys deleted are removed from the stats.
+func (r *Rotator) trimKeys(ctx context.Context, res *Result, del keyDeleter, stats *iamStats) error {
+	currentId := stats.current
+
+	if err := r.stage(ctx, res, StageMakeRoom, func() error {
+		return r.makeRoomForKey(ctx, del, currentId, stats.old)
+	}); err != nil {
+		return err
+	}
+
+	// Forget the keys just deleted, so they are not deleted again.
+	for _, v := range append([]*iamKeyInfo{}, stats.old...) {
+		if aws.ToString(v.AccessKeyId) != currentId {
+			stats.removeKey(aws.ToString(v.AccessKeyId))
+		}
+	}
+
+	return r.stage(ctx, res, StageRemoveExcess, func() error {
+		return r.removeExcessKeys(ctx, del, stats, r.policy.MaxKeysAllowed, currentId)
+	})
+}
+
+// unlock Give up the lock on the user. It does not use the context of the run, so the lock is given up when the run is
+// cancelled or times out, a lock that cannot be given up expires with its lease.
+func (r *Rotator) unlock(user string) {
+	ctx, cancel := r.callContext(context.Background())
+	defer cancel()
+
+	if err := r.locker.Unlock(ctx, user); err != nil {
+		r.log.Warn(err.Error(), "user", user)
+	}
+}
+
+// keyUser Get the name of the user a key belongs to, empty when the key is not in the list.
+func keyUser(keys []types.AccessKeyMetadata, id string) string {
+	for _, k := range keys {
+		if aws.ToString(k.AccessKeyId) == id {
+			return aws.ToString(k.UserName)
+		}
+	}
+
+	return ""
+}
+
+// userNameInput The user name to give IAM calls, nil for the signed in user.
+func (r *Rotator) userNameInput() *string {
+	if r.userName == "" {
+		return nil
+	}
+
+	return aws.String(r.userName)
+}
+
+// newestActiveKey Get the ID of the newest active key, which is taken to be the one in use when rotating another user.
+func newestActiveKey(user string, keys []types.AccessKeyMetadata) (string, error) {
+	var newest *types.AccessKeyMetadata
+	for i, v := range keys {
+		if v.Status != types.StatusTypeActive {
+			continue
+		}
+
+		if newest == nil || aws.ToTime(v.CreateDate).After(aws.ToTime(newest.CreateDate)) {
+			newest = &keys[i]
+		}
+	}
+
+	if newest == nil {
+		return "", fmt.Errorf(errMsgs.noActiveKey, user)
+	}
+
+	return aws.ToString(newest.AccessKeyId), nil
+}
+
+// save Save the new key to every store, and check it with those that can, stopping at the first that fails. The
+// stores that saved it are recorded in the result, even when the check fails, as they now hold the new key.
+func (r *Rotator) save(ctx context.Context, res *Result, key *types.AccessKey) error {
+	for _, st := range r.stores {
+		if ctx.Err() != nil {
+			return withKind(ErrStorageFailed, fmt.Errorf(errMsgs.saveKeyErr, st.Name(), ctx.Err()))
+		}
+
+		r.log.Info(stdMsgs.saving, "target", st.Name())
+
+		if err := r.call(ctx, func(ctx context.Context) error { return st.Save(ctx, key) }); err != nil {
+			return withKind(ErrStorageFailed, fmt.Errorf(errMsgs.saveKeyErr, st.Name(), err))
+		}
+		res.Saved = append(res.Saved, st.Name())
+
+		c, ok := st.(Checker)
+		if !ok {
+			continue
+		}
+
+		if err := c.Check(ctx, key); err != nil {
+			return withKind(ErrStorageFailed, fmt.Errorf(errMsgs.checkKeyErr, st.Name(), err))
+		}
+	}
+
+	return nil
+}
+
+// callContext Get a context for a single call, limited by the call timeout.
+func (r *Rotator) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.callTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, r.callTimeout)
+}
+
+// call Make a call to IAM or a store, limiting each attempt to the call timeout and retrying when it fails with a
+// retryable error.
+func (r *Rotator) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.callWith(ctx, Retryable, fn)
+}
+
+// callCreate Make a call to IAM that creates a credential like call, but only retry it when it was throttled. Any other
+// failure may have created the credential all the same, so making it again could leave one behind.
+func (r *Rotator) callCreate(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.callWith(ctx, Throttled, fn)
+}
+
+// callWith Make a call like call, retrying only the errors retry accepts.
+func (r *Rotator) callWith(ctx context.Context, retry func(err error) bool, fn func(ctx context.Context) error) error {
+	n, err := r.retry.do(ctx, retry, func(ctx context.Context) error {
+		callCtx, cancel := r.callContext(ctx)
+		defer cancel()
+
+		return fn(callCtx)
+	})
+	r.attempts += n
+
+	if n > 1 {
+		r.log.Debug("retried call", "attempts", n, "error", err)
+	}
+
+	return err
+}
+
+// keyDeleter Deletes a key by its ID, such as deleteKey for access keys.
+type keyDeleter func(ctx context.Context, id *string) error
+
+// deleteKey Delete a key, unless the context is done.
+func (r *Rotator) deleteKey(ctx context.Context, id *string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf(errMsgs.deleteKeyErr, *id, ctx.Err())
+	}
+
+	daki := &iam.DeleteAccessKeyInput{AccessKeyId: id, UserName: r.userNameInput()}
+	if err := r.call(ctx, func(ctx context.Context) error {
+		_, err := r.iam.DeleteAccessKey(ctx, daki)
+		return err
+	}); err != nil {
+		return fmt.Errorf(errMsgs.deleteKeyErr, *id, err)
+	}
+	r.log.Info(stdMsgs.removedKey, "key_id", MaskKeyId(*id))
+
+	return nil
+}
+
+// deleteKeys Delete every key in the list with del.
+func (r *Rotator) deleteKeys(ctx context.Context, del keyDeleter, deleteKeys []*iamKeyInfo) error {
+	for _, v := range deleteKeys {
+		if err := del(ctx, v.AccessKeyId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makeRoomForKey Deletes all IAM keys in the delete key list except for the current access ID in use.
+func (r *Rotator) makeRoomForKey(ctx context.Context, del keyDeleter, currentId string, deleteKeys []*iamKeyInfo) error {
+	for _, v := range deleteKeys {
+		// delete all keys marked for deletion, except the one we are using.
+		if *v.AccessKeyId != currentId {
+			if err := del(ctx, v.AccessKeyId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// makeNewKey Add a new IAM key.
+func (r *Rotator) makeNewKey(ctx context.Context, stats *iamStats) (*iam.CreateAccessKeyOutput, error) {
+
+	// Skip if not expired.
+	if expired, err := stats.IsCurrentKeyExpired(); err != nil || !expired {
+		return nil, err
+	}
+
+	r.log.Info(stdMsgs.expireKey, "key_id", MaskKeyId(stats.current))
+
+	var newKey *iam.CreateAccessKeyOutput
+	err1 := r.callCreate(ctx, func(ctx context.Context) (err error) {
+		newKey, err = r.iam.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{UserName: r.userNameInput()})
+		return
+	})
+	if err1 != nil {
+		return nil, fmt.Errorf(errMsgs.probMakingNewKey, err1)
+	}
+
+	return newKey, nil
+}
+
+// reportStats Record the keys found in the result, and how old the current one is.
+func (r *Rotator) reportStats(res *Result, stats *iamStats) {
+	for _, v := range stats.keys {
+		res.Keys = append(res.Keys, KeyInfo{
+			AccessKeyId: aws.ToString(v.AccessKeyId),
+			UserName:    aws.ToString(v.UserName),
+			Status:      v.Status,
+			CreateDate:  aws.ToTime(v.CreateDate),
+			Days:        v.Days,
+			Expired:     v.Expired,
+			Unmanaged:   v.Unmanaged,
+		})
+	}
+
+	if ck := stats.findKey(stats.current); ck != nil {
+		if res.User == "" {
+			res.User = aws.ToString(ck.UserName)
+		}
+		res.CurrentKeyDays = ck.Days
+		res.Warn = r.policy.WarnDays > 0 && !ck.Expired && ck.Days >= r.policy.WarnDays
+	}
+}
+
+// displayIamStats Display info that allows the user to understand what is happening.
+func (r *Rotator) displayIamStats(stats *iamStats) {
+	for _, v := range stats.keys {
+		r.log.Info(
+			"key",
+			"key_id", MaskKeyId(aws.ToString(v.AccessKeyId)),
+			"status", v.Status,
+			"user", aws.ToString(v.UserName),
+			"days_old", v.Days,
+			"created", aws.ToTime(v.CreateDate).Format(time.RFC3339),
+		)
+
+		if v.Unmanaged {
+			r.log.Warn(stdMsgs.unmanagedKey, "key_id", MaskKeyId(aws.ToString(v.AccessKeyId)), "user", aws.ToString(v.UserName))
+		}
+	}
+
+	r.log.Info("keys", "total", len(stats.keys), "valid", len(stats.valid), "remove", len(stats.old))
+}
+
+func (r *Rotator) removeExcessKeys(ctx context.Context, del keyDeleter, stats *iamStats, maxKeysAllowed int, currentId string) error {
+	numKeys := len(stats.keys)
+
+	if numKeys <= maxKeysAllowed {
+		return nil
+	}
+	// delete keys that we are not using, until we get to the max allowed.
+	for _, v := range append([]iamKeyInfo{}, stats.keys...) {
+		if *v.AccessKeyId == currentId {
+			continue
+		}
+		if v.Expired || len(stats.keys) > maxKeysAllowed {
+			if err := del(ctx, v.AccessKeyId); err != nil {
+				return err
+			}
+			// Remove any reference to the deleted key.
+			stats.removeKey(*v.AccessKeyId)
+
+			numKeys--
+			if numKeys <= maxKeysAllowed {
+				break
+			}
+		}
+	}
+
+	return nil
+}
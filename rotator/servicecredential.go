@@ -0,0 +1,253 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"strings"
+	"time"
+)
+
+// Services that IAM makes service-specific credentials for.
+const (
+	ServiceCodeCommit = "codecommit.amazonaws.com"
+	ServiceKeyspaces  = "cassandra.amazonaws.com"
+)
+
+// ResetAtTagPrefix Followed by the ID of a service-specific credential, tags the user with when its password was last
+// reset. A reset keeps the date IAM made the credential, so its age is taken from this tag instead.
+const ResetAtTagPrefix = "iam-user-key-rotator:reset-at:"
+
+// ServiceCredentialClient The IAM calls made to rotate service-specific credentials, *iam.Client implements it.
+type ServiceCredentialClient interface {
+	ListServiceSpecificCredentials(ctx context.Context, params *iam.ListServiceSpecificCredentialsInput, optFns ...func(*iam.Options)) (*iam.ListServiceSpecificCredentialsOutput, error)
+	CreateServiceSpecificCredential(ctx context.Context, params *iam.CreateServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.CreateServiceSpecificCredentialOutput, error)
+	ResetServiceSpecificCredential(ctx context.Context, params *iam.ResetServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.ResetServiceSpecificCredentialOutput, error)
+	DeleteServiceSpecificCredential(ctx context.Context, params *iam.DeleteServiceSpecificCredentialInput, optFns ...func(*iam.Options)) (*iam.DeleteServiceSpecificCredentialOutput, error)
+}
+
+// RotateServiceCredential Rotate the service-specific credentials of the user for a service, such as
+// ServiceCodeCommit, with the same policy Rotate uses for access keys. The newest active credential is taken to be
+// the one in use. When it has expired a new credential is made and saved to the stores, with the service user name in
+// place of the access key ID and the password in place of the secret, then the old one is deleted. With
+// Options.ResetServiceCredential the password of the credential in use is reset instead, which needs no room for a
+// second credential, but the old password stops working at once and cannot be got back when saving fails. When it is
+// reset is recorded in the tags of the user, so a Tagger is needed to reset.
+func (r *Rotator) RotateServiceCredential(ctx context.Context, service string) (*Result, error) {
+	res := &Result{}
+
+	if r.services == nil {
+		return res, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.serviceClientMissing))
+	}
+
+	if r.resetService {
+		if r.tagger == nil {
+			return res, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.resetNeedsTagger))
+		}
+
+		return res, r.rotateCredential(ctx, res, resetServiceCredentials{serviceCredentials{r, service}})
+	}
+
+	return res, r.rotateCredential(ctx, res, serviceCredentials{r, service})
+}
+
+// serviceCredentials The service-specific credentials of the user for a service, as rotated by RotateServiceCredential.
+type serviceCredentials struct {
+	r       *Rotator
+	service string
+}
+
+func (k serviceCredentials) list(ctx context.Context) ([]types.AccessKeyMetadata, error) {
+	lssco, err := k.r.services.ListServiceSpecificCredentials(ctx, &iam.ListServiceSpecificCredentialsInput{
+		UserName:    k.r.userNameInput(),
+		ServiceName: aws.String(k.service),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceCredentialMetadata(lssco.ServiceSpecificCredentials), nil
+}
+
+func (k serviceCredentials) current(keys []types.AccessKeyMetadata) (string, error) {
+	id, err := newestActiveKey(k.r.userName, keys)
+	if err != nil {
+		return "", fmt.Errorf(errMsgs.noActiveServiceCredential, k.r.userName, k.service)
+	}
+
+	return id, nil
+}
+
+// deleter Service calls name the user even when it is the one signed in, so it is taken from the credentials.
+func (k serviceCredentials) deleter(user string) keyDeleter {
+	return k.r.serviceCredentialDeleter(user)
+}
+
+func (k serviceCredentials) create(ctx context.Context, res *Result, user string, stats *iamStats) (string, error) {
+	k.r.log.Info(stdMsgs.expireServiceCredential, "key_id", MaskKeyId(stats.current), "service", k.service)
+
+	cred, err := k.r.makeServiceCredential(ctx, user, k.service, stats.current)
+	if err != nil {
+		return "", err
+	}
+	res.NewServiceCredential = cred
+
+	return aws.ToString(cred.ServiceSpecificCredentialId), nil
+}
+
+// save Save the service user name in place of the access key ID and the password in place of the secret.
+func (k serviceCredentials) save(ctx context.Context, res *Result) error {
+	cred := res.NewServiceCredential
+
+	return k.r.save(ctx, res, &types.AccessKey{
+		AccessKeyId:     cred.ServiceUserName,
+		SecretAccessKey: cred.ServicePassword,
+		UserName:        cred.UserName,
+		CreateDate:      cred.CreateDate,
+		Status:          cred.Status,
+	})
+}
+
+func (k serviceCredentials) reset() bool {
+	return k.r.resetService
+}
+
+// resetServiceCredentials Service-specific credentials whose password is reset in place, aged from when they were last
+// reset.
+type resetServiceCredentials struct {
+	serviceCredentials
+}
+
+func (k resetServiceCredentials) list(ctx context.Context) ([]types.AccessKeyMetadata, error) {
+	keys, err := k.serviceCredentials.list(ctx)
+	if err != nil || len(keys) == 0 {
+		return keys, err
+	}
+
+	luto, err := k.r.tagger.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: keys[0].UserName})
+	if err != nil {
+		return nil, err
+	}
+
+	return useResetDates(keys, luto.Tags), nil
+}
+
+func (k resetServiceCredentials) metadata(context.Context, string) *Metadata {
+	return nil
+}
+
+// record Tag the user with when the password was reset, once it is saved. A reset that was not saved is not recorded,
+// so the next run resets it again.
+func (k resetServiceCredentials) record(ctx context.Context, res *Result) error {
+	cred := res.NewServiceCredential
+	tag := types.Tag{
+		Key:   aws.String(ResetAtTagPrefix + aws.ToString(cred.ServiceSpecificCredentialId)),
+		Value: aws.String(k.r.clock.Now().UTC().Format(time.RFC3339)),
+	}
+
+	return k.r.call(ctx, func(ctx context.Context) error {
+		_, err := k.r.tagger.TagUser(ctx, &iam.TagUserInput{UserName: cred.UserName, Tags: []types.Tag{tag}})
+		return err
+	})
+}
+
+// useResetDates Date each credential from when it was last reset, as the tags say, when that is after it was made.
+func useResetDates(keys []types.AccessKeyMetadata, tags []types.Tag) []types.AccessKeyMetadata {
+	resetAt := make(map[string]time.Time, len(tags))
+	for _, t := range tags {
+		if id := strings.TrimPrefix(aws.ToString(t.Key), ResetAtTagPrefix); id != aws.ToString(t.Key) {
+			if at, err := time.Parse(time.RFC3339, aws.ToString(t.Value)); err == nil {
+				resetAt[id] = at
+			}
+		}
+	}
+
+	for i, k := range keys {
+		if at, ok := resetAt[aws.ToString(k.AccessKeyId)]; ok && (k.CreateDate == nil || at.After(*k.CreateDate)) {
+			keys[i].CreateDate = aws.Time(at)
+		}
+	}
+
+	return keys
+}
+
+// serviceCredentialMetadata Describe service-specific credentials as access keys, so their age and number are judged
+// the same way.
+func serviceCredentialMetadata(creds []types.ServiceSpecificCredentialMetadata) []types.AccessKeyMetadata {
+	meta := make([]types.AccessKeyMetadata, 0, len(creds))
+	for _, c := range creds {
+		meta = append(meta, types.AccessKeyMetadata{
+			AccessKeyId: c.ServiceSpecificCredentialId,
+			CreateDate:  c.CreateDate,
+			Status:      c.Status,
+			UserName:    c.UserName,
+		})
+	}
+
+	return meta
+}
+
+// makeServiceCredential Make a new credential for the service, or reset the password of the current one when asked to.
+func (r *Rotator) makeServiceCredential(ctx context.Context, user, service, currentId string) (*types.ServiceSpecificCredential, error) {
+	var cred *types.ServiceSpecificCredential
+
+	// A reset only changes the password again when made twice, so it is retried like any other call.
+	var err error
+	if r.resetService {
+		err = r.call(ctx, func(ctx context.Context) error {
+			rssco, err := r.services.ResetServiceSpecificCredential(ctx, &iam.ResetServiceSpecificCredentialInput{
+				UserName:                    aws.String(user),
+				ServiceSpecificCredentialId: aws.String(currentId),
+			})
+			if err != nil {
+				return err
+			}
+			cred = rssco.ServiceSpecificCredential
+
+			return nil
+		})
+	} else {
+		err = r.callCreate(ctx, func(ctx context.Context) error {
+			cssco, err := r.services.CreateServiceSpecificCredential(ctx, &iam.CreateServiceSpecificCredentialInput{
+				UserName:    aws.String(user),
+				ServiceName: aws.String(service),
+			})
+			if err != nil {
+				return err
+			}
+			cred = cssco.ServiceSpecificCredential
+
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf(errMsgs.probMakingServiceCredential, service, err)
+	}
+
+	return cred, nil
+}
+
+// serviceCredentialDeleter Get a keyDeleter that deletes service-specific credentials of the user, unless the context
+// is done.
+func (r *Rotator) serviceCredentialDeleter(user string) keyDeleter {
+	return func(ctx context.Context, id *string) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf(errMsgs.deleteKeyErr, *id, ctx.Err())
+		}
+
+		if err := r.call(ctx, func(ctx context.Context) error {
+			_, err := r.services.DeleteServiceSpecificCredential(ctx, &iam.DeleteServiceSpecificCredentialInput{
+				UserName:                    aws.String(user),
+				ServiceSpecificCredentialId: id,
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf(errMsgs.deleteKeyErr, *id, err)
+		}
+		r.log.Info(stdMsgs.removedKey, "key_id", MaskKeyId(*id))
+
+		return nil
+	}
+}
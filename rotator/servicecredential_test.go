@@ -0,0 +1,221 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingStore Records the keys saved to it by ID and secret, failing when throw is set.
+type recordingStore struct {
+	saved map[string]string
+	throw bool
+}
+
+func (s *recordingStore) Name() string { return "recording" }
+
+func (s *recordingStore) Save(ctx context.Context, key *types.AccessKey) error {
+	if s.throw {
+		return fmt.Errorf("a test error occurred")
+	}
+	s.saved[aws.ToString(key.AccessKeyId)] = aws.ToString(key.SecretAccessKey)
+
+	return nil
+}
+
+func TestRotateServiceCredential(tester *testing.T) {
+	now := time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+	fresh := now.AddDate(0, 0, -5)
+	stale := now.AddDate(0, 0, -40)
+	user := "bob"
+
+	cases := []struct {
+		name        string
+		created     time.Time
+		reset       bool
+		storeThrow  bool
+		failNext    string
+		wantRotated bool
+		wantErr     error
+		wantCreds   []string
+		wantStages  []string
+	}{
+		{
+			"fresh",
+			fresh,
+			false, false, "", false, nil,
+			[]string{"ACCACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageDelete},
+		},
+		{
+			"expired",
+			stale,
+			false, false, "", true, nil,
+			[]string{"new"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageSave, StageDelete},
+		},
+		{
+			"reset",
+			stale,
+			true, false, "", true, nil,
+			[]string{"ACCACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageSave, StageTag},
+		},
+		{
+			"quotaReached",
+			stale,
+			false, false, "CreateServiceSpecificCredential", false, ErrQuotaReached,
+			[]string{"ACCACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate},
+		},
+		{
+			"saveFails",
+			stale,
+			false, true, "", false, ErrStorageFailed,
+			[]string{"ACCACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageSave},
+		},
+		{
+			// The password has changed in IAM, so the credential counts as rotated even though it was not saved.
+			"resetSaveFails",
+			stale,
+			true, true, "", true, ErrRollbackNeeded,
+			[]string{"ACCACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageSave},
+		},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			f.Now = func() time.Time { return now }
+			f.AddKey(user, "AKIABOB", now, types.StatusTypeActive)
+			// A credential for another service is left alone.
+			f.AddServiceCredential(user, ServiceKeyspaces, "ACCAKEYSPACES", stale, types.StatusTypeActive)
+			f.AddServiceCredential(user, ServiceCodeCommit, "ACCACUR", test.created, types.StatusTypeActive)
+			if test.failNext != "" {
+				f.FailNext(test.failNext, iamfake.APIError("LimitExceeded", "a test error occurred"))
+			}
+
+			store := &recordingStore{saved: map[string]string{}, throw: test.storeThrow}
+			r, _ := New(Options{
+				IAM:                    f,
+				CurrentKeyId:           "AKIABOB",
+				Services:               f,
+				ResetServiceCredential: test.reset,
+				Tagger:                 f,
+				Stores:                 []Store{store},
+				Clock:                  fixedClock{now},
+				Retry:                  RetryPolicy{MaxAttempts: 1},
+				Policy:                 Policy{MaxDaysAllowed: 30, MaxKeysAllowed: 2},
+			})
+
+			res, err := r.RotateServiceCredential(context.TODO(), ServiceCodeCommit)
+
+			if test.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("want a %v error, got %v", test.wantErr, err)
+			}
+
+			if res.Rotated() != test.wantRotated || res.User != user {
+				t.Errorf("want rotated %v for %v, got %+v", test.wantRotated, user, res)
+			}
+
+			got := make([]string, 0)
+			for _, c := range f.ServiceCredentials(user, ServiceCodeCommit) {
+				id := aws.ToString(c.ServiceSpecificCredentialId)
+				if !test.reset && res.NewServiceCredential != nil && id == aws.ToString(res.NewServiceCredential.ServiceSpecificCredentialId) {
+					id = "new"
+				}
+				got = append(got, id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.wantCreds) {
+				t.Errorf("want credentials %v left, got %v", test.wantCreds, got)
+			}
+
+			if len(f.ServiceCredentials(user, ServiceKeyspaces)) != 1 {
+				t.Error("want the credential for another service left alone")
+			}
+
+			if test.wantRotated && err == nil {
+				pw := aws.ToString(res.NewServiceCredential.ServicePassword)
+				if store.saved["bob-at-"+iamfake.AccountId] != pw || pw == "" {
+					t.Errorf("want the service user name and new password saved, got %v", store.saved)
+				}
+			}
+
+			stages := make([]string, 0, len(res.Stages))
+			for _, s := range res.Stages {
+				stages = append(stages, s.Name)
+			}
+			if strings.Join(stages, ",") != strings.Join(test.wantStages, ",") {
+				t.Errorf("want stages %v, got %v", test.wantStages, stages)
+			}
+		})
+	}
+}
+
+func TestRotateServiceCredentialNoneActive(tester *testing.T) {
+	f := iamfake.New()
+	f.AddServiceCredential("bob", ServiceCodeCommit, "ACCAOFF", time.Now(), types.StatusTypeInactive)
+
+	r, _ := New(Options{IAM: f, UserName: "bob", Services: f})
+	if _, err := r.RotateServiceCredential(context.TODO(), ServiceCodeCommit); err == nil || !strings.Contains(err.Error(), "no active credential") {
+		tester.Errorf("want an error when no credential is active, got %v", err)
+	}
+
+	r, _ = New(Options{IAM: f, UserName: "bob"})
+	if _, err := r.RotateServiceCredential(context.TODO(), ServiceCodeCommit); !errors.Is(err, ErrConfigInvalid) {
+		tester.Errorf("want a config error without a client, got %v", err)
+	}
+
+	r, _ = New(Options{IAM: f, UserName: "bob", Services: f, ResetServiceCredential: true})
+	if _, err := r.RotateServiceCredential(context.TODO(), ServiceCodeCommit); !errors.Is(err, ErrConfigInvalid) {
+		tester.Errorf("want a config error resetting without a tagger, got %v", err)
+	}
+}
+
+func TestRotateServiceCredentialResetTwice(tester *testing.T) {
+	now := time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+	f := iamfake.New()
+	f.Now = func() time.Time { return now }
+	f.AddKey("bob", "AKIABOB", now, types.StatusTypeActive)
+	f.AddServiceCredential("bob", ServiceCodeCommit, "ACCACUR", now.AddDate(0, 0, -40), types.StatusTypeActive)
+
+	// The second run is a day later, the credential was made 41 days ago but reset yesterday.
+	for i, wantRotated := range []bool{true, false} {
+		day := now.AddDate(0, 0, i)
+		r, _ := New(Options{
+			IAM:                    f,
+			UserName:               "bob",
+			Services:               f,
+			ResetServiceCredential: true,
+			Tagger:                 f,
+			Stores:                 []Store{&recordingStore{saved: map[string]string{}}},
+			Clock:                  fixedClock{day},
+			Policy:                 Policy{MaxDaysAllowed: 30, MaxKeysAllowed: 2},
+		})
+
+		res, err := r.RotateServiceCredential(context.TODO(), ServiceCodeCommit)
+		if err != nil {
+			tester.Fatalf("run %v: unexpected error: %v", i+1, err)
+		}
+
+		if res.Rotated() != wantRotated {
+			tester.Errorf("run %v: want rotated %v, got %+v", i+1, wantRotated, res)
+		}
+	}
+
+	if n := strings.Count(strings.Join(f.Calls(), ","), "ResetServiceSpecificCredential"); n != 1 {
+		tester.Errorf("want the password reset once, got %v resets", n)
+	}
+}
@@ -0,0 +1,320 @@
+package rotator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// Types of SSH key pair the rotator can make.
+const (
+	SSHKeyRSA     = "rsa"
+	SSHKeyED25519 = "ed25519"
+)
+
+// rsaKeyBits The size of the RSA keys made, CodeCommit needs at least 2048.
+const rsaKeyBits = 4096
+
+// SSHClient The IAM calls made to rotate the SSH public keys of a user, *iam.Client implements it.
+type SSHClient interface {
+	ListSSHPublicKeys(ctx context.Context, params *iam.ListSSHPublicKeysInput, optFns ...func(*iam.Options)) (*iam.ListSSHPublicKeysOutput, error)
+	UploadSSHPublicKey(ctx context.Context, params *iam.UploadSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UploadSSHPublicKeyOutput, error)
+	GetSSHPublicKey(ctx context.Context, params *iam.GetSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.GetSSHPublicKeyOutput, error)
+	UpdateSSHPublicKey(ctx context.Context, params *iam.UpdateSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.UpdateSSHPublicKeyOutput, error)
+	DeleteSSHPublicKey(ctx context.Context, params *iam.DeleteSSHPublicKeyInput, optFns ...func(*iam.Options)) (*iam.DeleteSSHPublicKeyOutput, error)
+}
+
+// SSHStore Somewhere the private key of a new SSH key pair is saved.
+type SSHStore interface {
+	// Name A short name for the store, used in logs and results.
+	Name() string
+	// SaveSSHKey Save the new key pair, it holds the private key.
+	SaveSSHKey(ctx context.Context, key *SSHKey) error
+}
+
+// SSHKey A key pair made by the rotator, whose public key was uploaded to IAM.
+type SSHKey struct {
+	// Id The ID IAM gave the public key, it is the user to connect to CodeCommit as.
+	Id       string
+	UserName string
+	// Fingerprint The MD5 fingerprint of the public key, as IAM shows it.
+	Fingerprint string
+	// PublicKey The public key in the authorized_keys format.
+	PublicKey string
+	// PrivateKey The private key, PEM encoded in the format ssh-keygen writes.
+	PrivateKey []byte
+}
+
+// RotateSSHKeys Rotate the SSH public keys of Options.UserName, such as those used for CodeCommit, with the same policy Rotate
+// uses for access keys. The newest active SSH key is taken to be the one in use. When it has expired a new key pair is
+// made and its public key uploaded, then the upload is checked before the private key is saved to the SSH stores and the
+// old key is deactivated and deleted. A new key that cannot be checked or saved is deleted again.
+func (r *Rotator) RotateSSHKeys(ctx context.Context) (*Result, error) {
+	res := &Result{}
+
+	if r.ssh == nil {
+		return res, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.sshClientMissing))
+	}
+
+	// SSH calls need a user name, unlike access key calls.
+	if r.userName == "" {
+		return res, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.sshUserMissing))
+	}
+
+	return res, r.rotateCredential(ctx, res, sshKeys{r})
+}
+
+// sshKeys The SSH public keys of the user, as rotated by RotateSSHKeys.
+type sshKeys struct {
+	r *Rotator
+}
+
+func (k sshKeys) list(ctx context.Context) ([]types.AccessKeyMetadata, error) {
+	lspko, err := k.r.ssh.ListSSHPublicKeys(ctx, &iam.ListSSHPublicKeysInput{UserName: aws.String(k.r.userName)})
+	if err != nil {
+		return nil, err
+	}
+
+	return sshKeyMetadata(lspko.SSHPublicKeys), nil
+}
+
+func (k sshKeys) current(keys []types.AccessKeyMetadata) (string, error) {
+	id, err := newestActiveKey(k.r.userName, keys)
+	if err != nil {
+		return "", fmt.Errorf(errMsgs.noActiveSSHKey, k.r.userName)
+	}
+
+	return id, nil
+}
+
+func (k sshKeys) deleter(user string) keyDeleter {
+	return k.r.sshKeyDeleter(user)
+}
+
+func (k sshKeys) create(ctx context.Context, res *Result, user string, stats *iamStats) (string, error) {
+	k.r.log.Info(stdMsgs.expireSSHKey, "key_id", MaskKeyId(stats.current))
+
+	newKey, err := k.r.uploadSSHKey(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	res.NewSSHKey = newKey
+
+	return newKey.Id, nil
+}
+
+func (k sshKeys) save(ctx context.Context, res *Result) error {
+	return k.r.saveSSHKey(ctx, res, res.NewSSHKey)
+}
+
+func (k sshKeys) verify(ctx context.Context, res *Result) error {
+	return k.r.verifySSHKey(ctx, res.NewSSHKey)
+}
+
+// sshKeyMetadata Describe SSH keys as access keys, so their age and number are judged the same way.
+func sshKeyMetadata(keys []types.SSHPublicKeyMetadata) []types.AccessKeyMetadata {
+	meta := make([]types.AccessKeyMetadata, 0, len(keys))
+	for _, k := range keys {
+		meta = append(meta, types.AccessKeyMetadata{
+			AccessKeyId: k.SSHPublicKeyId,
+			CreateDate:  k.UploadDate,
+			Status:      k.Status,
+			UserName:    k.UserName,
+		})
+	}
+
+	return meta
+}
+
+// uploadSSHKey Make a new key pair of the type chosen and upload its public key to IAM.
+func (r *Rotator) uploadSSHKey(ctx context.Context, user string) (*SSHKey, error) {
+	pub, private, err1 := newSSHKeyPair(r.sshKeyType, user)
+	if err1 != nil {
+		return nil, withKind(ErrConfigInvalid, err1)
+	}
+
+	body := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+
+	var uspko *iam.UploadSSHPublicKeyOutput
+	if err := r.callCreate(ctx, func(ctx context.Context) (err error) {
+		uspko, err = r.ssh.UploadSSHPublicKey(ctx, &iam.UploadSSHPublicKeyInput{
+			UserName:         aws.String(user),
+			SSHPublicKeyBody: aws.String(body),
+		})
+		return
+	}); err != nil {
+		return nil, fmt.Errorf(errMsgs.uploadSSHKeyErr, err)
+	}
+
+	return &SSHKey{
+		Id:          aws.ToString(uspko.SSHPublicKey.SSHPublicKeyId),
+		UserName:    user,
+		Fingerprint: aws.ToString(uspko.SSHPublicKey.Fingerprint),
+		PublicKey:   body,
+		PrivateKey:  private,
+	}, nil
+}
+
+// saveSSHKey Save the new key pair to every SSH store, stopping at the first that fails, and record the stores that
+// saved it in the result.
+func (r *Rotator) saveSSHKey(ctx context.Context, res *Result, key *SSHKey) error {
+	for _, st := range r.sshStores {
+		if ctx.Err() != nil {
+			return withKind(ErrStorageFailed, fmt.Errorf(errMsgs.saveKeyErr, st.Name(), ctx.Err()))
+		}
+
+		r.log.Info(stdMsgs.saving, "target", st.Name())
+
+		if err := r.call(ctx, func(ctx context.Context) error { return st.SaveSSHKey(ctx, key) }); err != nil {
+			return withKind(ErrStorageFailed, fmt.Errorf(errMsgs.saveKeyErr, st.Name(), err))
+		}
+		res.Saved = append(res.Saved, st.Name())
+	}
+
+	return nil
+}
+
+// verifySSHKey Check IAM has the public key that was made, and that it is active.
+func (r *Rotator) verifySSHKey(ctx context.Context, key *SSHKey) error {
+	var gspko *iam.GetSSHPublicKeyOutput
+	if err := r.call(ctx, func(ctx context.Context) (err error) {
+		gspko, err = r.ssh.GetSSHPublicKey(ctx, &iam.GetSSHPublicKeyInput{
+			UserName:       aws.String(key.UserName),
+			SSHPublicKeyId: aws.String(key.Id),
+			Encoding:       types.EncodingTypeSsh,
+		})
+		return
+	}); err != nil {
+		return err
+	}
+
+	want, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	got, _, _, _, err := ssh.ParseAuthorizedKey([]byte(aws.ToString(gspko.SSHPublicKey.SSHPublicKeyBody)))
+	if err != nil || !bytes.Equal(got.Marshal(), want.Marshal()) {
+		return withKind(ErrVerificationFailed, fmt.Errorf(errMsgs.sshKeyMismatch, key.Id))
+	}
+
+	if gspko.SSHPublicKey.Status != types.StatusTypeActive {
+		return withKind(ErrVerificationFailed, fmt.Errorf(errMsgs.sshKeyInactive, key.Id, gspko.SSHPublicKey.Status))
+	}
+
+	return nil
+}
+
+// sshKeyDeleter Get a keyDeleter that deactivates then deletes SSH keys of the user, unless the context is done.
+func (r *Rotator) sshKeyDeleter(user string) keyDeleter {
+	return func(ctx context.Context, id *string) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf(errMsgs.deleteKeyErr, *id, ctx.Err())
+		}
+
+		if err := r.call(ctx, func(ctx context.Context) error {
+			_, err := r.ssh.UpdateSSHPublicKey(ctx, &iam.UpdateSSHPublicKeyInput{
+				UserName:       aws.String(user),
+				SSHPublicKeyId: id,
+				Status:         types.StatusTypeInactive,
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf(errMsgs.deleteKeyErr, *id, err)
+		}
+
+		if err := r.call(ctx, func(ctx context.Context) error {
+			_, err := r.ssh.DeleteSSHPublicKey(ctx, &iam.DeleteSSHPublicKeyInput{UserName: aws.String(user), SSHPublicKeyId: id})
+			return err
+		}); err != nil {
+			return fmt.Errorf(errMsgs.deleteKeyErr, *id, err)
+		}
+		r.log.Info(stdMsgs.removedKey, "key_id", MaskKeyId(*id))
+
+		return nil
+	}
+}
+
+// newSSHKeyPair Make a key pair of the type given, returning the public key and the PEM encoded private key.
+func newSSHKeyPair(keyType, comment string) (ssh.PublicKey, []byte, error) {
+	switch keyType {
+	case "", SSHKeyRSA:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pub, err := ssh.NewPublicKey(&private.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pub, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}), nil
+	case SSHKeyED25519:
+		edPub, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pub, err := ssh.NewPublicKey(edPub)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		block, err := marshalED25519PrivateKey(pub, edPrivate, comment)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pub, pem.EncodeToMemory(block), nil
+	}
+
+	return nil, nil, fmt.Errorf(errMsgs.sshKeyTypeInvalid, keyType)
+}
+
+// marshalED25519PrivateKey Encode an ed25519 private key in the unencrypted openssh-key-v1 format, which is the only
+// one OpenSSH reads ed25519 keys in.
+func marshalED25519PrivateKey(pub ssh.PublicKey, key ed25519.PrivateKey, comment string) (*pem.Block, error) {
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+
+	private := struct {
+		Check1, Check2 uint32
+		KeyType        string
+		Pub, Priv      []byte
+		Comment        string
+	}{
+		binary.BigEndian.Uint32(check),
+		binary.BigEndian.Uint32(check),
+		ssh.KeyAlgoED25519,
+		key.Public().(ed25519.PublicKey),
+		key,
+		comment,
+	}
+
+	// The private section is padded with 1, 2, 3... to a multiple of the cipher block size, 8 for none.
+	block := ssh.Marshal(private)
+	for i := byte(1); len(block)%8 != 0; i++ {
+		block = append(block, i)
+	}
+
+	envelope := struct {
+		CipherName, KdfName, KdfOpts string
+		NumKeys                      uint32
+		PubKey, PrivKeyBlock         []byte
+	}{"none", "none", "", 1, pub.Marshal(), block}
+
+	return &pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), ssh.Marshal(envelope)...),
+	}, nil
+}
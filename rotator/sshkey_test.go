@@ -0,0 +1,249 @@
+package rotator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSSHStore Records the SSH keys saved to it, failing when throw is set.
+type mockSSHStore struct {
+	saved []*SSHKey
+	throw bool
+}
+
+func (s *mockSSHStore) Name() string { return "mock" }
+
+func (s *mockSSHStore) SaveSSHKey(ctx context.Context, key *SSHKey) error {
+	if s.throw {
+		return fmt.Errorf("a test error occurred")
+	}
+	s.saved = append(s.saved, key)
+
+	return nil
+}
+
+func TestRotateSSHKeys(tester *testing.T) {
+	now := time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+	fresh := now.AddDate(0, 0, -5)
+	stale := now.AddDate(0, 0, -40)
+	user := "bob"
+
+	cases := []struct {
+		name        string
+		keys        map[string]time.Time
+		storeThrow  bool
+		failNext    string
+		wantRotated bool
+		wantBack    bool
+		wantErr     error
+		wantKeys    []string
+		wantStages  []string
+	}{
+		{
+			"fresh",
+			map[string]time.Time{"APKAOLD": stale, "APKACUR": fresh},
+			false, "", false, false, nil,
+			[]string{"APKACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageDelete},
+		},
+		{
+			"expired",
+			map[string]time.Time{"APKACUR": stale},
+			false, "", true, false, nil,
+			[]string{"new"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageVerify, StageSave, StageDelete},
+		},
+		{
+			"save_fails",
+			map[string]time.Time{"APKACUR": stale},
+			true, "", false, true, ErrStorageFailed,
+			[]string{"APKACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageVerify, StageSave},
+		},
+		{
+			"verify_fails",
+			map[string]time.Time{"APKACUR": stale},
+			false, "GetSSHPublicKey", false, true, ErrAuthFailed,
+			[]string{"APKACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate, StageVerify},
+		},
+		{
+			"upload_fails",
+			map[string]time.Time{"APKACUR": stale},
+			false, "UploadSSHPublicKey", false, false, ErrAuthFailed,
+			[]string{"APKACUR"},
+			[]string{StageList, StageMakeRoom, StageRemoveExcess, StageCreate},
+		},
+		{
+			"no_keys",
+			nil,
+			false, "", false, false, nil,
+			nil,
+			[]string{StageList},
+		},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			f.Now = func() time.Time { return now }
+			f.AddUser(user)
+			for _, id := range []string{"APKAOLD", "APKACUR"} {
+				if at, ok := test.keys[id]; ok {
+					f.AddSSHKey(user, id, "ssh-rsa "+id, at, types.StatusTypeActive)
+				}
+			}
+			if test.failNext != "" {
+				f.FailNext(test.failNext, iamfake.APIError("AccessDenied", "a test error occurred"))
+			}
+
+			store := &mockSSHStore{throw: test.storeThrow}
+			r, _ := New(Options{
+				IAM:        f,
+				UserName:   user,
+				SSH:        f,
+				SSHStores:  []SSHStore{store},
+				SSHKeyType: SSHKeyED25519,
+				Clock:      fixedClock{now},
+				Retry:      RetryPolicy{MaxAttempts: 1},
+				Policy:     Policy{MaxDaysAllowed: 30, MaxKeysAllowed: 2},
+			})
+
+			res, err := r.RotateSSHKeys(context.TODO())
+
+			switch {
+			case test.keys == nil:
+				if err == nil || !strings.Contains(err.Error(), "no active SSH public key") {
+					t.Errorf("want an error for a user without SSH keys, got %v", err)
+				}
+			case test.wantErr == nil && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.wantErr != nil && !errors.Is(err, test.wantErr):
+				t.Errorf("want a %v error, got %v", test.wantErr, err)
+			}
+
+			if res.Rotated() != test.wantRotated || res.RolledBack != test.wantBack {
+				t.Errorf("want rotated %v, rolled back %v, got %+v", test.wantRotated, test.wantBack, res)
+			}
+
+			got := make([]string, 0)
+			for _, k := range f.SSHKeys(user) {
+				id := aws.ToString(k.SSHPublicKeyId)
+				if res.NewSSHKey != nil && id == res.NewSSHKey.Id {
+					id = "new"
+				}
+				got = append(got, id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.wantKeys) {
+				t.Errorf("want SSH keys %v left, got %v", test.wantKeys, got)
+			}
+
+			if test.wantRotated && (len(store.saved) != 1 || store.saved[0] != res.NewSSHKey) {
+				t.Errorf("want the new key saved, got %v", store.saved)
+			}
+
+			// A key that did not verify is never saved, so the key file still holds the old key.
+			if !test.wantRotated && len(store.saved) != 0 {
+				t.Errorf("want nothing saved, got %v", store.saved)
+			}
+
+			stages := make([]string, 0, len(res.Stages))
+			for _, s := range res.Stages {
+				stages = append(stages, s.Name)
+			}
+			if strings.Join(stages, ",") != strings.Join(test.wantStages, ",") {
+				t.Errorf("want stages %v, got %v", test.wantStages, stages)
+			}
+		})
+	}
+}
+
+func TestRotateSSHKeysDeactivatesFirst(tester *testing.T) {
+	now := time.Date(2022, 1, 31, 1, 0, 0, 0, time.UTC)
+	f := iamfake.New()
+	f.AddSSHKey("bob", "APKACUR", "ssh-rsa x", now.AddDate(0, 0, -40), types.StatusTypeActive)
+
+	r, _ := New(Options{IAM: f, UserName: "bob", SSH: f, Clock: fixedClock{now}, SSHKeyType: SSHKeyED25519})
+	if _, err := r.RotateSSHKeys(context.TODO()); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := strings.Join(f.Calls(), ",")
+	if !strings.HasSuffix(calls, "GetSSHPublicKey,UpdateSSHPublicKey,DeleteSSHPublicKey") {
+		tester.Errorf("want the old key deactivated then deleted once the new one is verified, got %v", calls)
+	}
+}
+
+func TestRotateSSHKeysConfigInvalid(tester *testing.T) {
+	f := iamfake.New()
+
+	cases := []struct {
+		name string
+		o    Options
+	}{
+		{"no_client", Options{IAM: f, UserName: "bob"}},
+		{"no_user", Options{IAM: f, CurrentKeyId: "AKIA", SSH: f}},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			r, _ := New(test.o)
+
+			if _, err := r.RotateSSHKeys(context.TODO()); !errors.Is(err, ErrConfigInvalid) {
+				t.Errorf("want a config error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewSSHKeyPair(tester *testing.T) {
+	cases := []struct {
+		name     string
+		keyType  string
+		wantType string
+		wantErr  bool
+	}{
+		{"rsa", SSHKeyRSA, ssh.KeyAlgoRSA, false},
+		{"ed25519", SSHKeyED25519, ssh.KeyAlgoED25519, false},
+		{"dsa", "dsa", "", true},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			pub, private, err := newSSHKeyPair(test.keyType, "bob")
+			if test.wantErr {
+				if err == nil {
+					t.Error("want an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if pub.Type() != test.wantType {
+				t.Errorf("want a %v key, got %v", test.wantType, pub.Type())
+			}
+
+			// The private key must be readable by OpenSSH and match the public key.
+			signer, err := ssh.ParsePrivateKey(private)
+			if err != nil {
+				t.Fatalf("could not parse the private key: %v", err)
+			}
+
+			if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+				t.Error("want the private key to match the public key")
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package rotator
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"time"
+)
+
+type iamStats struct {
+	current    string
+	keys       []iamKeyInfo
+	old, valid []*iamKeyInfo
+}
+
+type iamKeyInfo struct {
+	*types.AccessKeyMetadata
+	Days    int
+	Expired bool
+	// Unmanaged The key is not the one the tags of the user say was made by the last rotation.
+	Unmanaged bool
+}
+
+// RemoveKeyByIndex Removed IAM key from stats.
+func (is *iamStats) RemoveKeyByIndex(idx int) {
+	id := aws.ToString(is.keys[idx].AccessKeyId)
+	is.keys = append(is.keys[:idx], is.keys[idx+1:]...)
+
+	// Remove reference in old array, by ID since it points to a copy of the key.
+	for i, v := range is.old {
+		if aws.ToString(v.AccessKeyId) == id {
+			is.old = append(is.old[:i], is.old[i+1:]...)
+			break
+		}
+	}
+	// Remove reference in valid array.
+	for i, v := range is.valid {
+		if aws.ToString(v.AccessKeyId) == id {
+			is.valid = append(is.valid[:i], is.valid[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeKey Remove the key with the ID from stats, when it is there.
+func (is *iamStats) removeKey(id string) {
+	for i, v := range is.keys {
+		if aws.ToString(v.AccessKeyId) == id {
+			is.RemoveKeyByIndex(i)
+			return
+		}
+	}
+}
+
+// findKey Find a key by its ID, nil when there is no such key.
+func (is *iamStats) findKey(id string) *iamKeyInfo {
+	for i, v := range is.keys {
+		if aws.ToString(v.AccessKeyId) == id {
+			return &is.keys[i]
+		}
+	}
+
+	return nil
+}
+
+// IsCurrentKeyExpired Indicates the current key is older than the days allowed. The error is ErrConfigInvalid when
+// the current key is not one of the keys, such as when the credentials in use belong to another user.
+func (is *iamStats) IsCurrentKeyExpired() (bool, error) {
+	if ck := is.findKey(is.current); ck != nil {
+		return ck.Expired, nil
+	}
+
+	return false, withKind(ErrConfigInvalid, fmt.Errorf(errMsgs.currentKeyNotFound, MaskKeyId(is.current)))
+}
+
+// DaysOld Calculate the days passed since the date.
+func DaysOld(someDate *time.Time) int {
+	return daysOldAt(time.Now(), someDate)
+}
+
+// daysOldAt Calculate the days passed between the date and now.
+func daysOldAt(now time.Time, someDate *time.Time) int {
+	days := now.Sub(*someDate).Hours() / 24
+
+	return int(days)
+}
+
+func newIamStats(c string) *iamStats {
+	stats := &iamStats{
+		current: c,
+		keys:    make([]iamKeyInfo, 0),
+		old:     make([]*iamKeyInfo, 0),
+		valid:   make([]*iamKeyInfo, 0),
+	}
+	return stats
+}
+
+func getIamKeyStats(ak []types.AccessKeyMetadata, daysAllowed int, currentId, managedId string, now time.Time) *iamStats {
+	stats := newIamStats(currentId)
+
+	for i := range ak {
+		// Take the address of the element, not the loop variable, so each key keeps its own metadata.
+		v := ak[i]
+		daysOld := daysOldAt(now, v.CreateDate)
+		k := iamKeyInfo{
+			&v,
+			daysOld,
+			daysOld > daysAllowed,
+			managedId != "" && aws.ToString(v.AccessKeyId) != managedId,
+		}
+		stats.keys = append(stats.keys, k)
+
+		// When older than daysAllowed, then mark for deletion.
+		if daysOld > daysAllowed {
+			stats.old = append(stats.old, &k)
+			continue
+		}
+
+		// otherwise, its valid.
+		stats.valid = append(stats.valid, &k)
+	}
+
+	return stats
+}
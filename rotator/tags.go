@@ -0,0 +1,99 @@
+package rotator
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"strings"
+	"time"
+)
+
+// Tags of the IAM user that record the last rotation.
+const (
+	RotatedAtTag  = "iam-user-key-rotator:rotated-at"
+	ManagedKeyTag = "iam-user-key-rotator:managed-key"
+	TargetsTag    = "iam-user-key-rotator:targets"
+	VersionTag    = "iam-user-key-rotator:version"
+)
+
+// Metadata What the tags of the IAM user say about the last rotation.
+type Metadata struct {
+	// RotatedAt When the last new key was made and saved.
+	RotatedAt time.Time
+	// ManagedKey The ID of the key made by the last rotation, any other key of the user was made some other way.
+	ManagedKey string
+	// Targets The names of the stores the key was saved to.
+	Targets []string
+	// Version The version of the program that rotated the key.
+	Version string
+}
+
+// readMetadata Get what the tags of the user say about the last rotation, nil when the user has no such tags. A failure
+// to read them is only logged, as the tags are not needed to rotate.
+func (r *Rotator) readMetadata(ctx context.Context, user string) *Metadata {
+	if r.tagger == nil || user == "" {
+		return nil
+	}
+
+	var luto *iam.ListUserTagsOutput
+	if err := r.call(ctx, func(ctx context.Context) (err error) {
+		luto, err = r.tagger.ListUserTags(ctx, &iam.ListUserTagsInput{UserName: aws.String(user)})
+		return
+	}); err != nil {
+		r.log.Warn(stdMsgs.tagsUnread, "user", user, "error", err)
+		return nil
+	}
+
+	meta := &Metadata{}
+	for _, t := range luto.Tags {
+		v := aws.ToString(t.Value)
+		switch aws.ToString(t.Key) {
+		case RotatedAtTag:
+			meta.RotatedAt, _ = time.Parse(time.RFC3339, v)
+		case ManagedKeyTag:
+			meta.ManagedKey = v
+		case TargetsTag:
+			meta.Targets = strings.Fields(v)
+		case VersionTag:
+			meta.Version = v
+		}
+	}
+
+	if meta.ManagedKey == "" {
+		return nil
+	}
+
+	return meta
+}
+
+// writeMetadata Tag the user with the time of the rotation, the new key and where it was saved.
+func (r *Rotator) writeMetadata(ctx context.Context, user string, key *types.AccessKey) error {
+	targets := make([]string, 0, len(r.stores))
+	for _, st := range r.stores {
+		targets = append(targets, st.Name())
+	}
+
+	tags := []types.Tag{
+		{Key: aws.String(RotatedAtTag), Value: aws.String(r.clock.Now().UTC().Format(time.RFC3339))},
+		{Key: aws.String(ManagedKeyTag), Value: key.AccessKeyId},
+		{Key: aws.String(TargetsTag), Value: aws.String(strings.Join(targets, " "))},
+	}
+	if r.version != "" {
+		tags = append(tags, types.Tag{Key: aws.String(VersionTag), Value: aws.String(r.version)})
+	}
+
+	return r.call(ctx, func(ctx context.Context) error {
+		_, err := r.tagger.TagUser(ctx, &iam.TagUserInput{UserName: aws.String(user), Tags: tags})
+		return err
+	})
+}
+
+// managedKey The ID of the key the tags say was made by the last rotation, empty when unknown.
+func (m *Metadata) managedKey() string {
+	if m == nil {
+		return ""
+	}
+
+	return m.ManagedKey
+}
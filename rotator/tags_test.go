@@ -0,0 +1,86 @@
+package rotator
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/iamfake"
+	"testing"
+	"time"
+)
+
+func TestRotateTagsUser(tester *testing.T) {
+	cases := []struct {
+		name     string
+		tagFails bool
+	}{
+		{"tagged", false},
+		{"tag_fails", true},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			f := iamfake.New()
+			f.Now = func() time.Time { return lockNow }
+			f.Quota = 3
+			f.AddUser("bob", types.Tag{Key: aws.String(ManagedKeyTag), Value: aws.String("ABC123")})
+			f.AddKey("bob", "ABC123", lockNow.AddDate(0, 0, -40), types.StatusTypeActive)
+			f.AddKey("bob", "MANUAL1", lockNow.AddDate(0, 0, -2), types.StatusTypeActive)
+			if test.tagFails {
+				f.FailNext("TagUser", iamfake.APIError("AccessDenied", "not authorized to perform iam:TagUser"))
+			}
+
+			r, _ := New(Options{
+				IAM:          f,
+				CurrentKeyId: "ABC123",
+				Stores:       []Store{&mockStore{}},
+				Clock:        fixedClock{lockNow},
+				Retry:        RetryPolicy{MaxAttempts: 1},
+				Tagger:       f,
+				Version:      "1.2.3",
+				Policy:       Policy{MaxDaysAllowed: 30, MaxKeysAllowed: 3},
+			})
+
+			res, err := r.Rotate(context.TODO())
+			if err != nil || !res.Rotated() {
+				t.Fatalf("want a rotation, got %v", err)
+			}
+
+			if res.Metadata == nil || res.Metadata.ManagedKey != "ABC123" {
+				t.Errorf("want the managed key read from the tags, got %+v", res.Metadata)
+			}
+
+			for _, k := range res.Keys {
+				if k.Unmanaged != (k.AccessKeyId == "MANUAL1") {
+					t.Errorf("want only MANUAL1 unmanaged, got %+v", k)
+				}
+			}
+
+			last := res.Stages[len(res.Stages)-1]
+			if last.Name != StageTag || (last.Err != nil) != test.tagFails {
+				t.Errorf("want the tag stage last, failed %v, got %+v", test.tagFails, last)
+			}
+
+			want := map[string]string{
+				ManagedKeyTag: aws.ToString(res.NewKey.AccessKeyId),
+				RotatedAtTag:  lockNow.Format(time.RFC3339),
+				TargetsTag:    "mock",
+				VersionTag:    "1.2.3",
+			}
+			if test.tagFails {
+				want = map[string]string{ManagedKeyTag: "ABC123"}
+			}
+
+			got := map[string]string{}
+			for _, tag := range f.Tags("bob") {
+				got[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+
+			for k, v := range want {
+				if got[k] != v {
+					t.Errorf("want tag %v=%q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
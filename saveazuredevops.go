@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/url"
+	"strings"
+)
+
+// azurePatEnv The environment variable the Azure DevOps personal access token is read from when -azurePat is not given.
+const azurePatEnv = "AZURE_DEVOPS_EXT_PAT"
+
+// azureApiVersion The version of the Azure DevOps REST API the variable group calls are made with.
+const azureApiVersion = "7.1-preview.2"
+
+// azureGroupParams The fields of a variable group sent back when updating it, the API replaces the whole group.
+var azureGroupParams = []string{"name", "description", "type", "providerData", "variables", "variableGroupProjectReferences"}
+
+// azureDevOpsTarget A variable group in an Azure DevOps project, and the personal access token to update it with.
+type azureDevOpsTarget struct {
+	org,
+	project,
+	group,
+	pat string
+	vars *ciVars
+	hc   httpCommunicator
+}
+
+// azureVariable A variable of a variable group as sent to the API.
+type azureVariable struct {
+	Value    string `json:"value"`
+	IsSecret bool   `json:"isSecret"`
+}
+
+// newAzureDevOpsTarget Get the Azure DevOps target chosen by flags.
+func newAzureDevOpsTarget(ac *applicationFlags, hc httpCommunicator) *azureDevOpsTarget {
+	return &azureDevOpsTarget{
+		org:     strings.TrimRight(*ac.azureOrg, "/"),
+		project: *ac.azureProject,
+		group:   *ac.azureVariableGroup,
+		pat:     flagOrEnv(ac.azurePat, azurePatEnv),
+		vars:    ac.ciVars("azureVars"),
+		hc:      hc,
+	}
+}
+
+// saveToAzureDevOps Set the key and secret as secret variables in the variable group, keeping its other variables.
+func saveToAzureDevOps(ctx context.Context, creds *iam.CreateAccessKeyOutput, at *azureDevOpsTarget) error {
+	id, group, err1 := at.findGroup(ctx)
+	if err1 != nil {
+		return err1
+	}
+
+	// Other variables are sent back as they came, secret ones come without a value, which the API takes to mean
+	// keep the value it has.
+	vars := map[string]json.RawMessage{}
+	if raw, ok := group["variables"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &vars); err != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Azure DevOps", err)
+		}
+	}
+
+	newVars, err2 := at.vars.values(creds)
+	if err2 != nil {
+		return err2
+	}
+
+	for name, val := range newVars {
+		vars[name], _ = json.Marshal(&azureVariable{Value: val, IsSecret: true})
+	}
+
+	params := map[string]interface{}{}
+	for _, field := range azureGroupParams {
+		if raw, ok := group[field]; ok {
+			params[field] = raw
+		}
+	}
+	params["variables"] = vars
+
+	groupUrl := fmt.Sprintf("%v/_apis/distributedtask/variablegroups/%v?api-version=%v", at.org, id, azureApiVersion)
+	_, err3 := at.send(ctx, "PUT", groupUrl, params)
+
+	return err3
+}
+
+// findGroup Look up the variable group of the project by name, getting its ID and fields.
+func (at *azureDevOpsTarget) findGroup(ctx context.Context) (int64, map[string]json.RawMessage, error) {
+	listUrl := fmt.Sprintf(
+		"%v/%v/_apis/distributedtask/variablegroups?groupName=%v&api-version=%v",
+		at.org, url.PathEscape(at.project), url.QueryEscape(at.group), azureApiVersion,
+	)
+
+	body, err1 := at.send(ctx, "GET", listUrl, nil)
+	if err1 != nil {
+		return 0, nil, err1
+	}
+
+	list := &struct {
+		Value []map[string]json.RawMessage `json:"value"`
+	}{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return 0, nil, fmt.Errorf(errors.ciResponseInvalid, "Azure DevOps", err)
+	}
+
+	for _, group := range list.Value {
+		var name string
+		var id int64
+		_ = json.Unmarshal(group["name"], &name)
+		_ = json.Unmarshal(group["id"], &id)
+
+		if strings.EqualFold(name, at.group) && id != 0 {
+			return id, group, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf(errors.azureGroupNotFound, at.group, at.project)
+}
+
+// send Make a request to the API, authenticating with the personal access token.
+func (at *azureDevOpsTarget) send(ctx context.Context, method, reqUrl string, payload interface{}) ([]byte, error) {
+	return sendJSON(ctx, at.hc, "Azure DevOps", method, reqUrl, basicAuth("", at.pat), payload)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAzureDevOps Keeps one variable group, replacing it whole on every update like the real API.
+type fakeAzureDevOps struct {
+	group map[string]interface{}
+	sent  map[string]interface{}
+	pat   string
+}
+
+func (fa *fakeAzureDevOps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authorization") != basicAuth("", fa.pat) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/acme/My Project/_apis/distributedtask/variablegroups":
+		list := []interface{}{}
+		if strings.EqualFold(r.URL.Query().Get("groupName"), "release") {
+			list = append(list, fa.group)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"count": len(list), "value": list})
+	case r.Method == "PUT" && r.URL.Path == fmt.Sprintf("/acme/_apis/distributedtask/variablegroups/%v", fa.group["id"]):
+		fa.sent = map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&fa.sent)
+		_ = json.NewEncoder(w).Encode(fa.sent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSaveToAzureDevOps(tester *testing.T) {
+	cases := []struct {
+		name    string
+		group   string
+		pat     string
+		wantErr string
+	}{
+		{"updated", "Release", "p4t", ""},
+		{"groupMissing", "staging", "p4t", `no variable group "staging"`},
+		{"unauthorized", "Release", "wrong", "status 401"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fa := &fakeAzureDevOps{pat: "p4t", group: map[string]interface{}{
+				"id":           7,
+				"name":         "Release",
+				"type":         "Vsts",
+				"description":  "release creds",
+				"createdBy":    map[string]string{"displayName": "someone"},
+				"providerData": nil,
+				"variables": map[string]interface{}{
+					"DEPLOY_TOKEN": map[string]interface{}{"value": nil, "isSecret": true},
+					"REGION":       map[string]interface{}{"value": "us-east-1"},
+					keyVarName:     map[string]interface{}{"value": "AKIAOLD"},
+					secretVarName:  map[string]interface{}{"value": nil, "isSecret": true},
+				},
+				"variableGroupProjectReferences": []map[string]string{{"name": "Release"}},
+			}}
+			srv := httptest.NewServer(fa)
+			defer srv.Close()
+
+			at := &azureDevOpsTarget{org: srv.URL + "/acme", project: "My Project", group: test.group, pat: test.pat, hc: srv.Client()}
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToAzureDevOps(context.TODO(), creds, at)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Only the fields of the group that can be updated are sent back.
+			if _, ok := fa.sent["createdBy"]; ok || fa.sent["description"] != "release creds" || fa.sent["variableGroupProjectReferences"] == nil {
+				t.Errorf("want the group sent back as it was, got %v", fa.sent)
+			}
+
+			got, _ := json.Marshal(fa.sent["variables"])
+			want := `{"AWS_ACCESS_KEY_ID":{"isSecret":true,"value":"AKIANEW"},"AWS_SECRET_ACCESS_KEY":{"isSecret":true,"value":"new-secret"},"DEPLOY_TOKEN":{"isSecret":true,"value":null},"REGION":{"value":"us-east-1"}}`
+			if string(got) != want {
+				t.Errorf("want variables %v, got %v", want, string(got))
+			}
+		})
+	}
+}
+
+func TestNewAzureDevOpsTargetEnv(tester *testing.T) {
+	tester.Setenv(azurePatEnv, "env-pat")
+
+	empty, given := "", "flag-pat"
+	cases := []struct {
+		name string
+		pat  *string
+		want string
+	}{
+		{"fromEnv", &empty, "env-pat"},
+		{"fromFlag", &given, "flag-pat"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := *appFlags
+			ac.azurePat = test.pat
+
+			if at := newAzureDevOpsTarget(&ac, nil); at.pat != test.want {
+				t.Errorf("want %q, got %q", test.want, at.pat)
+			}
+		})
+	}
+}
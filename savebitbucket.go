@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/url"
+	"strings"
+)
+
+// The environment variables the Bitbucket app password and token are read from when their flags are not given.
+const (
+	bitbucketAppPasswordEnv = "BITBUCKET_APP_PASSWORD"
+	bitbucketTokenEnv       = "BITBUCKET_TOKEN"
+)
+
+// bitbucketApi The Bitbucket Cloud REST API, changed by tests to point at a fake.
+var bitbucketApi = "https://api.bitbucket.org/2.0"
+
+// bitbucketTarget Where to keep Bitbucket Pipelines variables and how to authenticate. Variables are kept in the
+// workspace when no repository is given, in the repository when no environment is given, else in the deployment
+// environment of the repository.
+type bitbucketTarget struct {
+	workspace,
+	repo,
+	environment,
+	user,
+	appPassword,
+	token string
+	vars *ciVars
+	hc   httpCommunicator
+}
+
+// bitbucketVariable A Pipelines variable as sent to and got from the API.
+type bitbucketVariable struct {
+	Uuid    string `json:"uuid,omitempty"`
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Secured bool   `json:"secured"`
+}
+
+// bitbucketPage One page of a paged list, Next is empty on the last page.
+type bitbucketPage struct {
+	Values json.RawMessage `json:"values"`
+	Next   string          `json:"next"`
+}
+
+// newBitbucketTarget Get the Bitbucket target chosen by flags.
+func newBitbucketTarget(ac *applicationFlags, hc httpCommunicator) *bitbucketTarget {
+	return &bitbucketTarget{
+		workspace:   *ac.bitbucketWorkspace,
+		repo:        *ac.bitbucketRepo,
+		environment: *ac.bitbucketEnvironment,
+		user:        *ac.bitbucketUser,
+		appPassword: flagOrEnv(ac.bitbucketAppPassword, bitbucketAppPasswordEnv),
+		token:       flagOrEnv(ac.bitbucketToken, bitbucketTokenEnv),
+		vars:        ac.ciVars("bitbucketVars"),
+		hc:          hc,
+	}
+}
+
+// saveToBitbucket Set the key and secret as secured Pipelines variables.
+func saveToBitbucket(ctx context.Context, creds *iam.CreateAccessKeyOutput, bt *bitbucketTarget) error {
+	varsUrl, err1 := bt.variablesUrl(ctx)
+	if err1 != nil {
+		return err1
+	}
+
+	vars, err2 := bt.vars.values(creds)
+	if err2 != nil {
+		return err2
+	}
+
+	for key, val := range vars {
+		if err := bt.setVariable(ctx, varsUrl, key, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// variablesUrl Get the URL of the variables of the workspace, repository or deployment environment.
+func (bt *bitbucketTarget) variablesUrl(ctx context.Context) (string, error) {
+	ws := url.PathEscape(bt.workspace)
+
+	if bt.repo == "" {
+		return bitbucketApi + "/workspaces/" + ws + "/pipelines-config/variables", nil
+	}
+
+	repoUrl := bitbucketApi + "/repositories/" + ws + "/" + url.PathEscape(bt.repo)
+
+	if bt.environment == "" {
+		return repoUrl + "/pipelines_config/variables", nil
+	}
+
+	envUuid, err := bt.environmentUuid(ctx, repoUrl)
+	if err != nil {
+		return "", err
+	}
+
+	return repoUrl + "/deployments_config/environments/" + url.PathEscape(envUuid) + "/variables", nil
+}
+
+// environmentUuid Look up the UUID of the deployment environment by name, a UUID given in braces is used as is.
+func (bt *bitbucketTarget) environmentUuid(ctx context.Context, repoUrl string) (string, error) {
+	if strings.HasPrefix(bt.environment, "{") {
+		return bt.environment, nil
+	}
+
+	var found string
+	err := bt.list(ctx, repoUrl+"/environments?pagelen=100", func(values json.RawMessage) (bool, error) {
+		var envs []struct {
+			Uuid string `json:"uuid"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(values, &envs); err != nil {
+			return false, err
+		}
+
+		for _, e := range envs {
+			if strings.EqualFold(e.Name, bt.environment) {
+				found = e.Uuid
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if found == "" {
+		return "", fmt.Errorf(errors.bitbucketEnvNotFound, bt.environment, bt.workspace, bt.repo)
+	}
+
+	return found, nil
+}
+
+// setVariable Update the variable with the key, looking up its UUID, or make it when there is none.
+func (bt *bitbucketTarget) setVariable(ctx context.Context, varsUrl, key, val string) error {
+	var uuid string
+	err := bt.list(ctx, varsUrl+"?pagelen=100", func(values json.RawMessage) (bool, error) {
+		var vars []bitbucketVariable
+		if err := json.Unmarshal(values, &vars); err != nil {
+			return false, err
+		}
+
+		for _, v := range vars {
+			if v.Key == key {
+				uuid = v.Uuid
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	variable := &bitbucketVariable{Key: key, Value: val, Secured: true}
+
+	if uuid == "" {
+		_, err = bt.send(ctx, "POST", varsUrl, variable)
+		return err
+	}
+
+	_, err = bt.send(ctx, "PUT", varsUrl+"/"+url.PathEscape(uuid), variable)
+
+	return err
+}
+
+// list Walk the pages of a list until found returns true or there are no more pages.
+func (bt *bitbucketTarget) list(ctx context.Context, pageUrl string, found func(values json.RawMessage) (bool, error)) error {
+	for pageUrl != "" {
+		body, err1 := bt.send(ctx, "GET", pageUrl, nil)
+		if err1 != nil {
+			return err1
+		}
+
+		page := &bitbucketPage{}
+		if err := json.Unmarshal(body, page); err != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Bitbucket", err)
+		}
+
+		done, err2 := found(page.Values)
+		if err2 != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Bitbucket", err2)
+		}
+
+		if done {
+			return nil
+		}
+
+		pageUrl = page.Next
+	}
+
+	return nil
+}
+
+// send Make a request to the API, authenticating with the OAuth token when there is one, else the app password.
+func (bt *bitbucketTarget) send(ctx context.Context, method, reqUrl string, payload interface{}) ([]byte, error) {
+	auth := basicAuth(bt.user, bt.appPassword)
+	if bt.token != "" {
+		auth = "Bearer " + bt.token
+	}
+
+	return sendJSON(ctx, bt.hc, "Bitbucket", method, reqUrl, auth, payload)
+}
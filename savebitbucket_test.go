@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// handlerHttpClient Answers requests with a handler, so a fake API can stand in for a real one.
+type handlerHttpClient struct {
+	h http.Handler
+}
+
+func (hhc *handlerHttpClient) Do(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	hhc.h.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// fakeBitbucket Keeps Pipelines variables by the path of their list, one variable per page to exercise paging.
+type fakeBitbucket struct {
+	vars  map[string][]bitbucketVariable
+	auth  string
+	calls []string
+	seq   int
+}
+
+func (fb *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fb.calls = append(fb.calls, r.Method+" "+r.URL.EscapedPath())
+
+	if r.Header.Get("authorization") != fb.auth {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/2.0")
+
+	if r.Method == "GET" && strings.HasSuffix(path, "/environments") {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"values": []map[string]string{{"uuid": "{env-1}", "name": "Production"}},
+		})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		list, ok := fb.vars[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		page := map[string]interface{}{"values": []bitbucketVariable{}}
+		i := 0
+		_, _ = fmt.Sscan(r.URL.Query().Get("page"), &i)
+		if i < len(list) {
+			page["values"] = list[i : i+1]
+		}
+		if i+1 < len(list) {
+			page["next"] = fmt.Sprintf("%v%v?page=%v", bitbucketApi, path, i+1)
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	case "POST":
+		v := bitbucketVariable{}
+		_ = json.NewDecoder(r.Body).Decode(&v)
+		fb.seq++
+		v.Uuid = fmt.Sprintf("{var-%v}", fb.seq)
+		fb.vars[path] = append(fb.vars[path], v)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT":
+		i := strings.LastIndex(path, "/")
+		list := fb.vars[path[:i]]
+		for n := range list {
+			if "/"+strings.NewReplacer("{", "%7B", "}", "%7D").Replace(list[n].Uuid) == path[i:] {
+				_ = json.NewDecoder(r.Body).Decode(&list[n])
+			}
+		}
+	}
+}
+
+func TestSaveToBitbucket(tester *testing.T) {
+	oldApi := bitbucketApi
+	bitbucketApi = "https://bitbucket.test/2.0"
+	defer func() { bitbucketApi = oldApi }()
+
+	repoVars := "/repositories/acme/app/pipelines_config/variables"
+	envVars := "/repositories/acme/app/deployments_config/environments/%7Benv-1%7D/variables"
+	wsVars := "/workspaces/acme/pipelines-config/variables"
+
+	cases := []struct {
+		name     string
+		target   bitbucketTarget
+		path     string
+		existed  []bitbucketVariable
+		wantVars int
+		wantErr  string
+	}{
+		{"workspace", bitbucketTarget{workspace: "acme", token: "t0k"}, wsVars, nil, 2, ""},
+		{"repoUpdate", bitbucketTarget{workspace: "acme", repo: "app", token: "t0k"}, repoVars, []bitbucketVariable{
+			{Uuid: "{old-1}", Key: "OTHER"},
+			{Uuid: "{old-2}", Key: secretVarName, Secured: true},
+			{Uuid: "{old-3}", Key: keyVarName},
+		}, 3, ""},
+		{"environment", bitbucketTarget{workspace: "acme", repo: "app", environment: "production", token: "t0k"}, envVars, nil, 2, ""},
+		{"environmentUuid", bitbucketTarget{workspace: "acme", repo: "app", environment: "{env-1}", token: "t0k"}, envVars, nil, 2, ""},
+		{"environmentMissing", bitbucketTarget{workspace: "acme", repo: "app", environment: "staging", token: "t0k"}, envVars, nil, 0, `no deployment environment "staging"`},
+		{"appPassword", bitbucketTarget{workspace: "acme", repo: "app", user: "bob", appPassword: "s3cret"}, repoVars, nil, 2, ""},
+		{"unauthorized", bitbucketTarget{workspace: "acme", repo: "app", token: "wrong"}, repoVars, nil, 0, "status 401"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fb := &fakeBitbucket{vars: map[string][]bitbucketVariable{test.path: test.existed}, auth: "Bearer t0k"}
+			if test.target.user != "" {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.SetBasicAuth(test.target.user, test.target.appPassword)
+				fb.auth = req.Header.Get("authorization")
+			}
+
+			bt := test.target
+			bt.hc = &handlerHttpClient{fb}
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToBitbucket(context.TODO(), creds, &bt)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v, calls %v", err, fb.calls)
+			}
+
+			got := map[string]bitbucketVariable{}
+			for _, v := range fb.vars[test.path] {
+				got[v.Key] = v
+			}
+
+			// Variables that exist are updated in place, not made again.
+			if len(fb.vars[test.path]) != test.wantVars {
+				t.Errorf("want existing variables updated in place, got %+v", fb.vars[test.path])
+			}
+
+			for key, val := range map[string]string{keyVarName: "AKIANEW", secretVarName: "new-secret"} {
+				if got[key].Value != val || !got[key].Secured {
+					t.Errorf("want %v set to %q and secured, got %+v", key, val, got[key])
+				}
+			}
+		})
+	}
+}
+
+func TestNewBitbucketTargetEnv(tester *testing.T) {
+	tester.Setenv(bitbucketTokenEnv, "env-token")
+	tester.Setenv(bitbucketAppPasswordEnv, "env-password")
+
+	empty, given, workspace := "", "flag-token", "acme"
+	cases := []struct {
+		name, token, want string
+	}{
+		{"fromEnv", empty, "env-token"},
+		{"fromFlag", given, "flag-token"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := *appFlags
+			ac.bitbucketWorkspace = &workspace
+			ac.bitbucketToken = &test.token
+			ac.bitbucketAppPassword = &empty
+
+			if err := ac.checkBitbucket(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			bt := newBitbucketTarget(&ac, nil)
+			if bt.token != test.want || bt.appPassword != "env-password" {
+				t.Errorf("want token %q and the password from the environment, got %q %q", test.want, bt.token, bt.appPassword)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/url"
+)
+
+// buildkiteTokenEnv The environment variable the Buildkite API token is read from when -buildkiteToken is not given.
+const buildkiteTokenEnv = "BUILDKITE_API_TOKEN"
+
+// buildkiteApi The Buildkite REST API, changed by tests to point at a fake.
+var buildkiteApi = "https://api.buildkite.com/v2"
+
+// buildkiteTarget Where to keep the key in a Buildkite organization, as secrets of a cluster and environment variables
+// of a pipeline, and the API token to update them with.
+type buildkiteTarget struct {
+	org,
+	cluster,
+	pipeline,
+	token string
+	vars *ciVars
+	hc   httpCommunicator
+}
+
+// buildkiteSecret A cluster secret as sent to and got from the API, the value is never got back.
+type buildkiteSecret struct {
+	Id    string `json:"id,omitempty"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// newBuildkiteTarget Get the Buildkite target chosen by flags.
+func newBuildkiteTarget(ac *applicationFlags, hc httpCommunicator) *buildkiteTarget {
+	return &buildkiteTarget{
+		org:      *ac.buildkiteOrg,
+		cluster:  *ac.buildkiteCluster,
+		pipeline: *ac.buildkitePipeline,
+		token:    flagOrEnv(ac.buildkiteToken, buildkiteTokenEnv),
+		vars:     ac.ciVars("buildkiteVars"),
+		hc:       hc,
+	}
+}
+
+// saveToBuildkite Set the key and secret as secrets of the cluster and in the environment of the pipeline, whichever
+// are given.
+func saveToBuildkite(ctx context.Context, creds *iam.CreateAccessKeyOutput, bt *buildkiteTarget) error {
+	vars, err1 := bt.vars.values(creds)
+	if err1 != nil {
+		return err1
+	}
+
+	orgUrl := buildkiteApi + "/organizations/" + url.PathEscape(bt.org)
+
+	if bt.cluster != "" {
+		if err := bt.setSecrets(ctx, orgUrl+"/clusters/"+url.PathEscape(bt.cluster)+"/secrets", vars); err != nil {
+			return err
+		}
+	}
+
+	if bt.pipeline != "" {
+		return bt.setPipelineEnv(ctx, orgUrl+"/pipelines/"+url.PathEscape(bt.pipeline), vars)
+	}
+
+	return nil
+}
+
+// setSecrets Update the value of the cluster secrets with the keys, making those that are not there.
+func (bt *buildkiteTarget) setSecrets(ctx context.Context, secretsUrl string, vars map[string]string) error {
+	body, err1 := bt.send(ctx, "GET", secretsUrl+"?per_page=100", nil)
+	if err1 != nil {
+		return err1
+	}
+
+	var secrets []buildkiteSecret
+	if err := json.Unmarshal(body, &secrets); err != nil {
+		return fmt.Errorf(errors.ciResponseInvalid, "Buildkite", err)
+	}
+
+	ids := map[string]string{}
+	for _, s := range secrets {
+		ids[s.Key] = s.Id
+	}
+
+	for key, val := range vars {
+		var err error
+		if id, ok := ids[key]; ok {
+			_, err = bt.send(ctx, "PUT", secretsUrl+"/"+url.PathEscape(id)+"/value", &buildkiteSecret{Key: key, Value: val})
+		} else {
+			_, err = bt.send(ctx, "POST", secretsUrl, &buildkiteSecret{Key: key, Value: val})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setPipelineEnv Set the variables in the environment of the pipeline, keeping its other variables.
+func (bt *buildkiteTarget) setPipelineEnv(ctx context.Context, pipelineUrl string, vars map[string]string) error {
+	body, err1 := bt.send(ctx, "GET", pipelineUrl, nil)
+	if err1 != nil {
+		return err1
+	}
+
+	pipeline := &struct {
+		Env map[string]interface{} `json:"env"`
+	}{}
+	if err := json.Unmarshal(body, pipeline); err != nil {
+		return fmt.Errorf(errors.ciResponseInvalid, "Buildkite", err)
+	}
+
+	if pipeline.Env == nil {
+		pipeline.Env = map[string]interface{}{}
+	}
+
+	for key, val := range vars {
+		pipeline.Env[key] = val
+	}
+
+	_, err2 := bt.send(ctx, "PATCH", pipelineUrl, pipeline)
+
+	return err2
+}
+
+// send Make a request to the API, authenticating with the API token.
+func (bt *buildkiteTarget) send(ctx context.Context, method, reqUrl string, payload interface{}) ([]byte, error) {
+	return sendJSON(ctx, bt.hc, "Buildkite", method, reqUrl, "Bearer "+bt.token, payload)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBuildkite Keeps the secrets of cluster c1 and the environment of pipeline app in the organization acme.
+type fakeBuildkite struct {
+	secrets []*buildkiteSecret
+	env     map[string]interface{}
+}
+
+func (fb *fakeBuildkite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authorization") != "Bearer t0k" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	secretsPath := "/v2/organizations/acme/clusters/c1/secrets"
+	pipelinePath := "/v2/organizations/acme/pipelines/app"
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == secretsPath:
+		// The API never gives back the value of a secret.
+		list := make([]*buildkiteSecret, 0, len(fb.secrets))
+		for _, s := range fb.secrets {
+			list = append(list, &buildkiteSecret{Id: s.Id, Key: s.Key})
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	case r.Method == "POST" && r.URL.Path == secretsPath:
+		s := &buildkiteSecret{}
+		_ = json.NewDecoder(r.Body).Decode(s)
+		s.Id = fmt.Sprintf("s%v", len(fb.secrets)+1)
+		fb.secrets = append(fb.secrets, s)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, secretsPath+"/") && strings.HasSuffix(r.URL.Path, "/value"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, secretsPath+"/"), "/value")
+		in := &buildkiteSecret{}
+		_ = json.NewDecoder(r.Body).Decode(in)
+		for _, s := range fb.secrets {
+			if s.Id == id {
+				s.Value = in.Value
+			}
+		}
+	case r.Method == "GET" && r.URL.Path == pipelinePath:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"slug": "app", "env": fb.env})
+	case r.Method == "PATCH" && r.URL.Path == pipelinePath:
+		in := &struct {
+			Env map[string]interface{} `json:"env"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(in)
+		fb.env = in.Env
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSaveToBuildkite(tester *testing.T) {
+	cases := []struct {
+		name        string
+		target      buildkiteTarget
+		wantSecrets map[string]string
+		wantEnv     map[string]interface{}
+		wantErr     string
+	}{
+		{
+			"cluster", buildkiteTarget{cluster: "c1", token: "t0k"},
+			map[string]string{keyVarName: "AKIANEW", secretVarName: "new-secret", "OTHER": "x"},
+			map[string]interface{}{"REGION": "us-east-1"},
+			"",
+		},
+		{
+			"pipeline", buildkiteTarget{pipeline: "app", token: "t0k"},
+			map[string]string{keyVarName: "AKIAOLD", "OTHER": "x"},
+			map[string]interface{}{"REGION": "us-east-1", keyVarName: "AKIANEW", secretVarName: "new-secret"},
+			"",
+		},
+		{"unauthorized", buildkiteTarget{cluster: "c1", token: "wrong"}, nil, nil, "status 401"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fb := &fakeBuildkite{
+				secrets: []*buildkiteSecret{{Id: "s1", Key: keyVarName, Value: "AKIAOLD"}, {Id: "s2", Key: "OTHER", Value: "x"}},
+				env:     map[string]interface{}{"REGION": "us-east-1"},
+			}
+			srv := httptest.NewServer(fb)
+			defer srv.Close()
+
+			oldApi := buildkiteApi
+			buildkiteApi = srv.URL + "/v2"
+			defer func() { buildkiteApi = oldApi }()
+
+			bt := test.target
+			bt.org, bt.hc = "acme", srv.Client()
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToBuildkite(context.TODO(), creds, &bt)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := map[string]string{}
+			for _, s := range fb.secrets {
+				got[s.Key] = s.Value
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(test.wantSecrets) {
+				t.Errorf("want secrets %v, got %v", test.wantSecrets, got)
+			}
+
+			if fmt.Sprint(fb.env) != fmt.Sprint(test.wantEnv) {
+				t.Errorf("want pipeline env %v, got %v", test.wantEnv, fb.env)
+			}
+		})
+	}
+}
+
+func TestBuildkiteDroneTravisTokensEnv(tester *testing.T) {
+	tester.Setenv(buildkiteTokenEnv, "bk-token")
+	tester.Setenv(droneTokenEnv, "drone-token")
+	tester.Setenv(travisTokenEnv, "travis-token")
+
+	empty, org, pipeline, server, repo := "", "acme", "app", "https://drone.test", "acme/app"
+	ac := *appFlags
+	ac.buildkiteOrg, ac.buildkitePipeline, ac.buildkiteToken = &org, &pipeline, &empty
+	ac.droneServer, ac.droneRepo, ac.droneToken = &server, &repo, &empty
+	ac.travisRepo, ac.travisToken = &repo, &empty
+
+	if err := ac.checkBuildkiteDroneTravis(); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	bt, dt, tt := newBuildkiteTarget(&ac, nil), newDroneTarget(&ac, nil), newTravisTarget(&ac, nil)
+	if bt.token != "bk-token" || dt.token != "drone-token" || tt.token != "travis-token" {
+		tester.Errorf("want the tokens from the environment, got %q %q %q", bt.token, dt.token, tt.token)
+	}
+}
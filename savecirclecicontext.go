@@ -1,47 +1,44 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
-	"io/ioutil"
 	"net/http"
-	"strings"
+	"net/url"
 )
 
+// circleciApi The Circle CI API, changed by tests to point at a fake.
+var circleciApi = "https://circleci.com/api/v2"
+
 type httpCommunicator interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func updateCircleCIContextVar(name, val, token string, client httpCommunicator) error {
-	url := "https://circleci.com/api/v2/context/%7Bcontext-id%7D/environment-variable/" + name
-
-	payload := strings.NewReader("{\"value\":\"" + val + "\"}")
-
-	req, _ := http.NewRequest("PUT", url, payload)
-
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("authorization", "Basic "+token)
-
-	res, _ := client.Do(req)
+// circleciContextVar The body of a request to set a variable of a Circle CI context.
+type circleciContextVar struct {
+	Value string `json:"value"`
+}
 
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
+// updateCircleCIContextVar Set a variable of the Circle CI context, making it when there is none.
+func updateCircleCIContextVar(ctx context.Context, contextId, name, val, token string, client httpCommunicator) error {
+	varUrl := circleciApi + "/context/" + url.PathEscape(contextId) + "/environment-variable/" + url.PathEscape(name)
 
-	if res.StatusCode != 200 {
-		return fmt.Errorf(errors.updateCiContextErr, string(body))
-	}
+	_, err := sendJSON(ctx, client, "Circle CI", http.MethodPut, varUrl, basicAuth(token, ""), &circleciContextVar{Value: val})
 
-	return nil
+	return err
 }
 
-// saveToCircleContext
-func saveToCircleContext(creds *iam.CreateAccessKeyOutput, cciToken string, hc httpCommunicator) error {
-	if err := updateCircleCIContextVar(keyVarName, *creds.AccessKey.AccessKeyId, cciToken, hc); err != nil {
-		return err
+// saveToCircleContext Save the variables to the Circle CI context.
+func saveToCircleContext(ctx context.Context, creds *iam.CreateAccessKeyOutput, contextId, cciToken string, vars *ciVars, hc httpCommunicator) error {
+	values, err1 := vars.values(creds)
+	if err1 != nil {
+		return err1
 	}
 
-	if err := updateCircleCIContextVar(secretVarName, *creds.AccessKey.SecretAccessKey, cciToken, hc); err != nil {
-		return err
+	for name, val := range values {
+		if err := updateCircleCIContextVar(ctx, contextId, name, val, cciToken, hc); err != nil {
+			return err
+		}
 	}
 
 	return nil
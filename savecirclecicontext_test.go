@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockHttpClient struct {
@@ -27,13 +36,13 @@ func TestUpdateCircleCIContextVar(tester *testing.T) {
 		want   error
 		client httpCommunicator
 	}{
-		{"updateFails", fmt.Errorf(errors.updateCiContextErr, "err"), &mockHttpClient{1}},
+		{"updateFails", fmt.Errorf(errors.ciResponseErr, "Circle CI", "PUT", 400, "err"), &mockHttpClient{1}},
 		{"updateSucceeds", nil, &mockHttpClient{0}},
 	}
 
 	for _, test := range cases {
 		tester.Run(test.name, func(t *testing.T) {
-			got := updateCircleCIContextVar("", "", "", test.client)
+			got := updateCircleCIContextVar(context.TODO(), "", "", "", "", test.client)
 			// Had to extract the error messages a compare them.
 			// Handle nil case separately
 			if (got != nil && got.Error() != test.want.Error()) || (got == nil && got != test.want) {
@@ -43,3 +52,96 @@ func TestUpdateCircleCIContextVar(tester *testing.T) {
 		})
 	}
 }
+
+func TestSaveToCircleContext(tester *testing.T) {
+	got := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("authorization") != basicAuth("t0k", "") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/v2/context/ctx-id/environment-variable/")
+		if r.Method != "PUT" || name == r.URL.Path {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		v := &circleciContextVar{}
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got[name] = v.Value
+	}))
+	defer srv.Close()
+
+	oldApi := circleciApi
+	circleciApi = srv.URL + "/v2"
+	defer func() { circleciApi = oldApi }()
+
+	// A secret with quotes and a backslash must reach the API as it is.
+	creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+		AccessKeyId:     aws.String("AKIANEW"),
+		SecretAccessKey: aws.String(`new"se\cret`),
+	}}
+
+	if err := saveToCircleContext(context.TODO(), creds, "ctx-id", "t0k", mustCiVars(""), srv.Client()); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{keyVarName: "AKIANEW", secretVarName: `new"se\cret`}
+	if !reflect.DeepEqual(got, want) {
+		tester.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestUpdateCircleCIContextVarTimeout(tester *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// A client that hangs until the request is given up on, like an endpoint that never answers.
+	hang := &hangingHttpClient{}
+
+	err := updateCircleCIContextVar(ctx, "ctx-id", keyVarName, "ABC123", "token", hang)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		tester.Errorf("want a deadline exceeded error, got %v", err)
+	}
+}
+
+type hangingHttpClient struct{}
+
+func (hhc *hangingHttpClient) Do(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+
+	return nil, req.Context().Err()
+}
+
+func TestUpdateCircleCIContextVarThrottled(tester *testing.T) {
+	client := &throttledHttpClient{}
+
+	err := updateCircleCIContextVar(context.TODO(), "ctx-id", keyVarName, "ABC123", "token", client)
+
+	se, ok := err.(*rotator.StatusError)
+	if !ok {
+		tester.Fatalf("want a *rotator.StatusError, got %T %v", err, err)
+	}
+
+	if se.StatusCode != http.StatusTooManyRequests || se.RetryAfter != 3*time.Second {
+		tester.Errorf("want status 429 retry after 3s, got %v %v", se.StatusCode, se.RetryAfter)
+	}
+
+	if !rotator.Retryable(err) {
+		tester.Errorf("want a 429 to be retryable")
+	}
+}
+
+type throttledHttpClient struct{}
+
+func (thc *throttledHttpClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"3"}},
+		Body:       ioutil.NopCloser(strings.NewReader("slow down")),
+	}, nil
+}
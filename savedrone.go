@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/url"
+	"strings"
+)
+
+// droneTokenEnv The environment variable the Drone token is read from when -droneToken is not given.
+const droneTokenEnv = "DRONE_TOKEN"
+
+// droneTarget A repository, as owner/name, on a Drone server to keep the key in as secrets, and the token to update
+// them with.
+type droneTarget struct {
+	server,
+	repo,
+	token string
+	vars *ciVars
+	hc   httpCommunicator
+}
+
+// droneSecret A repository secret as sent to the API.
+type droneSecret struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+// newDroneTarget Get the Drone target chosen by flags.
+func newDroneTarget(ac *applicationFlags, hc httpCommunicator) *droneTarget {
+	return &droneTarget{
+		server: strings.TrimRight(*ac.droneServer, "/"),
+		repo:   *ac.droneRepo,
+		token:  flagOrEnv(ac.droneToken, droneTokenEnv),
+		vars:   ac.ciVars("droneVars"),
+		hc:     hc,
+	}
+}
+
+// saveToDrone Set the key and secret as secrets of the repository, making those that are not there. Secrets are not
+// given to builds of pull requests, which is the default.
+func saveToDrone(ctx context.Context, creds *iam.CreateAccessKeyOutput, dt *droneTarget) error {
+	vars, err1 := dt.vars.values(creds)
+	if err1 != nil {
+		return err1
+	}
+
+	parts := strings.SplitN(dt.repo, "/", 2)
+	secretsUrl := dt.server + "/api/repos/" + url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1]) + "/secrets"
+
+	for name, val := range vars {
+		secret := &droneSecret{Name: name, Data: val}
+
+		_, err := dt.send(ctx, "PATCH", secretsUrl+"/"+url.PathEscape(name), secret)
+		if isNotFound(err) {
+			_, err = dt.send(ctx, "POST", secretsUrl, secret)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send Make a request to the API, authenticating with the token.
+func (dt *droneTarget) send(ctx context.Context, method, reqUrl string, payload interface{}) ([]byte, error) {
+	return sendJSON(ctx, dt.hc, "Drone", method, reqUrl, "Bearer "+dt.token, payload)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDrone Keeps the secrets of the repo acme/app by name.
+type fakeDrone struct {
+	secrets map[string]string
+}
+
+func (fd *fakeDrone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authorization") != "Bearer t0k" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	secret := &droneSecret{}
+	_ = json.NewDecoder(r.Body).Decode(secret)
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/repos/acme/app/secrets":
+		fd.secrets[secret.Name] = secret.Data
+	case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/api/repos/acme/app/secrets/"):
+		name := strings.TrimPrefix(r.URL.Path, "/api/repos/acme/app/secrets/")
+		if _, ok := fd.secrets[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fd.secrets[name] = secret.Data
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSaveToDrone(tester *testing.T) {
+	cases := []struct {
+		name    string
+		repo    string
+		vars    string
+		token   string
+		want    map[string]string
+		wantErr string
+	}{
+		{"defaultNames", "acme/app", "", "t0k", map[string]string{keyVarName: "AKIANEW", secretVarName: "new-secret", "OTHER": "x"}, ""},
+		{"customNames", "acme/app", "DEPLOY_KEY_ID=key,DEPLOY_SECRET=secret", "t0k", map[string]string{keyVarName: "AKIAOLD", "DEPLOY_KEY_ID": "AKIANEW", "DEPLOY_SECRET": "new-secret", "OTHER": "x"}, ""},
+		{"repoMissing", "acme/web", "", "t0k", nil, "status 404"},
+		{"unauthorized", "acme/app", "", "wrong", nil, "status 401"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fd := &fakeDrone{secrets: map[string]string{keyVarName: "AKIAOLD", "OTHER": "x"}}
+			srv := httptest.NewServer(fd)
+			defer srv.Close()
+
+			dt := &droneTarget{server: srv.URL, repo: test.repo, token: test.token, vars: mustCiVars(test.vars), hc: srv.Client()}
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToDrone(context.TODO(), creds, dt)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(fd.secrets) != len(test.want) {
+				t.Errorf("want secrets %v, got %v", test.want, fd.secrets)
+			}
+
+			for name, val := range test.want {
+				if fd.secrets[name] != val {
+					t.Errorf("want secret %v = %q, got %q", name, val, fd.secrets[name])
+				}
+			}
+		})
+	}
+}
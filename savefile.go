@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// keyFileMode Only the owner may read or write the key file.
+const keyFileMode = 0600
+
+// fileFormats All formats the key file can be written in.
+var fileFormats = []string{"json", "csv", "shell", "powershell", "dotenv", "credential_process"}
+
+// saveToFile Save the new key to a local file in the format given, encrypted to the age recipients when any are given.
+// The SES SMTP passwords are added when smtp is not nil.
+func saveToFile(newKey *iam.CreateAccessKeyOutput, filename, format, ageRecipients string, smtp *sesSmtp) error {
+	nk := newKeyPair(newKey.AccessKey, smtp)
+
+	content, err1 := formatKeyPair(nk, format)
+	if err1 != nil {
+		return err1
+	}
+
+	if ageRecipients != "" {
+		encrypted, err := encryptForRecipients(content, ageRecipients)
+		if err != nil {
+			return err
+		}
+		content = encrypted
+	}
+
+	if err := writeFileAtomic(filename, content, keyFileMode); err != nil {
+		return fmt.Errorf(errors.writingNewKeyErr, err.Error())
+	}
+
+	return nil
+}
+
+// formatKeyPair Render the key pair in one of the fileFormats.
+func formatKeyPair(nk awsKeyPair, format string) ([]byte, error) {
+	switch format {
+	case "", "json":
+		content, err := json.Marshal(nk)
+		if err != nil {
+			return nil, fmt.Errorf(errors.translateKeyToJsonErr, err.Error())
+		}
+		return content, nil
+	case "csv":
+		buf := &bytes.Buffer{}
+		w := csv.NewWriter(buf)
+		if nk.SMTPUsername == "" {
+			// Same layout as the file the AWS console offers to download when making an access key.
+			_ = w.Write([]string{"Access key ID", "Secret access key"})
+			_ = w.Write([]string{nk.Id, nk.Key})
+		} else {
+			var names, values []string
+			for _, v := range nk.envVars() {
+				names, values = append(names, v.name), append(values, v.value)
+			}
+			_ = w.Write(names)
+			_ = w.Write(values)
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	case "shell":
+		b := &strings.Builder{}
+		for _, v := range nk.envVars() {
+			fmt.Fprintf(b, "export %s=%s\n", v.name, shellQuote(v.value))
+		}
+		return []byte(b.String()), nil
+	case "powershell":
+		b := &strings.Builder{}
+		for _, v := range nk.envVars() {
+			fmt.Fprintf(b, "$Env:%s = %s\r\n", v.name, powershellQuote(v.value))
+		}
+		return []byte(b.String()), nil
+	case "dotenv":
+		b := &strings.Builder{}
+		for _, v := range nk.envVars() {
+			fmt.Fprintf(b, "%s=%s\n", v.name, v.value)
+		}
+		return []byte(b.String()), nil
+	case "credential_process":
+		content, err := json.Marshal(credentialProcessOutput{Version: 1, AccessKeyId: nk.Id, SecretAccessKey: nk.Key})
+		if err != nil {
+			return nil, fmt.Errorf(errors.translateKeyToJsonErr, err.Error())
+		}
+		return content, nil
+	default:
+		return nil, fmt.Errorf(errors.fileFormatInvalid, format, strings.Join(fileFormats, ", "))
+	}
+}
+
+// shellQuote Quote a value for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powershellQuote Quote a value for PowerShell.
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// removeKeyFile Delete the local key file.
+func removeKeyFile(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf(errors.removingKeyFileErr, err.Error())
+	}
+
+	appLog.Info(stdMsgs.removedKeyFile, "file", filename)
+
+	return nil
+}
+
+// encryptForRecipients Encrypt content to a comma separated list of age recipients, ASCII armored.
+func encryptForRecipients(content []byte, ageRecipients string) ([]byte, error) {
+	recipients, err1 := age.ParseRecipients(strings.NewReader(strings.ReplaceAll(ageRecipients, ",", "\n")))
+	if err1 != nil {
+		return nil, fmt.Errorf(errors.ageRecipientInvalid, err1.Error())
+	}
+
+	buf := &bytes.Buffer{}
+	aw := armor.NewWriter(buf)
+
+	w, err2 := age.Encrypt(aw, recipients...)
+	if err2 != nil {
+		return nil, fmt.Errorf(errors.encryptKeyErr, err2.Error())
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return nil, fmt.Errorf(errors.encryptKeyErr, err.Error())
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf(errors.encryptKeyErr, err.Error())
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, fmt.Errorf(errors.encryptKeyErr, err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readKeyFile Read the key pair back from a key file written in the json or credential_process format, decrypting it
+// with the identity file when it was encrypted.
+func readKeyFile(filename, identityFile string) (*awsKeyPair, error) {
+	content, err1 := ioutil.ReadFile(filename)
+	if err1 != nil {
+		return nil, fmt.Errorf(errors.readingKeyFileErr, err1.Error())
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)) {
+		buf := &bytes.Buffer{}
+		if err := decryptBackup(filename, identityFile, buf); err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+	}
+
+	cpo := credentialProcessOutput{}
+	if err := json.Unmarshal(content, &cpo); err == nil && cpo.Version == 1 {
+		return &awsKeyPair{Id: cpo.AccessKeyId, Key: cpo.SecretAccessKey}, nil
+	}
+
+	nk := &awsKeyPair{}
+	if err := json.Unmarshal(content, nk); err != nil {
+		return nil, fmt.Errorf(errors.readingKeyFileErr, err.Error())
+	}
+
+	if nk.Id == "" || nk.Key == "" {
+		return nil, fmt.Errorf(errors.readingKeyFileErr, "no access key found")
+	}
+
+	return nk, nil
+}
+
+// decryptBackup Decrypt a key file written with -ageRecipient, using the identities in the identity file.
+func decryptBackup(filename, identityFile string, out io.Writer) error {
+	if identityFile == "" {
+		return withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.ageIdentityMissing))
+	}
+
+	idf, err1 := os.Open(identityFile)
+	if err1 != nil {
+		return fmt.Errorf(errors.ageIdentityInvalid, err1.Error())
+	}
+	defer idf.Close()
+
+	identities, err2 := age.ParseIdentities(idf)
+	if err2 != nil {
+		return fmt.Errorf(errors.ageIdentityInvalid, err2.Error())
+	}
+
+	content, err3 := ioutil.ReadFile(filename)
+	if err3 != nil {
+		return fmt.Errorf(errors.decryptKeyErr, err3.Error())
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)) {
+		return fmt.Errorf(errors.keyFileNotEncrypted, filename)
+	}
+
+	r, err4 := age.Decrypt(armor.NewReader(bytes.NewReader(content)), identities...)
+	if err4 != nil {
+		return fmt.Errorf(errors.decryptKeyErr, err4.Error())
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf(errors.decryptKeyErr, err.Error())
+	}
+
+	return nil
+}
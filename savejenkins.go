@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	stderrors "errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// jenkinsTokenEnv The environment variable the Jenkins API token is read from when -jenkinsToken is not given.
+const jenkinsTokenEnv = "JENKINS_API_TOKEN"
+
+// jenkinsKinds The credentials plugin classes of the kinds of credential the key can be saved as.
+var jenkinsKinds = map[string]string{
+	"aws":              "com.cloudbees.jenkins.plugins.awscredentials.AWSCredentialsImpl",
+	"usernamePassword": "com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl",
+}
+
+// jenkinsTarget A credential in a Jenkins credentials store, the store of a folder when one is given, and the user and
+// API token to update it with.
+type jenkinsTarget struct {
+	url,
+	user,
+	token,
+	folder,
+	domain,
+	id,
+	kind string
+	hc   httpCommunicator
+	smtp *sesSmtp
+}
+
+// jenkinsCredential The XML of an AWS or username/password credential, as read from and sent to config.xml.
+type jenkinsCredential struct {
+	XMLName     xml.Name
+	Scope       string `xml:"scope"`
+	Id          string `xml:"id"`
+	Description string `xml:"description"`
+	AccessKey   string `xml:"accessKey,omitempty"`
+	SecretKey   string `xml:"secretKey,omitempty"`
+	IamRoleArn  string `xml:"iamRoleArn,omitempty"`
+	MfaSerial   string `xml:"iamMfaSerialNumber,omitempty"`
+	Username    string `xml:"username,omitempty"`
+	Password    string `xml:"password,omitempty"`
+}
+
+// jenkinsCrumb Sent with every POST when Jenkins has CSRF protection on, along with the session cookie it was issued
+// in.
+type jenkinsCrumb struct {
+	field,
+	value,
+	cookie string
+}
+
+// newJenkinsTarget Get the Jenkins target chosen by flags.
+func newJenkinsTarget(ac *applicationFlags, hc httpCommunicator) *jenkinsTarget {
+	return &jenkinsTarget{
+		url:    strings.TrimRight(*ac.jenkinsUrl, "/"),
+		user:   *ac.jenkinsUser,
+		token:  flagOrEnv(ac.jenkinsToken, jenkinsTokenEnv),
+		folder: strings.Trim(*ac.jenkinsFolder, "/"),
+		domain: *ac.jenkinsDomain,
+		id:     *ac.jenkinsCredentialId,
+		kind:   *ac.jenkinsCredentialKind,
+		hc:     hc,
+		smtp:   ac.sesSmtp(),
+	}
+}
+
+// saveToJenkins Update the credential with the new key, or make it when there is none. The scope, description and role
+// of a credential that exists are kept.
+func saveToJenkins(ctx context.Context, creds *iam.CreateAccessKeyOutput, jt *jenkinsTarget) error {
+	crumb, err1 := jt.crumb(ctx)
+	if err1 != nil {
+		return err1
+	}
+
+	cred := &jenkinsCredential{
+		XMLName:     xml.Name{Local: jenkinsKinds[jt.kind]},
+		Scope:       "GLOBAL",
+		Id:          jt.id,
+		Description: stdMsgs.jenkinsDescription,
+	}
+
+	credUrl := jt.domainUrl() + "/credential/" + url.PathEscape(jt.id) + "/config.xml"
+	postUrl := credUrl
+
+	_, body, err2 := jt.send(ctx, "GET", credUrl, nil, nil)
+	switch {
+	case isNotFound(err2):
+		postUrl = jt.domainUrl() + "/createCredentials"
+	case err2 != nil:
+		return err2
+	default:
+		old := &jenkinsCredential{}
+		if err := xml.Unmarshal(body, old); err != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Jenkins", err)
+		}
+		cred.Scope, cred.Description = old.Scope, old.Description
+		cred.IamRoleArn, cred.MfaSerial = old.IamRoleArn, old.MfaSerial
+	}
+
+	if jt.kind == "usernamePassword" {
+		cred.Username, cred.Password = *creds.AccessKey.AccessKeyId, *creds.AccessKey.SecretAccessKey
+		// With -sesSmtpOnly the credential holds what signs in to SES SMTP, the password of the first region.
+		if jt.smtp != nil && jt.smtp.only {
+			cred.Password = sesSmtpPassword(cred.Password, jt.smtp.regions[0])
+		}
+	} else {
+		cred.AccessKey, cred.SecretKey = *creds.AccessKey.AccessKeyId, *creds.AccessKey.SecretAccessKey
+	}
+
+	content, err3 := xml.Marshal(cred)
+	if err3 != nil {
+		return err3
+	}
+
+	_, _, err4 := jt.send(ctx, "POST", postUrl, content, crumb)
+
+	return err4
+}
+
+// domainUrl Get the URL of the credentials domain, in the store of the folder when there is one, else the system store.
+func (jt *jenkinsTarget) domainUrl() string {
+	base, store := jt.url, "system"
+
+	if jt.folder != "" {
+		for _, name := range strings.Split(jt.folder, "/") {
+			base += "/job/" + url.PathEscape(name)
+		}
+		store = "folder"
+	}
+
+	return base + "/credentials/store/" + store + "/domain/" + url.PathEscape(jt.domain)
+}
+
+// crumb Get a crumb to send with POSTs, nil when Jenkins has CSRF protection off.
+func (jt *jenkinsTarget) crumb(ctx context.Context) (*jenkinsCrumb, error) {
+	res, body, err1 := jt.send(ctx, "GET", jt.url+"/crumbIssuer/api/json", nil, nil)
+	if isNotFound(err1) {
+		return nil, nil
+	}
+
+	if err1 != nil {
+		return nil, err1
+	}
+
+	issued := &struct {
+		Crumb             string `json:"crumb"`
+		CrumbRequestField string `json:"crumbRequestField"`
+	}{}
+	if err := json.Unmarshal(body, issued); err != nil {
+		return nil, fmt.Errorf(errors.ciResponseInvalid, "Jenkins", err)
+	}
+
+	// The crumb is only good in the session it was issued in.
+	cookies := make([]string, 0, len(res.Cookies()))
+	for _, c := range res.Cookies() {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+
+	return &jenkinsCrumb{issued.CrumbRequestField, issued.Crumb, strings.Join(cookies, "; ")}, nil
+}
+
+// send Make a request to Jenkins with the user and API token, sending the crumb when given.
+func (jt *jenkinsTarget) send(ctx context.Context, method, reqUrl string, content []byte, crumb *jenkinsCrumb) (*http.Response, []byte, error) {
+	req, err1 := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(content))
+	if err1 != nil {
+		return nil, nil, err1
+	}
+
+	req.SetBasicAuth(jt.user, jt.token)
+	if content != nil {
+		req.Header.Add("content-type", "application/xml")
+	}
+
+	if crumb != nil {
+		req.Header.Add(crumb.field, crumb.value)
+		if crumb.cookie != "" {
+			req.Header.Add("cookie", crumb.cookie)
+		}
+	}
+
+	return doRequest(jt.hc, "Jenkins", req)
+}
+
+// isNotFound Indicates the API responded that there is no such thing.
+func isNotFound(err error) bool {
+	var se *rotator.StatusError
+
+	return stderrors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
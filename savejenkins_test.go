@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeJenkins Keeps the config.xml of credentials by the URL path of their domain and ID, rejecting POSTs without the
+// crumb when crumbs are on.
+type fakeJenkins struct {
+	creds  map[string]string
+	crumbs bool
+	token  string
+}
+
+func (fj *fakeJenkins) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, token, _ := r.BasicAuth(); user != "bob" || token != fj.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/crumbIssuer/api/json" {
+		if !fj.crumbs {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "s1"})
+		_, _ = w.Write([]byte(`{"crumb":"c1","crumbRequestField":"Jenkins-Crumb"}`))
+		return
+	}
+
+	if r.Method == "POST" && fj.crumbs {
+		if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != "s1" || r.Header.Get("Jenkins-Crumb") != "c1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/config.xml"):
+		content, ok := fj.creds[strings.TrimSuffix(r.URL.Path, "/config.xml")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/config.xml"):
+		path := strings.TrimSuffix(r.URL.Path, "/config.xml")
+		if _, ok := fj.creds[path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fj.creds[path] = string(body)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/createCredentials"):
+		cred := &jenkinsCredential{}
+		_ = xml.Unmarshal(body, cred)
+		fj.creds[strings.TrimSuffix(r.URL.Path, "/createCredentials")+"/credential/"+cred.Id] = string(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSaveToJenkins(tester *testing.T) {
+	systemCred := "/credentials/store/system/domain/_/credential/aws-deploy"
+	folderCred := "/job/team/job/app/credentials/store/folder/domain/prod/credential/aws-deploy"
+
+	cases := []struct {
+		name     string
+		target   jenkinsTarget
+		crumbs   bool
+		existing map[string]string
+		path     string
+		want     string
+		wantErr  string
+	}{
+		{
+			"createAws", jenkinsTarget{domain: "_", kind: "aws", token: "t0k"}, true, nil, systemCred,
+			`<com.cloudbees.jenkins.plugins.awscredentials.AWSCredentialsImpl><scope>GLOBAL</scope><id>aws-deploy</id><description>` + stdMsgs.jenkinsDescription + `</description><accessKey>AKIANEW</accessKey><secretKey>new-secret</secretKey></com.cloudbees.jenkins.plugins.awscredentials.AWSCredentialsImpl>`,
+			"",
+		},
+		{
+			"updateFolder", jenkinsTarget{folder: "team/app", domain: "prod", kind: "usernamePassword", token: "t0k"}, false,
+			map[string]string{folderCred: `<com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl><scope>SYSTEM</scope><id>aws-deploy</id><description>deploy key</description><username>AKIAOLD</username><password>old</password></com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl>`},
+			folderCred,
+			`<com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl><scope>SYSTEM</scope><id>aws-deploy</id><description>deploy key</description><username>AKIANEW</username><password>new-secret</password></com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl>`,
+			"",
+		},
+		{"unauthorized", jenkinsTarget{domain: "_", kind: "aws", token: "wrong"}, true, nil, systemCred, "", "status 401"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			fj := &fakeJenkins{creds: map[string]string{}, crumbs: test.crumbs, token: "t0k"}
+			for path, content := range test.existing {
+				fj.creds[path] = content
+			}
+			srv := httptest.NewServer(fj)
+			defer srv.Close()
+
+			jt := test.target
+			jt.url, jt.user, jt.id, jt.hc = srv.URL, "bob", "aws-deploy", srv.Client()
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToJenkins(context.TODO(), creds, &jt)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := fj.creds[test.path]; got != test.want {
+				t.Errorf("want credential\n%v\ngot\n%v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestNewJenkinsTargetEnv(tester *testing.T) {
+	tester.Setenv(jenkinsTokenEnv, "env-token")
+
+	empty, given := "", "flag-token"
+	cases := []struct {
+		name  string
+		token *string
+		want  string
+	}{
+		{"fromEnv", &empty, "env-token"},
+		{"fromFlag", &given, "flag-token"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := *appFlags
+			ac.jenkinsToken = test.token
+
+			if jt := newJenkinsTarget(&ac, nil); jt.token != test.want {
+				t.Errorf("want %q, got %q", test.want, jt.token)
+			}
+		})
+	}
+}
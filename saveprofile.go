@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"os"
@@ -8,14 +9,14 @@ import (
 )
 
 // saveToLocalProfile Save the credentials to a local config file using the aws cli.
-func saveToLocalProfile(creds *iam.CreateAccessKeyOutput) error {
+func saveToLocalProfile(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
 	awsProfile := os.Getenv("AWS_PROFILE")
-	err1 := runCmd("aws", "configure", "set", "aws_access_key_id", *creds.AccessKey.AccessKeyId, "--profile", awsProfile)
+	err1 := runCmd(ctx, "aws", "configure", "set", "aws_access_key_id", *creds.AccessKey.AccessKeyId, "--profile", awsProfile)
 	if err1 != nil {
 		return err1
 	}
 
-	err2 := runCmd("aws", "configure", "set", "aws_secret_access_key", *creds.AccessKey.SecretAccessKey, "--profile", awsProfile)
+	err2 := runCmd(ctx, "aws", "configure", "set", "aws_secret_access_key", *creds.AccessKey.SecretAccessKey, "--profile", awsProfile)
 	if err2 != nil {
 		return err2
 	}
@@ -23,9 +24,9 @@ func saveToLocalProfile(creds *iam.CreateAccessKeyOutput) error {
 	return nil
 }
 
-// runCmd run a command
-func runCmd(program string, args ...string) error {
-	cmd := exec.Command(program, args...)
+// runCmd run a command, killing it when the context is done.
+func runCmd(ctx context.Context, program string, args ...string) error {
+	cmd := exec.CommandContext(ctx, program, args...)
 	cmdOut, cmdErr := cmd.CombinedOutput()
 	if cmdErr != nil {
 		return cmdErr
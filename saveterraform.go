@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tfcTokenEnv The environment variable the Terraform Cloud API token is read from when -tfcToken is not given.
+const tfcTokenEnv = "TFE_TOKEN"
+
+// tfcPollInterval How often to check on a plan while waiting for it to finish, changed by tests.
+var tfcPollInterval = 5 * time.Second
+
+// tfcRunDone The states a plan-only run ends in, and whether the plan worked.
+var tfcRunDone = map[string]bool{
+	"planned_and_finished": true,
+	"errored":              false,
+	"canceled":             false,
+	"force_canceled":       false,
+	"discarded":            false,
+}
+
+// terraformTarget Workspaces and variable sets of a Terraform Cloud or Enterprise organization to keep the key in as
+// sensitive environment variables, and the API token to update them with.
+type terraformTarget struct {
+	host,
+	org,
+	token string
+	workspaces,
+	varSets []string
+	plan        bool
+	planTimeout time.Duration
+	vars        *ciVars
+	hc          httpCommunicator
+}
+
+// tfcResource A resource as sent to and got from the JSON:API of Terraform Cloud.
+type tfcResource struct {
+	Id            string                 `json:"id,omitempty"`
+	Type          string                 `json:"type"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Relationships map[string]interface{} `json:"relationships,omitempty"`
+}
+
+// tfcDocument A response of the JSON:API, data is one resource or a list of them.
+type tfcDocument struct {
+	Data  json.RawMessage `json:"data"`
+	Links struct {
+		Next string `json:"next"`
+	} `json:"links"`
+}
+
+// newTerraformTarget Get the Terraform Cloud target chosen by flags.
+func newTerraformTarget(ac *applicationFlags, hc httpCommunicator) *terraformTarget {
+	host := strings.TrimRight(*ac.tfcHost, "/")
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	return &terraformTarget{
+		host:        host,
+		org:         *ac.tfcOrg,
+		token:       flagOrEnv(ac.tfcToken, tfcTokenEnv),
+		workspaces:  splitList(*ac.tfcWorkspaces),
+		varSets:     splitList(*ac.tfcVariableSets),
+		plan:        *ac.tfcPlan,
+		planTimeout: *ac.tfcPlanTimeout,
+		vars:        ac.ciVars("tfcVars"),
+		hc:          hc,
+	}
+}
+
+// saveToTerraformCloud Set the key and secret as sensitive environment variables on every workspace and variable set.
+func saveToTerraformCloud(ctx context.Context, creds *iam.CreateAccessKeyOutput, tt *terraformTarget) error {
+	vars, err0 := tt.vars.values(creds)
+	if err0 != nil {
+		return err0
+	}
+
+	for _, name := range tt.workspaces {
+		id, err1 := tt.workspaceId(ctx, name)
+		if err1 != nil {
+			return err1
+		}
+
+		if err := tt.setVars(ctx, "/workspaces/"+id+"/vars", vars); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range tt.varSets {
+		id, err1 := tt.varSetId(ctx, name)
+		if err1 != nil {
+			return err1
+		}
+
+		if err := tt.setVars(ctx, "/varsets/"+id+"/relationships/vars", vars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTerraformCloud Queue a plan on every workspace and wait for it to finish, when asked to, so the key is known to
+// work. The plans may take longer than a call to the API, so the wait has its own deadline.
+func checkTerraformCloud(ctx context.Context, tt *terraformTarget) error {
+	if !tt.plan {
+		return nil
+	}
+
+	if tt.planTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tt.planTimeout)
+		defer cancel()
+	}
+
+	for _, name := range tt.workspaces {
+		id, err1 := tt.workspaceId(ctx, name)
+		if err1 != nil {
+			return err1
+		}
+
+		if err := tt.runPlan(ctx, name, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// workspaceId Look up the ID of the workspace by name.
+func (tt *terraformTarget) workspaceId(ctx context.Context, name string) (string, error) {
+	doc, err1 := tt.send(ctx, "GET", tt.apiUrl("/organizations/"+url.PathEscape(tt.org)+"/workspaces/"+url.PathEscape(name)), nil)
+	if err1 != nil {
+		return "", err1
+	}
+
+	ws := &tfcResource{}
+	if err := json.Unmarshal(doc.Data, ws); err != nil {
+		return "", fmt.Errorf(errors.ciResponseInvalid, "Terraform Cloud", err)
+	}
+
+	return ws.Id, nil
+}
+
+// varSetId Look up the ID of the variable set by name.
+func (tt *terraformTarget) varSetId(ctx context.Context, name string) (string, error) {
+	var found string
+	err := tt.list(ctx, tt.apiUrl("/organizations/"+url.PathEscape(tt.org)+"/varsets?page%5Bsize%5D=100"), func(r *tfcResource) bool {
+		if r.Attributes["name"] == name {
+			found = r.Id
+		}
+		return found != ""
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if found == "" {
+		return "", fmt.Errorf(errors.tfcVarSetNotFound, name, tt.org)
+	}
+
+	return found, nil
+}
+
+// setVars Update the environment variables in the list at the path, making those that are not there.
+func (tt *terraformTarget) setVars(ctx context.Context, path string, vars map[string]string) error {
+	varIds := map[string]string{}
+	if err := tt.list(ctx, tt.apiUrl(path), func(r *tfcResource) bool {
+		key, _ := r.Attributes["key"].(string)
+		if _, ok := vars[key]; ok && r.Attributes["category"] == "env" {
+			varIds[key] = r.Id
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+
+	for key, val := range vars {
+		v := &tfcResource{Type: "vars", Attributes: map[string]interface{}{
+			"key":       key,
+			"value":     val,
+			"category":  "env",
+			"sensitive": true,
+		}}
+
+		var err error
+		if id, ok := varIds[key]; ok {
+			v.Id = id
+			_, err = tt.send(ctx, "PATCH", tt.apiUrl(path+"/"+id), v)
+		} else {
+			_, err = tt.send(ctx, "POST", tt.apiUrl(path), v)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPlan Queue a plan-only run on the workspace and wait for it to finish, it is an error when the plan does not work.
+func (tt *terraformTarget) runPlan(ctx context.Context, name, id string) error {
+	doc, err := tt.send(ctx, "POST", tt.apiUrl("/runs"), &tfcResource{
+		Type: "runs",
+		Attributes: map[string]interface{}{
+			"message":   stdMsgs.tfcPlanMessage,
+			"plan-only": true,
+		},
+		Relationships: map[string]interface{}{
+			"workspace": map[string]interface{}{"data": &tfcResource{Type: "workspaces", Id: id}},
+		},
+	})
+
+	for {
+		if err != nil {
+			return err
+		}
+
+		run := &tfcResource{}
+		if err1 := json.Unmarshal(doc.Data, run); err1 != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Terraform Cloud", err1)
+		}
+
+		status, _ := run.Attributes["status"].(string)
+		if ok, done := tfcRunDone[status]; done {
+			if !ok {
+				return fmt.Errorf(errors.tfcPlanFailed, run.Id, name, status)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(errors.tfcPlanFailed, run.Id, name, ctx.Err())
+		case <-time.After(tfcPollInterval):
+		}
+
+		doc, err = tt.send(ctx, "GET", tt.apiUrl("/runs/"+url.PathEscape(run.Id)), nil)
+	}
+}
+
+// list Walk the pages of a list until found returns true or there are no more pages.
+func (tt *terraformTarget) list(ctx context.Context, pageUrl string, found func(r *tfcResource) bool) error {
+	for pageUrl != "" {
+		doc, err1 := tt.send(ctx, "GET", pageUrl, nil)
+		if err1 != nil {
+			return err1
+		}
+
+		var page []*tfcResource
+		if err := json.Unmarshal(doc.Data, &page); err != nil {
+			return fmt.Errorf(errors.ciResponseInvalid, "Terraform Cloud", err)
+		}
+
+		for _, r := range page {
+			if found(r) {
+				return nil
+			}
+		}
+
+		pageUrl = doc.Links.Next
+	}
+
+	return nil
+}
+
+// apiUrl Get the URL of the path in the API of the host.
+func (tt *terraformTarget) apiUrl(path string) string {
+	return tt.host + "/api/v2" + path
+}
+
+// send Make a request to the API with the resource as the data of the payload, authenticating with the API token.
+func (tt *terraformTarget) send(ctx context.Context, method, reqUrl string, data *tfcResource) (*tfcDocument, error) {
+	var content []byte
+	if data != nil {
+		var err error
+		if content, err = json.Marshal(map[string]interface{}{"data": data}); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err1 := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(content))
+	if err1 != nil {
+		return nil, err1
+	}
+
+	req.Header.Add("authorization", "Bearer "+tt.token)
+	req.Header.Add("content-type", "application/vnd.api+json")
+
+	_, body, err2 := doRequest(tt.hc, "Terraform Cloud", req)
+	if err2 != nil {
+		return nil, err2
+	}
+
+	doc := &tfcDocument{}
+	if len(body) == 0 {
+		return doc, nil
+	}
+
+	if err := json.Unmarshal(body, doc); err != nil {
+		return nil, fmt.Errorf(errors.ciResponseInvalid, "Terraform Cloud", err)
+	}
+
+	return doc, nil
+}
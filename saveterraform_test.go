@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTerraformCloud Keeps variables by the path of their list, and answers plans with the given states in turn.
+type fakeTerraformCloud struct {
+	host   string
+	vars   map[string][]*tfcResource
+	states []string
+	runs   int
+	seq    int
+}
+
+func (ft *fakeTerraformCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authorization") != "Bearer t0k" || r.Header.Get("content-type") != "application/vnd.api+json" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2")
+	in := &struct{ Data *tfcResource }{}
+	_ = json.NewDecoder(r.Body).Decode(in)
+	reply := func(data interface{}, next string) {
+		doc := map[string]interface{}{"data": data}
+		if next != "" {
+			doc["links"] = map[string]string{"next": next}
+		}
+		_ = json.NewEncoder(w).Encode(doc)
+	}
+
+	switch {
+	case r.Method == "GET" && path == "/organizations/acme/workspaces/app-prod":
+		reply(&tfcResource{Id: "ws-1", Type: "workspaces"}, "")
+	case r.Method == "GET" && path == "/organizations/acme/varsets":
+		// Two pages, the set looked for is on the second.
+		if r.URL.Query().Get("page[number]") == "" {
+			reply([]*tfcResource{{Id: "varset-0", Type: "varsets", Attributes: map[string]interface{}{"name": "other"}}}, ft.host+"/api/v2/organizations/acme/varsets?page%5Bnumber%5D=2")
+			return
+		}
+		reply([]*tfcResource{{Id: "varset-1", Type: "varsets", Attributes: map[string]interface{}{"name": "aws"}}}, "")
+	case r.Method == "GET":
+		if strings.HasPrefix(path, "/runs/") {
+			reply(ft.run(), "")
+			return
+		}
+		list, ok := ft.vars[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reply(list, "")
+	case r.Method == "POST" && path == "/runs":
+		ft.runs = 0
+		reply(ft.run(), "")
+	case r.Method == "POST":
+		ft.seq++
+		in.Data.Id = fmt.Sprintf("var-%v", ft.seq)
+		ft.vars[path] = append(ft.vars[path], in.Data)
+		reply(in.Data, "")
+	case r.Method == "PATCH":
+		i := strings.LastIndex(path, "/")
+		for _, v := range ft.vars[path[:i]] {
+			if v.Id == path[i+1:] {
+				v.Attributes = in.Data.Attributes
+			}
+		}
+		reply(in.Data, "")
+	}
+}
+
+// run Get the run in its next state.
+func (ft *fakeTerraformCloud) run() *tfcResource {
+	state := ft.states[ft.runs]
+	if ft.runs < len(ft.states)-1 {
+		ft.runs++
+	}
+
+	return &tfcResource{Id: "run-1", Type: "runs", Attributes: map[string]interface{}{"status": state}}
+}
+
+func TestSaveToTerraformCloud(tester *testing.T) {
+	oldInterval := tfcPollInterval
+	tfcPollInterval = time.Millisecond
+	defer func() { tfcPollInterval = oldInterval }()
+
+	wsVars := "/workspaces/ws-1/vars"
+	setVars := "/varsets/varset-1/relationships/vars"
+
+	cases := []struct {
+		name    string
+		target  terraformTarget
+		states  []string
+		wantErr string
+	}{
+		{"workspaceAndVarSet", terraformTarget{workspaces: []string{"app-prod"}, varSets: []string{"aws"}}, nil, ""},
+		{"planWorks", terraformTarget{workspaces: []string{"app-prod"}, plan: true}, []string{"pending", "planning", "planned_and_finished"}, ""},
+		{"planFails", terraformTarget{workspaces: []string{"app-prod"}, plan: true}, []string{"planning", "errored"}, "did not finish: errored"},
+		{"planTimesOut", terraformTarget{workspaces: []string{"app-prod"}, plan: true, planTimeout: 20 * time.Millisecond}, []string{"planning"}, "context deadline exceeded"},
+		{"varSetMissing", terraformTarget{varSets: []string{"gcp"}}, nil, `no variable set "gcp"`},
+		{"workspaceMissing", terraformTarget{workspaces: []string{"app-dev"}}, nil, "status 404"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ft := &fakeTerraformCloud{states: test.states, vars: map[string][]*tfcResource{
+				wsVars: {
+					{Id: "var-old", Type: "vars", Attributes: map[string]interface{}{"key": keyVarName, "value": "AKIAOLD", "category": "env"}},
+					{Id: "var-tf", Type: "vars", Attributes: map[string]interface{}{"key": secretVarName, "value": "x", "category": "terraform"}},
+				},
+				setVars: {},
+			}}
+			srv := httptest.NewServer(ft)
+			defer srv.Close()
+			ft.host = srv.URL
+
+			tt := test.target
+			tt.host, tt.org, tt.token, tt.hc = srv.URL, "acme", "t0k", srv.Client()
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToTerraformCloud(context.TODO(), creds, &tt)
+			if err == nil {
+				err = checkTerraformCloud(context.TODO(), &tt)
+			}
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			paths := map[string]int{wsVars: 3}
+			if len(tt.varSets) > 0 {
+				paths[setVars] = 2
+			}
+
+			for path, wantLen := range paths {
+				got := map[string]string{}
+				for _, v := range ft.vars[path] {
+					if v.Attributes["category"] == "env" && v.Attributes["sensitive"] == true {
+						got[v.Attributes["key"].(string)] = v.Attributes["value"].(string)
+					}
+				}
+
+				// The env variable is updated in place, a terraform variable of the same name is left alone.
+				if len(ft.vars[path]) != wantLen || got[keyVarName] != "AKIANEW" || got[secretVarName] != "new-secret" {
+					t.Errorf("want the key set as sensitive env variables in %v, got %v", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSetVarsEmptyValue(tester *testing.T) {
+	path := "/workspaces/ws-1/vars"
+	ft := &fakeTerraformCloud{vars: map[string][]*tfcResource{
+		path: {{Id: "var-empty", Type: "vars", Attributes: map[string]interface{}{"key": "AWS_SESSION_TOKEN", "value": "", "category": "env"}}},
+	}}
+	srv := httptest.NewServer(ft)
+	defer srv.Close()
+
+	tt := &terraformTarget{host: srv.URL, org: "acme", token: "t0k", hc: srv.Client()}
+	if err := tt.setVars(context.TODO(), path, map[string]string{"AWS_SESSION_TOKEN": ""}); err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	// The variable is there, so it is updated in place rather than made again.
+	if got := len(ft.vars[path]); got != 1 {
+		tester.Errorf("want 1 variable, got %v", got)
+	}
+}
+
+func TestNewTerraformTargetEnv(tester *testing.T) {
+	tester.Setenv(tfcTokenEnv, "env-token")
+
+	empty, given := "", "flag-token"
+	cases := []struct {
+		name  string
+		token *string
+		want  string
+	}{
+		{"fromEnv", &empty, "env-token"},
+		{"fromFlag", &given, "flag-token"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ac := *appFlags
+			ac.tfcToken = test.token
+
+			if tt := newTerraformTarget(&ac, nil); tt.token != test.want {
+				t.Errorf("want %q, got %q", test.want, tt.token)
+			}
+		})
+	}
+}
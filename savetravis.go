@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// travisTokenEnv The environment variable the Travis CI API token is read from when -travisToken is not given.
+const travisTokenEnv = "TRAVIS_TOKEN"
+
+// travisTarget A repository, as owner/name, on Travis CI to keep the key in as private environment variables, and the
+// API token to update them with.
+type travisTarget struct {
+	endpoint,
+	repo,
+	token string
+	vars *ciVars
+	hc   httpCommunicator
+}
+
+// newTravisTarget Get the Travis CI target chosen by flags.
+func newTravisTarget(ac *applicationFlags, hc httpCommunicator) *travisTarget {
+	return &travisTarget{
+		endpoint: strings.TrimRight(*ac.travisEndpoint, "/"),
+		repo:     *ac.travisRepo,
+		token:    flagOrEnv(ac.travisToken, travisTokenEnv),
+		vars:     ac.ciVars("travisVars"),
+		hc:       hc,
+	}
+}
+
+// saveToTravis Set the key and secret as private environment variables of the repository, so they are hidden from build
+// logs, making those that are not there.
+func saveToTravis(ctx context.Context, creds *iam.CreateAccessKeyOutput, tt *travisTarget) error {
+	vars, err0 := tt.vars.values(creds)
+	if err0 != nil {
+		return err0
+	}
+
+	// The slug is one part of the path, so its slash is escaped too.
+	repoUrl := tt.endpoint + "/repo/" + url.PathEscape(tt.repo)
+
+	body, err1 := tt.send(ctx, "GET", repoUrl+"/env_vars", nil)
+	if err1 != nil {
+		return err1
+	}
+
+	list := &struct {
+		EnvVars []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"env_vars"`
+	}{}
+	if err := json.Unmarshal(body, list); err != nil {
+		return fmt.Errorf(errors.ciResponseInvalid, "Travis CI", err)
+	}
+
+	ids := map[string]string{}
+	for _, v := range list.EnvVars {
+		ids[v.Name] = v.Id
+	}
+
+	for name, val := range vars {
+		envVar := map[string]interface{}{"env_var.value": val, "env_var.public": false}
+
+		var err error
+		if id, ok := ids[name]; ok {
+			_, err = tt.send(ctx, "PATCH", repoUrl+"/env_var/"+url.PathEscape(id), envVar)
+		} else {
+			envVar["env_var.name"] = name
+			_, err = tt.send(ctx, "POST", repoUrl+"/env_vars", envVar)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send Make a request to version 3 of the API, authenticating with the API token.
+func (tt *travisTarget) send(ctx context.Context, method, reqUrl string, payload interface{}) ([]byte, error) {
+	var content []byte
+	if payload != nil {
+		var err error
+		if content, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err1 := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(content))
+	if err1 != nil {
+		return nil, err1
+	}
+
+	req.Header.Add("authorization", "token "+tt.token)
+	req.Header.Add("travis-api-version", "3")
+	req.Header.Add("content-type", "application/json")
+
+	_, body, err2 := doRequest(tt.hc, "Travis CI", req)
+
+	return body, err2
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeTravis Keeps the environment variables of the repo acme/app.
+type fakeTravis struct {
+	vars []map[string]interface{}
+}
+
+func (ft *fakeTravis) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authorization") != "token t0k" || r.Header.Get("travis-api-version") != "3" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	in := map[string]interface{}{}
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	// The slug is escaped as one part of the path.
+	repoPath := "/repo/acme%2Fapp"
+
+	switch {
+	case r.Method == "GET" && r.URL.EscapedPath() == repoPath+"/env_vars":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"env_vars": ft.vars})
+	case r.Method == "POST" && r.URL.EscapedPath() == repoPath+"/env_vars":
+		ft.vars = append(ft.vars, map[string]interface{}{
+			"id":     fmt.Sprintf("v%v", len(ft.vars)+1),
+			"name":   in["env_var.name"],
+			"value":  in["env_var.value"],
+			"public": in["env_var.public"],
+		})
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PATCH" && strings.HasPrefix(r.URL.EscapedPath(), repoPath+"/env_var/"):
+		for _, v := range ft.vars {
+			if v["id"] == strings.TrimPrefix(r.URL.EscapedPath(), repoPath+"/env_var/") {
+				v["value"], v["public"] = in["env_var.value"], in["env_var.public"]
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSaveToTravis(tester *testing.T) {
+	cases := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"saved", "t0k", ""},
+		{"forbidden", "wrong", "status 403"},
+	}
+
+	for _, test := range cases {
+		tester.Run(test.name, func(t *testing.T) {
+			ft := &fakeTravis{vars: []map[string]interface{}{
+				{"id": "v1", "name": "DEPLOY_KEY_ID", "value": "AKIAOLD", "public": true},
+				{"id": "v2", "name": "OTHER", "value": "x", "public": true},
+			}}
+			srv := httptest.NewServer(ft)
+			defer srv.Close()
+
+			tt := &travisTarget{endpoint: srv.URL, repo: "acme/app", token: test.token, vars: mustCiVars("DEPLOY_KEY_ID=key,DEPLOY_SECRET=secret"), hc: srv.Client()}
+			creds := &iam.CreateAccessKeyOutput{AccessKey: &types.AccessKey{
+				AccessKeyId:     aws.String("AKIANEW"),
+				SecretAccessKey: aws.String("new-secret"),
+			}}
+
+			err := saveToTravis(context.TODO(), creds, tt)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := fmt.Sprint([]map[string]interface{}{
+				{"id": "v1", "name": "DEPLOY_KEY_ID", "value": "AKIANEW", "public": false},
+				{"id": "v2", "name": "OTHER", "value": "x", "public": true},
+				{"id": "v3", "name": "DEPLOY_SECRET", "value": "new-secret", "public": false},
+			})
+			if got := fmt.Sprint(ft.vars); got != want {
+				t.Errorf("want variables %v, got %v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+)
+
+// rotateServiceCredential Rotate the service-specific credentials of the user signed in for the service named by
+// -serviceName, with the same policy as access keys. The service user name and password of a new credential are saved
+// to the storage targets in place of a key ID and secret. Indicates whether a new credential was made.
+func rotateServiceCredential(ctx context.Context, ac *applicationFlags) (bool, error) {
+	if err := ac.check(); err != nil {
+		return false, err
+	}
+
+	// An SMTP password is derived from the secret of an access key, not the password of a service.
+	if ac.sesSmtp() != nil {
+		return false, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.sesSmtpNotForService, "rotate-service-credential"))
+	}
+
+	// A reset keeps the date the credential was made, so when it was reset is kept in the tags of the user.
+	if *ac.serviceReset && !*ac.tagUser {
+		return false, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.serviceResetNeedsTags))
+	}
+
+	res, err := runRotation(ctx, ac, credentialRotation{
+		options: func(o *rotator.Options, iamClient *iam.Client) {
+			o.Services = iamClient
+			if auditTrail != nil {
+				o.Services = &auditedServiceClient{iamClient, auditTrail}
+			}
+			o.ResetServiceCredential = *ac.serviceReset
+			o.Tagger = newTagger(ac, newTagClient(iamClient))
+			o.Stores = newStores(ac, httpComm, false)
+		},
+		rotate: func(ctx context.Context, r *rotator.Rotator) (*rotator.Result, error) {
+			return r.RotateServiceCredential(ctx, *ac.serviceName)
+		},
+		newId: func(res *rotator.Result) string {
+			return aws.ToString(res.NewServiceCredential.ServiceSpecificCredentialId)
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if res.Rotated() {
+		appLog.Info(fmt.Sprintf(stdMsgs.serviceCredentialRotated, *ac.serviceName), "credential_id", aws.ToString(res.NewServiceCredential.ServiceSpecificCredentialId), "service_user_name", aws.ToString(res.NewServiceCredential.ServiceUserName))
+	}
+
+	return res.Rotated(), nil
+}
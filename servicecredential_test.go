@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRotateServiceCredentialWithFakeIam(tester *testing.T) {
+	keyFile := testTmp + "/fake-iam-service-credential.json"
+	base := []string{"-region", "us-east-1", "-detailedExitCodes"}
+
+	var tests = []struct {
+		name     string
+		fail     string
+		args     []string
+		wantCode int
+		wantUser string
+	}{
+		{"nothingToDo", "", []string{"-maxDaysAllowed", "90"}, exitNothingToDo, ""},
+		{"rotated", "", []string{}, exitRotated, "bob-at-123456789012"},
+		{"reset", "", []string{"-serviceReset"}, exitRotated, "bob-at-123456789012"},
+		{"noCredential", "", []string{"-serviceName", "cassandra.amazonaws.com"}, exitFailed, ""},
+		{"quotaReached", "CreateServiceSpecificCredential=LimitExceeded", []string{}, exitQuotaReached, ""},
+		{"storageFailed", "", []string{"-filename", testTmp}, exitStorageFailed, ""},
+		{"resetNotSaved", "", []string{"-serviceReset", "-filename", testTmp}, exitRollbackNeeded, ""},
+		{"resetUntagged", "", []string{"-serviceReset", "-tagUser=false"}, exitConfigInvalid, ""},
+	}
+
+	for _, test := range tests {
+		tester.Run(test.name, func(t *testing.T) {
+			_ = os.Remove(keyFile)
+
+			args := append(append([]string{}, base...), "-filename", keyFile)
+			cmd := getTestBinCmd(append(append(args, test.args...), "rotate-service-credential"))
+			cmd.Env = append(cmd.Env, fakeIamEnv+"="+test.fail)
+
+			cmdOut, cmdErr := cmd.CombinedOutput()
+
+			got := cmd.ProcessState.ExitCode()
+			if got != test.wantCode {
+				showCmdOutput(cmdOut, cmdErr)
+				t.Fatalf("want exit code %v, got %v", test.wantCode, got)
+			}
+
+			if test.wantUser == "" {
+				return
+			}
+
+			content, _ := ioutil.ReadFile(keyFile)
+			kp := awsKeyPair{}
+			_ = json.Unmarshal(content, &kp)
+			if kp.Id != test.wantUser || kp.Key == "" {
+				t.Errorf("want the service user name %v and a password in the key file, got %s", test.wantUser, content)
+			}
+		})
+	}
+}
+
+func TestRotateServiceCredentialAudited(tester *testing.T) {
+	keyFile, auditFile := testTmp+"/fake-iam-service-credential-audited.json", testTmp+"/fake-iam-service-audit.jsonl"
+	_ = os.Remove(auditFile)
+
+	cmd := getTestBinCmd([]string{"-region", "us-east-1", "-filename", keyFile, "-auditLog", auditFile, "-detailedExitCodes", "rotate-service-credential"})
+	cmd.Env = append(cmd.Env, fakeIamEnv+"=")
+
+	cmdOut, cmdErr := cmd.CombinedOutput()
+	if got := cmd.ProcessState.ExitCode(); got != exitRotated {
+		showCmdOutput(cmdOut, cmdErr)
+		tester.Fatalf("want exit code %v, got %v", exitRotated, got)
+	}
+
+	entries, err := readAuditEntries(auditFile)
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, e.Action)
+	}
+
+	want := "iam:CreateServiceSpecificCredential store:file iam:DeleteServiceSpecificCredential"
+	if strings.Join(got, " ") != want {
+		tester.Errorf("want actions %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	smtpUserVarName     = "SMTP_USERNAME"
+	smtpPasswordVarName = "SMTP_PASSWORD"
+)
+
+// sesRegionRe An AWS region name, such as us-east-1.
+var sesRegionRe = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// sesSmtp The SES regions to derive SMTP passwords for from a new key, and whether they are saved in place of the secret.
+type sesSmtp struct {
+	regions []string
+	only    bool
+}
+
+// envVar A variable written to a key file or saved to a CI service.
+type envVar struct {
+	name,
+	value string
+}
+
+// sesSmtpPassword Derive the password to send mail through the SES SMTP endpoint of a region from a secret access key,
+// with the same algorithm as the AWS documentation. The key ID is the SMTP user name.
+func sesSmtpPassword(secret, region string) string {
+	sig := []byte("AWS4" + secret)
+	for _, msg := range []string{"11111111", region, "ses", "aws4_request", "SendRawEmail"} {
+		h := hmac.New(sha256.New, sig)
+		h.Write([]byte(msg))
+		sig = h.Sum(nil)
+	}
+
+	return base64.StdEncoding.EncodeToString(append([]byte{0x04}, sig...))
+}
+
+// passwords Get the SMTP password for every region, by region.
+func (s *sesSmtp) passwords(secret string) map[string]string {
+	pws := make(map[string]string, len(s.regions))
+	for _, region := range s.regions {
+		pws[region] = sesSmtpPassword(secret, region)
+	}
+
+	return pws
+}
+
+// newKeyPair Get what is written to the key file for a new key, with the SMTP passwords when smtp is not nil, and
+// without the secret when only they are wanted.
+func newKeyPair(key *types.AccessKey, smtp *sesSmtp) awsKeyPair {
+	nk := awsKeyPair{
+		Id:       aws.ToString(key.AccessKeyId),
+		Key:      aws.ToString(key.SecretAccessKey),
+		Username: aws.ToString(key.UserName),
+	}
+
+	if smtp == nil {
+		return nk
+	}
+
+	nk.SMTPUsername, nk.SMTPPasswords = nk.Id, smtp.passwords(nk.Key)
+	if smtp.only {
+		nk.Key = ""
+	}
+
+	return nk
+}
+
+// envVars Get the variables that hold the key pair, the key ID and secret as keyVarName and secretVarName, then the
+// SMTP user name and passwords when there are any. A single password is smtpPasswordVarName, more have the region
+// added to the name, such as SMTP_PASSWORD_EU_WEST_1.
+func (nk awsKeyPair) envVars() []envVar {
+	var vars []envVar
+	if nk.Key != "" {
+		vars = append(vars, envVar{keyVarName, nk.Id}, envVar{secretVarName, nk.Key})
+	}
+
+	if nk.SMTPUsername == "" {
+		return vars
+	}
+
+	vars = append(vars, envVar{smtpUserVarName, nk.SMTPUsername})
+
+	regions := make([]string, 0, len(nk.SMTPPasswords))
+	for region := range nk.SMTPPasswords {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		name := smtpPasswordVarName
+		if len(regions) > 1 {
+			name += "_" + strings.ToUpper(strings.ReplaceAll(region, "-", "_"))
+		}
+		vars = append(vars, envVar{name, nk.SMTPPasswords[region]})
+	}
+
+	return vars
+}
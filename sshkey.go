@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+)
+
+// publicKeyFileMode Anyone may read the public key file, as ssh-keygen leaves it.
+const publicKeyFileMode = 0644
+
+// sshKeyFile A storage target for a new SSH key pair, the private key is written to a local file and the public key
+// next to it, with .pub added to the name.
+type sshKeyFile struct {
+	filename,
+	ageRecipients string
+}
+
+func (sf *sshKeyFile) Name() string {
+	return "file"
+}
+
+// SaveSSHKey Write the key pair, the private key encrypted to the age recipients when any are given.
+func (sf *sshKeyFile) SaveSSHKey(ctx context.Context, key *rotator.SSHKey) error {
+	return recordStorage(sf.Name(), key.Id, func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		content := key.PrivateKey
+		if sf.ageRecipients != "" {
+			encrypted, err := encryptForRecipients(content, sf.ageRecipients)
+			if err != nil {
+				return err
+			}
+			content = encrypted
+		}
+
+		if err := writeFileAtomic(sf.filename, content, keyFileMode); err != nil {
+			return fmt.Errorf(errors.writingSSHKeyErr, err.Error())
+		}
+
+		if err := writeFileAtomic(sf.filename+".pub", []byte(key.PublicKey+"\n"), publicKeyFileMode); err != nil {
+			return fmt.Errorf(errors.writingSSHKeyErr, err.Error())
+		}
+
+		return nil
+	})
+}
+
+// rotateSSHKey Rotate the SSH public keys of the user signed in, such as those used for CodeCommit, with the same policy
+// as access keys. The private key of a new key pair is saved to the file named by -sshKeyFile. Indicates whether a new
+// key pair was made.
+func rotateSSHKey(ctx context.Context, ac *applicationFlags) (bool, error) {
+	if err := ac.check(); err != nil {
+		return false, err
+	}
+
+	if *ac.sshKeyType != rotator.SSHKeyRSA && *ac.sshKeyType != rotator.SSHKeyED25519 {
+		return false, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.sshKeyTypeInvalid, *ac.sshKeyType))
+	}
+
+	res, err := runRotation(ctx, ac, credentialRotation{
+		options: func(o *rotator.Options, iamClient *iam.Client) {
+			o.SSH = iamClient
+			if auditTrail != nil {
+				o.SSH = &auditedSSHClient{iamClient, auditTrail}
+			}
+			o.SSHStores = []rotator.SSHStore{&sshKeyFile{*ac.sshKeyFile, *ac.ageRecipient}}
+			o.SSHKeyType = *ac.sshKeyType
+		},
+		rotate: func(ctx context.Context, r *rotator.Rotator) (*rotator.Result, error) {
+			return r.RotateSSHKeys(ctx)
+		},
+		newId: func(res *rotator.Result) string {
+			return res.NewSSHKey.Id
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if res.Rotated() {
+		appLog.Info(stdMsgs.sshKeyRotated, "ssh_key_id", res.NewSSHKey.Id, "fingerprint", res.NewSSHKey.Fingerprint, "file", *ac.sshKeyFile)
+	}
+
+	return res.Rotated(), nil
+}
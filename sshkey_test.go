@@ -0,0 +1,96 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRotateSSHKeyWithFakeIam(tester *testing.T) {
+	keyFile := testTmp + "/fake-iam-ssh-key"
+	base := []string{"-region", "us-east-1", "-detailedExitCodes"}
+
+	var tests = []struct {
+		name     string
+		fail     string
+		args     []string
+		wantCode int
+		wantKey  bool
+	}{
+		{"nothingToDo", "", []string{"-maxDaysAllowed", "90"}, exitNothingToDo, false},
+		{"rotated", "", []string{"-sshKeyType", "ed25519"}, exitRotated, true},
+		{"keyTypeInvalid", "", []string{"-sshKeyType", "dsa"}, exitConfigInvalid, false},
+		{"authFailed", "UploadSSHPublicKey=AccessDenied", []string{"-sshKeyType", "ed25519"}, exitAuthFailed, false},
+		{"storageFailed", "", []string{"-sshKeyType", "ed25519", "-sshKeyFile", testTmp}, exitStorageFailed, false},
+	}
+
+	for _, test := range tests {
+		tester.Run(test.name, func(t *testing.T) {
+			_ = os.Remove(keyFile)
+			_ = os.Remove(keyFile + ".pub")
+
+			args := append(append([]string{}, base...), "-sshKeyFile", keyFile)
+			cmd := getTestBinCmd(append(append(args, test.args...), "rotate-ssh-key"))
+			cmd.Env = append(cmd.Env, fakeIamEnv+"="+test.fail)
+
+			cmdOut, cmdErr := cmd.CombinedOutput()
+
+			got := cmd.ProcessState.ExitCode()
+			if got != test.wantCode {
+				showCmdOutput(cmdOut, cmdErr)
+				t.Fatalf("want exit code %v, got %v", test.wantCode, got)
+			}
+
+			if !test.wantKey {
+				return
+			}
+
+			private, _ := ioutil.ReadFile(keyFile)
+			signer, err1 := ssh.ParsePrivateKey(private)
+			if err1 != nil {
+				t.Fatalf("want an OpenSSH private key in the key file, got %v", err1)
+			}
+
+			public, _ := ioutil.ReadFile(keyFile + ".pub")
+			pub, _, _, _, err2 := ssh.ParseAuthorizedKey(public)
+			if err2 != nil || string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+				t.Errorf("want the matching public key next to the key file, got %q", public)
+			}
+
+			if fi, err := os.Stat(keyFile); err != nil || fi.Mode().Perm() != keyFileMode {
+				t.Errorf("want the key file readable only by its owner, got %v", fi.Mode())
+			}
+		})
+	}
+}
+
+func TestRotateSSHKeyAudited(tester *testing.T) {
+	keyFile, auditFile := testTmp+"/fake-iam-ssh-key-audited", testTmp+"/fake-iam-ssh-audit.jsonl"
+	_ = os.Remove(auditFile)
+
+	cmd := getTestBinCmd([]string{"-region", "us-east-1", "-sshKeyType", "ed25519", "-sshKeyFile", keyFile, "-auditLog", auditFile, "-detailedExitCodes", "rotate-ssh-key"})
+	cmd.Env = append(cmd.Env, fakeIamEnv+"=")
+
+	cmdOut, cmdErr := cmd.CombinedOutput()
+	if got := cmd.ProcessState.ExitCode(); got != exitRotated {
+		showCmdOutput(cmdOut, cmdErr)
+		tester.Fatalf("want exit code %v, got %v", exitRotated, got)
+	}
+
+	entries, err := readAuditEntries(auditFile)
+	if err != nil {
+		tester.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		got = append(got, e.Action)
+	}
+
+	want := "iam:UploadSSHPublicKey store:file iam:UpdateSSHPublicKey:Inactive iam:DeleteSSHPublicKey"
+	if strings.Join(got, " ") != want {
+		tester.Errorf("want actions %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+)
+
+// keyStore A storage target for new keys, recording metrics and an audit entry for every write.
+type keyStore struct {
+	name string
+	save func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error
+}
+
+func (ks *keyStore) Name() string {
+	return ks.name
+}
+
+func (ks *keyStore) Save(ctx context.Context, key *types.AccessKey) error {
+	creds := &iam.CreateAccessKeyOutput{AccessKey: key}
+
+	return recordStorage(ks.name, aws.ToString(key.AccessKeyId), func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return ks.save(ctx, creds)
+	})
+}
+
+// checkedKeyStore A storage target that also checks the key works once it is saved.
+type checkedKeyStore struct {
+	*keyStore
+	check func(ctx context.Context) error
+}
+
+func (cs *checkedKeyStore) Check(ctx context.Context, key *types.AccessKey) error {
+	return cs.check(ctx)
+}
+
+// newStores Get the storage targets chosen by flags. The key is always saved to a local file first, then to the
+// variables or credentials of every CI service chosen, or else to the local profile when toProfile is set.
+func newStores(ac *applicationFlags, hc httpCommunicator, toProfile bool) []rotator.Store {
+	stores := []rotator.Store{
+		&keyStore{"file", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToFile(creds, *ac.filename, *ac.fileFormat, *ac.ageRecipient, ac.sesSmtp())
+		}},
+	}
+
+	if *ac.circleci != "" {
+		stores = append(stores, &keyStore{"circleci", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToCircleContext(ctx, creds, *ac.circleciContext, *ac.circleci, ac.ciVars("circleciVars"), hc)
+		}})
+	}
+
+	if *ac.bitbucketWorkspace != "" {
+		bt := newBitbucketTarget(ac, hc)
+		stores = append(stores, &keyStore{"bitbucket", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToBitbucket(ctx, creds, bt)
+		}})
+	}
+
+	if *ac.azureVariableGroup != "" {
+		at := newAzureDevOpsTarget(ac, hc)
+		stores = append(stores, &keyStore{"azuredevops", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToAzureDevOps(ctx, creds, at)
+		}})
+	}
+
+	if *ac.jenkinsUrl != "" {
+		jt := newJenkinsTarget(ac, hc)
+		stores = append(stores, &keyStore{"jenkins", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToJenkins(ctx, creds, jt)
+		}})
+	}
+
+	if *ac.tfcWorkspaces != "" || *ac.tfcVariableSets != "" {
+		tt := newTerraformTarget(ac, hc)
+		ks := &keyStore{"terraform", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToTerraformCloud(ctx, creds, tt)
+		}}
+		stores = append(stores, &checkedKeyStore{ks, func(ctx context.Context) error {
+			return checkTerraformCloud(ctx, tt)
+		}})
+	}
+
+	if *ac.buildkiteOrg != "" {
+		bt := newBuildkiteTarget(ac, hc)
+		stores = append(stores, &keyStore{"buildkite", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToBuildkite(ctx, creds, bt)
+		}})
+	}
+
+	if *ac.droneServer != "" {
+		dt := newDroneTarget(ac, hc)
+		stores = append(stores, &keyStore{"drone", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToDrone(ctx, creds, dt)
+		}})
+	}
+
+	if *ac.travisRepo != "" {
+		tt := newTravisTarget(ac, hc)
+		stores = append(stores, &keyStore{"travis", func(ctx context.Context, creds *iam.CreateAccessKeyOutput) error {
+			return saveToTravis(ctx, creds, tt)
+		}})
+	}
+
+	if len(stores) == 1 && toProfile {
+		stores = append(stores, &keyStore{"profile", saveToLocalProfile})
+	}
+
+	return stores
+}
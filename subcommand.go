@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/kohirens/iam-user-key-rotator/rotator"
+	"os"
+	"strings"
+)
+
+// runSubcommand Run a subcommand given as the first argument after the flags, for example `audit verify`. Indicates
+// whether a new key was made.
+func runSubcommand(ctx context.Context, args []string, ac *applicationFlags) (bool, error) {
+	switch strings.Join(args, " ") {
+	case "audit verify":
+		if *ac.auditLog == "" {
+			return false, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.auditLogMissing))
+		}
+
+		n, err := verifyAuditLog(*ac.auditLog, auditKey(ac))
+		if err != nil {
+			return false, err
+		}
+
+		appLog.Info(stdMsgs.auditVerified, "file", *ac.auditLog, "entries", n)
+
+		return false, nil
+	case "credential-process":
+		return false, runCredentialProcess(ctx, ac, os.Stdout)
+	case "decrypt-backup":
+		return false, decryptBackup(*ac.filename, *ac.ageIdentity, os.Stdout)
+	case "rotate-service-credential":
+		return rotateServiceCredential(ctx, ac)
+	case "rotate-ssh-key":
+		return rotateSSHKey(ctx, ac)
+	default:
+		return false, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.unknownSubcommand, strings.Join(args, " ")))
+	}
+}
+
+// credentialRotation How a subcommand rotates a kind of credential of the user signed in.
+type credentialRotation struct {
+	// options Set the options for the kind of credential, on top of those the flags set.
+	options func(o *rotator.Options, iamClient *iam.Client)
+	// rotate Run the rotation.
+	rotate func(ctx context.Context, r *rotator.Rotator) (*rotator.Result, error)
+	// newId Get the ID of the credential that was made from the result.
+	newId func(res *rotator.Result) string
+}
+
+// runRotation Rotate a kind of credential of the user signed in, with the options the flags set, and send the notices
+// for what it did.
+func runRotation(ctx context.Context, ac *applicationFlags, cr credentialRotation) (*rotator.Result, error) {
+	ctx, cancel := withTimeout(ctx, *ac.timeout)
+	defer cancel()
+
+	awsConfig, err1 := getAwsConfig(ctx, ac)
+	if err1 != nil {
+		return nil, withKind(rotator.ErrConfigInvalid, fmt.Errorf(errors.awsConfigErr, err1))
+	}
+
+	iamClient := newIamClient(awsConfig)
+	notices := newNotifications(ac, httpComm, iamClient)
+
+	// These calls need the name of the user, which access key calls do not.
+	callCtx, cancelCall := withTimeout(ctx, *ac.callTimeout)
+	defer cancelCall()
+
+	guo, err2 := iamClient.GetUser(callCtx, &iam.GetUserInput{})
+	if err2 != nil {
+		return nil, withKind(rotator.ErrAuthFailed, fmt.Errorf(errors.userNameErr, err2))
+	}
+	user := aws.ToString(guo.User.UserName)
+
+	// Record every change made to IAM, and every store written, when asked to.
+	if err := openAuditTrail(callCtx, ac, awsConfig); err != nil {
+		return nil, err
+	}
+
+	opts := rotatorOptions(ac, iamClient)
+	opts.UserName = user
+	cr.options(&opts, iamClient)
+
+	r, err3 := rotator.New(opts)
+	if err3 != nil {
+		return nil, err3
+	}
+
+	res, err4 := cr.rotate(ctx, r)
+	recordStages(res.Stages)
+
+	newId := ""
+	if res.Rotated() || res.RolledBack {
+		newId = cr.newId(res)
+	}
+	notifyResult(notices, ac, res, err4, newId)
+
+	if err4 != nil {
+		notices.send(&rotationEvent{Event: eventFailure, User: user, Message: err4.Error()})
+		return nil, err4
+	}
+
+	return res, nil
+}